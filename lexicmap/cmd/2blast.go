@@ -42,6 +42,14 @@ with a tabular key-value mapping file.
 Input:
    - Output of 'lexicmap search' with the flag -a/--all.
 
+Output:
+   - BLAST-style pairwise alignment text by default.
+   - User-selected columns like BLAST tabular format (-outfmt 6) with --outfmt,
+     e.g., "6 qseqid sseqid pident length mismatch gapopen qstart qend sstart send".
+     See "lexicmap search -h" for available fields. Here, -a/--all is only needed
+     for the search output if some fields (mismatch, gapopen, nident, cigar, qseq, sseq, align)
+     are selected.
+
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		opt := getOptions(cmd)
@@ -62,6 +70,12 @@ Input:
 		kvFileGenome := getFlagString(cmd, "kv-file-genome")
 		ignoreCase := getFlagBool(cmd, "ignore-case")
 
+		var outFmt *OutFmt
+		if outFmtS := getFlagString(cmd, "outfmt"); outFmtS != "" {
+			outFmt, err = ParseOutFmt(outFmtS)
+			checkError(err)
+		}
+
 		hasKVSeq := kvFileSeq != ""
 		hasKVGenome := kvFileGenome != ""
 
@@ -104,13 +118,29 @@ Input:
 		var line string
 		var scanner *bufio.Scanner
 
-		ncols := 21
-		items := make([]string, ncols)
+		// columns needed for the output
+		required := make([]int, 0, nColsTotal)
+		for i := colQuery; i <= colSlen; i++ {
+			required = append(required, i)
+		}
+		if outFmt == nil || outFmt.NeedAll {
+			for i := colCigar; i <= colAlign; i++ {
+				required = append(required, i)
+			}
+		}
+		if outFmt != nil && outFmt.NeedLongRead {
+			for i := colSegment; i <= colBkpStrand; i++ {
+				required = append(required, i)
+			}
+		}
+		rp := newSearchResultParser(required...)
+		items := make([]string, nColsTotal)
+		var ok bool
 
 		var query, qlen, hits, sgenome, sseqid, qcovGnm, hsp, qcovHSP, alenHSP, pident, gaps, qstart, qend, sstart, send, sstr, slen string
+		var evalue, bitscore string
 		var cigar, qseq, sseq, align string
 
-		var headerLine bool
 		var iGenome, iSeq int
 		var preQuery, preGenome, preSeq string
 		var rows, i, j, end int
@@ -128,7 +158,7 @@ Input:
 			fh, err = xopen.Ropen(file)
 			checkError(err)
 
-			headerLine = true
+			rp.Reset(file)
 
 			scanner = bufio.NewScanner(fh)
 			scanner.Buffer(buf, int(bufferSize))
@@ -137,37 +167,43 @@ Input:
 				if line == "" {
 					continue
 				}
-				if headerLine {
-					headerLine = false
+				ok, err = rp.Parse(line)
+				checkError(err)
+				if !ok {
 					continue
 				}
+				for i = range items {
+					items[i] = rp.Field(i)
+				}
 
-				stringSplitNByByte(line, '\t', ncols, &items)
-				if len(items) < ncols {
-					checkError(fmt.Errorf("the input has only %d columns, did you forgot to add -a/--all for 'lexicmap search'?", len(items)))
+				if outFmt != nil {
+					outFmt.Write(outfh, items)
+					continue
 				}
 
-				query = items[0]
-				qlen = items[1]
-				hits = items[2]
-				sgenome = items[3]
-				sseqid = items[4]
-				qcovGnm = items[5]
-				hsp = items[6]
-				qcovHSP = items[7]
-				alenHSP = items[8]
-				pident = items[9]
-				gaps = items[10]
-				qstart = items[11]
-				qend = items[12]
-				sstart = items[13]
-				send = items[14]
-				sstr = items[15]
-				slen = items[16]
-				cigar = items[17]
-				qseq = items[18]
-				sseq = items[19]
-				align = items[20]
+				query = items[colQuery]
+				qlen = items[colQlen]
+				hits = items[colHits]
+				sgenome = items[colSgenome]
+				sseqid = items[colSseqid]
+				qcovGnm = items[colQcovGnm]
+				hsp = items[colHSP]
+				qcovHSP = items[colQcovHSP]
+				alenHSP = items[colAlenHSP]
+				pident = items[colPident]
+				gaps = items[colGaps]
+				qstart = items[colQstart]
+				qend = items[colQend]
+				sstart = items[colSstart]
+				send = items[colSend]
+				sstr = items[colSstr]
+				slen = items[colSlen]
+				evalue = items[colEvalue]
+				bitscore = items[colBitscore]
+				cigar = items[colCigar]
+				qseq = items[colQseq]
+				sseq = items[colSseq]
+				align = items[colAlign]
 
 				_qstart, _ = strconv.Atoi(qstart)
 				_qend, _ = strconv.Atoi(qend)
@@ -222,6 +258,7 @@ Input:
 				}
 
 				fmt.Fprintf(outfh, " HSP #%s\n", hsp)
+				fmt.Fprintf(outfh, " Score = %s bits, Expect = %s\n", bitscore, evalue)
 				fmt.Fprintf(outfh, " Query coverage per seq = %s%%, Aligned length = %s, Identities = %s%%, Gaps = %s\n",
 					qcovHSP, alenHSP, pident, gaps)
 				fmt.Fprintf(outfh, " Query range = %s-%s, Subject range = %s-%s, Strand = Plus/%s\n\n",
@@ -296,6 +333,11 @@ func init() {
 	toBlastCmd.Flags().StringP("kv-file-genome", "g", "",
 		formatFlagUsage(`Two-column tabular file for mapping the target genome ID (sgenome) to the corresponding value`))
 
+	toBlastCmd.Flags().StringP("outfmt", "", "",
+		formatFlagUsage(`Output user-selected columns like BLAST tabular format (-outfmt 6), `+
+			`e.g., "6 qseqid sseqid pident length mismatch gapopen qstart qend sstart send". `+
+			`A single "6" outputs the standard BLAST fields.`))
+
 	toBlastCmd.SetUsageTemplate(usageTemplate(""))
 }
 
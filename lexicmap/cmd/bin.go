@@ -4,12 +4,14 @@ package cmd
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"regexp"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 	"unsafe"
@@ -18,17 +20,13 @@ import (
 	"github.com/shenwei356/bio/seqio/fastx"
 	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
+	"github.com/zeebo/wyhash"
 )
 
-const ShortHeader string = "query\tqlen\thits\tsgenome\tsseqid\tqcovGnm\thsp\tqcovHSP\talenHSP\tpident\tgaps\tqstart\tqend\tsstart\tsend\tsstr\tslen"
-const LongHeader string = "query\tqlen\thits\tsgenome\tsseqid\tqcovGnm\thsp\tqcovHSP\talenHSP\tpident\tgaps\tqstart\tqend\tsstart\tsend\tsstr\tslen\tcigar\tqseq\tsseq\talign"
 const UnspecifiedBin = "NotMapped"
 const UniqueBinned string = "Unique"
 const AllBinned string = "All"
 
-var FastaList []string = []string{".fasta", ".fna", ".fa"}
-var FastqList []string = []string{".fastq", ".fq"}
-
 var binCmd = &cobra.Command{
 	Use:   "bin",
 	Short: "Bin input sequences based on the output of search.",
@@ -37,7 +35,9 @@ var binCmd = &cobra.Command{
 Previously mapped sequences can go be placed into file in which the sequence is put into a file for each genome it maps too, or
 into a folder containing each read it is mapped too along with a separate folder containing only reads that uniquely map to one genome.
 
-Output files can be gzipped depending on the input format. e.g. if the input file is gzipped, output files will be gzipped too.
+Output files are compressed in the same format as the input files, e.g., if the input file is gzipped,
+output files will be gzipped too. FASTA and FASTQ records are written into files with suffixes of
+.fasta and .fastq, respectively, so input files of mixed formats are supported.
 
 Output Format:
 
@@ -57,12 +57,56 @@ Output Directory
    ├── Genome1.sequences
    ├── Genome2.sequences
 
+Paired-end reads (--paired):
+  1. Files of read 1 and read 2 are given in pairs, e.g., A_R1.fq.gz A_R2.fq.gz B_R1.fq.gz B_R2.fq.gz.
+  2. Mates are matched by read IDs, with comments and suffixes "/1" and "/2" stripped.
+  3. A pair is binned to a genome if either mate (--pair-mode either) or both mates (--pair-mode both)
+     hit the genome. The score of a genome is the sum of those of the two mates.
+  4. Mates are kept synchronized and written into files with suffixes _R1 and _R2,
+     e.g., Genome1_R1.fastq.gz and Genome1_R2.fastq.gz.
+
+Input:
+  Records of the report are read in a streaming way and merge-joined with reads in one pass,
+  so the memory does not grow with the number of reads. Therefore, records of the report should
+  be in the same order as the reads, e.g., the output of "lexicmap search --keep-order" with
+  the same input files, or both the report and reads are sorted by query ID.
+  It stops with an error once a record of a read already passed is found.
+  Reads absent in the report are binned as unmapped reads (` + UnspecifiedBin + `).
+
+  Reads can also be binned directly during searching with "lexicmap search --bin-dir".
+
+Filters and strategies:
+  1. Only HSPs passing the filters (-i/--min-pident, -q/--min-qcov-hsp, and -Q/--min-qcov-genome) are used.
+     Reads without any HSP left are binned as unmapped reads.
+  2. The score of a genome is the bit score of its best HSP, or the sum of those of the two mates for
+     paired-end reads, so the column bitscore is needed in the report for the strategies best and
+     best-ties. Reads are assigned with one of the strategies (-s/--strategy):
+       all,        all genomes.
+       best,       the genome with the highest score, the first one is chosen for ties.
+       best-ties,  all genomes with the highest score.
+       lca,        the lowest common ancestor of lineages of all genomes (-m/--bin-map needed),
+                   i.e., reads are binned to the last taxon of the common lineage.
+                   Reads without a common ancestor are binned as ` + AmbiguousBin + `.
+  3. With a genome-to-bin mapping file (-m/--bin-map), reads are binned by bins (e.g., species)
+     rather than genomes. It's a tabular file with genome IDs in the first column, e.g.,
+         GCF_000017205.1    Pseudomonas aeruginosa
+     The bins could also be lineages separated by semicolons or tabs, where the last taxon is used
+     as the bin, and lineages are needed for the lca strategy, e.g.,
+         GCF_000017205.1    Bacteria;Pseudomonadota;Gammaproteobacteria;...;Pseudomonas aeruginosa
+     Genomes absent in the file are binned by their own IDs.
+  4. Slashes and backslashes in bin names are replaced with underscores in output file names.
+
 Example commands:
 
-$ lexicmap utils bin -r report.tsv -o /tmp/outputs ~/input.fastx
+$ lexicmap search -d db.lmi --keep-order input.fastq.gz -o report.tsv
+$ lexicmap utils bin -r report.tsv -o /tmp/outputs input.fastq.gz
 
 Bin reads but do not make seperate output for reads uniquely binned.
-$ lexicmap utils bin -r report.tsv -u -o /tmp/outputs ~/input.fastx
+$ lexicmap utils bin -r report.tsv -u=false -o /tmp/outputs input.fastq.gz
+
+Bin paired-end reads, with mates kept synchronized in _R1 and _R2 files of the same bins.
+$ lexicmap search -d db.lmi --keep-order --paired R1.fq.gz R2.fq.gz -o report.tsv
+$ lexicmap utils bin -r report.tsv --paired -o /tmp/outputs R1.fq.gz R2.fq.gz
 
 `,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -97,7 +141,43 @@ $ lexicmap utils bin -r report.tsv -u -o /tmp/outputs ~/input.fastx
 			checkError(fmt.Errorf("value of buffer size. supported unit: K, M, G"))
 		}
 
+		var err error
+
+		paired := getFlagBool(cmd, "paired")
+
+		assigner := &BinAssigner{
+			MinPident:     getFlagNonNegativeFloat64(cmd, "min-pident"),
+			MinQcovHSP:    getFlagNonNegativeFloat64(cmd, "min-qcov-hsp"),
+			MinQcovGenome: getFlagNonNegativeFloat64(cmd, "min-qcov-genome"),
+			Strategy:      getFlagString(cmd, "strategy"),
+		}
+		if !slices.Contains(BinStrategies, assigner.Strategy) {
+			checkError(fmt.Errorf("invalid value of flag -s/--strategy: %s. available values: %s",
+				assigner.Strategy, strings.Join(BinStrategies, ", ")))
+		}
+		if binMapFile := getFlagString(cmd, "bin-map"); binMapFile != "" {
+			assigner.BinMap, err = readKVs(binMapFile, false)
+			checkError(err)
+			if outputLog {
+				log.Infof("%d genomes in the genome-to-bin mapping file: %s", len(assigner.BinMap), binMapFile)
+			}
+		} else if assigner.Strategy == BinStrategyLCA {
+			checkError(fmt.Errorf("flag -m/--bin-map is needed for the strategy lca"))
+		}
+
+		pairMode := getFlagString(cmd, "pair-mode")
+		switch pairMode {
+		case "either":
+		case "both":
+			assigner.BothMates = paired
+		default:
+			checkError(fmt.Errorf("invalid value of flag --pair-mode: %s. available values: either, both", pairMode))
+		}
+
 		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+		if paired && len(files)%2 != 0 {
+			checkError(fmt.Errorf("files of paired-end reads should be given in pairs for --paired"))
+		}
 		if len(files) == 1 {
 			if isStdin(files[0]) {
 				checkError(fmt.Errorf("  no files given, cant read from stdin"))
@@ -108,17 +188,18 @@ $ lexicmap utils bin -r report.tsv -u -o /tmp/outputs ~/input.fastx
 			log.Infof("  %d input file(s) given", len(files))
 		}
 
-		var err error
 		bufferSize, err := ParseByteSize(bufferSizeS)
 		if err != nil {
 			checkError(fmt.Errorf("invalid value of buffer size. supported unit: K, M, G"))
 		}
 
-		//outputLog := opt.Verbose || opt.Log2File
 		report := getFlagString(cmd, "report")
+		if report == "" {
+			checkError(fmt.Errorf("flag -r/--report needed"))
+		}
 
 		if isStdin(report) {
-			log.Info("  no files given, reading from stdin")
+			log.Info("  reading report from stdin")
 		} else if outputLog {
 			log.Infof("Input file given: %s", report)
 		}
@@ -132,255 +213,683 @@ $ lexicmap utils bin -r report.tsv -u -o /tmp/outputs ~/input.fastx
 		log.Infof("Created output directory: %s", outDirectory)
 		checkError(err)
 
-		buf := make([]byte, bufferSize)
-		var fh *xopen.Reader
-		var line string
-		var scanner *bufio.Scanner
-		var blastOutput bool = false
-		log_read_interval := 1000
+		fh, err := xopen.Ropen(report)
+		checkError(err)
+		defer fh.Close()
 
-		//var value string
-		fh, err = xopen.Ropen(report)
+		reportReader, err := NewReportReader(fh, int(bufferSize), assigner.RequiredColumns()...)
+		if err != nil && len(assigner.RequiredColumns()) > 0 {
+			checkError(fmt.Errorf("%s. the column bitscore is needed in the strategy %s", err, assigner.Strategy))
+		}
 		checkError(err)
+		binner := NewReadBinner(outDirectory, bin_unique, opt.CompressionLevel)
+
+		if paired {
+			for i := 0; i < len(files); i += 2 {
+				binner.Compression = CompressionSuffix(files[i])
+				reportReader.NewInput()
+				binPairs(files[i], files[i+1], reportReader, assigner, binner, outputLog)
+			}
+		} else {
+			log.Info("Assigning queries to genomes.")
 
-		scanner = bufio.NewScanner(fh)
-		scanner.Buffer(buf, int(bufferSize))
-		scanner.Scan()
-		headerLine := false
+			var record *fastx.Record
+			hits := make([]*SearchFields, 0, 128)
+			var bins []string
+			ids := make([]string, 1)
+			log_read_interval := 1000
+			timeStart1 := time.Now()
 
-		// Check if additional blast fields are used
-		blastOutput = CheckRegex(scanner.Text(), LongHeader)
-		if CheckRegex(scanner.Text(), ShortHeader) || blastOutput {
-			headerLine = true
-		}
+			for _, file := range files {
+				log.Infof("Processing: %s", file)
+				binner.Compression = CompressionSuffix(file)
+				reportReader.NewInput()
+
+				fastxReader, err := fastx.NewReader(nil, file, "")
+				checkError(err)
+				for {
+					record, err = fastxReader.Read()
+					if err != nil {
+						if err == io.EOF {
+							break
+						}
+						checkError(err)
+						break
+					}
 
-		allInputs := make(map[string][]*SearchFields)
-		searchGenomes := make(map[string]bool) // Making a set of target genomes
-		var previous *SearchFields
-		if headerLine {
-			scanner.Scan()
-			if scanner.Text() == "" {
-				checkError(fmt.Errorf("first line of file is empty"))
+					ids[0] = ByteSliceToString(record.ID)
+					hits, err = reportReader.Read(ids, hits[:0])
+					checkError(err)
+					bins, err = assigner.Assign(hits)
+					checkError(err)
+
+					read := FormatRecord(record, fastxReader.IsFastq)
+					binner.Add(&read, bins)
+
+					if verbose && binner.Reads%uint64(log_read_interval) == 0 {
+						speed := float64(binner.Reads) / time.Since(timeStart1).Minutes()
+						fmt.Fprintf(os.Stderr, "Processed: %d records, %d mapped, %.3f records per minute\r", binner.Reads, binner.Mapped, speed)
+					}
+				}
+				fastxReader.Close()
 			}
+		}
 
-			search_val := ProcessInput(scanner.Text())
-			searchGenomes[search_val.sgenome] = true
-			values_to_append := make([]*SearchFields, 0)
-			values_to_append = append(values_to_append, search_val)
-			allInputs[search_val.query] = values_to_append
-			previous = search_val
+		if q := reportReader.Pending(); q != "" {
+			checkError(fmt.Errorf(`records of query "%s" in the report are not matched with the reads. `+
+				`Records of the report should be in the same order as the reads, `+
+				`e.g., the output of "lexicmap search --keep-order" with the same input files`, q))
 		}
 
-		var search_val *SearchFields
+		binner.Close()
 
 		if outputLog {
-			log.Info("Reading report data.")
+			if verbose {
+				fmt.Fprintln(os.Stderr)
+			}
+			log.Infof("%d of %d records binned to genomes, %d records read from the report",
+				binner.Mapped, binner.Reads, reportReader.Records)
+			log.Info("Done")
 		}
+	},
+}
 
-		for scanner.Scan() {
-			line = scanner.Text()
-			if line == "" {
-				continue
-			}
-			search_val = ProcessInput(line)
-			if _, ok := searchGenomes[search_val.sgenome]; !ok {
-				searchGenomes[search_val.sgenome] = true
-			}
+// / Bin paired-end reads, mates are kept synchronized in the same bins.
+// / Bins are decided by hits of both mates, and records of the two mates are written
+// / into the _R1 and _R2 files, respectively.
+func binPairs(file1 string, file2 string, reportReader *ReportReader, assigner *BinAssigner, binner *ReadBinner, outputLog bool) {
+	if outputLog {
+		log.Infof("Processing paired-end reads: %s and %s", file1, file2)
+	}
 
-			if previous.query != search_val.query { // Add new value if read is new or previous is nil
-				values_to_append := make([]*SearchFields, 0)
-				values_to_append = append(values_to_append, search_val)
-				allInputs[search_val.query] = values_to_append
-				previous = search_val
-			} else {
-				allInputs[search_val.query] = append(allInputs[search_val.query], search_val)
-			}
+	fastxReader1, err := fastx.NewReader(nil, file1, "")
+	checkError(err)
+	fastxReader2, err := fastx.NewReader(nil, file2, "")
+	checkError(err)
+
+	hits := make([]*SearchFields, 0, 128)
+	var bins []string
+	ids := make([]string, 4)
+
+	var record1, record2 *fastx.Record
+	var err1, err2 error
+	for {
+		record1, err1 = fastxReader1.Read()
+		record2, err2 = fastxReader2.Read()
+		if err1 == io.EOF && err2 == io.EOF {
+			break
 		}
-		allInputs_l := len(allInputs)
-		if outputLog {
-			log.Infof("Sequences listed in report: %d", allInputs_l)
+		if err1 == io.EOF || err2 == io.EOF {
+			checkError(fmt.Errorf("unequal numbers of reads in %s and %s", file1, file2))
 		}
+		checkError(err1)
+		checkError(err2)
 
-		checkError(scanner.Err())
-		checkError(fh.Close())
-		var record *fastx.Record
-		SequenceTracker := 0
-		var BasesInMemory uint64 = 0
-		var FlushBasesThreshold uint64 = 1_000_000_000 // Flush when x amount of bases are in memory
+		if !bytes.Equal(MateBaseID(record1.ID), MateBaseID(record2.ID)) {
+			checkError(fmt.Errorf("read IDs of mates not matched: %s (%s) and %s (%s)", record1.ID, file1, record2.ID, file2))
+		}
 
-		log.Info("Assigning queries to genomes.")
-		// Organize output files
-		for _, file := range files {
-			log.Infof("Processing: %s", file)
+		// in the paired-end mode of search, lines of the two mates are interleaved,
+		// and suffixes "/1" and "/2" are appended to read IDs if two mates have the same ID.
+		ids[0] = string(record1.ID)
+		ids[1] = string(record2.ID)
+		ids[2] = ids[0] + "/1"
+		ids[3] = ids[1] + "/2"
+		hits, err = reportReader.Read(ids, hits[:0])
+		checkError(err)
+		bins, err = assigner.Assign(hits)
+		checkError(err)
 
-			// Initialize outputWrites with each genome to be written too
-			outputWrites := CreateOutputGroups(&searchGenomes, UnspecifiedBin)
-			outputWrites_unq := CreateOutputGroups(&searchGenomes, UnspecifiedBin)
-			fastxReader, err := fastx.NewReader(nil, file, "")
+		read1 := FormatRecord(record1, fastxReader1.IsFastq)
+		read2 := FormatRecord(record2, fastxReader2.IsFastq)
+		binner.AddPair(&read1, &read2, bins)
+	}
+	fastxReader1.Close()
+	fastxReader2.Close()
+}
 
-			checkError(err)
-			timeStart1 := time.Now()
-			for {
-				record, err = fastxReader.Read()
-				if err != nil {
-					if err == io.EOF {
-						break
-					}
-					checkError(err)
-					break
-				}
-				fastq_id := string(record.ID)
-				BasesInMemory = BasesInMemory + uint64(len(record.Seq.Seq))
-				read := record.Format(0)
-
-				if val, ok := allInputs[fastq_id]; ok {
-					IdentifyBestHit(val, &outputWrites, &outputWrites_unq, &read, bin_unique)
-					val = nil
-				} else {
-					// Unspecified is always unique, but no need to track it twice
-					outputWrites[UnspecifiedBin] = Append(outputWrites[UnspecifiedBin], &read)
-				}
-				SequenceTracker++
-				if (SequenceTracker%log_read_interval) == 0 && verbose && outputLog {
-					speed := float64(SequenceTracker) / time.Since(timeStart1).Minutes()
-					fmt.Fprintf(os.Stderr, "Processed: %d of %d records %.3f matches per minute, bases in memory: %d \r\r", SequenceTracker, allInputs_l, speed, BasesInMemory)
-					if BasesInMemory >= FlushBasesThreshold {
-						// Flush the outputs periodically to prevent the maps from growing too large
-						// causing paging to disk
-						if bin_unique {
-							WriteBinnedReads(&outputWrites_unq, file, outDirectory, UniqueBinned, false, opt.CompressionLevel)
-							WriteBinnedReads(&outputWrites, file, outDirectory, AllBinned, true, opt.CompressionLevel)
-						} else {
-							WriteBinnedReads(&outputWrites, file, outDirectory, "", true, opt.CompressionLevel)
-						}
-						BasesInMemory = 0
-					}
-				}
-			}
+// / Format a record into a new byte slice. Records from FASTA files are always formatted as FASTA,
+// / because a reused record object might keep the quality from a previous FASTQ file.
+func FormatRecord(record *fastx.Record, isFastq bool) []byte {
+	if !isFastq {
+		record.Seq.Qual = nil
+	}
+	return record.Format(0)
+}
 
-			fastxReader.Close()
-			if outputLog {
-				log.Infof("Binning records for %s", file)
-			}
+// / Return the read ID with the comment (after the first space or tab) and the suffix "/1" or "/2" stripped,
+// / for comparing IDs of two mates.
+func MateBaseID(id []byte) []byte {
+	if i := bytes.IndexAny(id, " \t"); i >= 0 {
+		id = id[:i]
+	}
+	n := len(id)
+	if n > 2 && id[n-2] == '/' && (id[n-1] == '1' || id[n-1] == '2') {
+		return id[:n-2]
+	}
+	return id
+}
 
-			if bin_unique {
-				WriteBinnedReads(&outputWrites_unq, file, outDirectory, UniqueBinned, false, opt.CompressionLevel)
-				WriteBinnedReads(&outputWrites, file, outDirectory, AllBinned, true, opt.CompressionLevel)
-			} else {
-				WriteBinnedReads(&outputWrites, file, outDirectory, "", true, opt.CompressionLevel)
-			}
-			BasesInMemory = 0
-		}
+// / ReportReader reads records of a search report in a streaming way,
+// / records of the same query (or the same read pair) should be consecutive.
+type ReportReader struct {
+	scanner *bufio.Scanner
+	colIdx  []int         // positions of columns, detected from the header line
+	next    *SearchFields // the record read but not consumed yet
 
-		if outputLog {
-			log.Info("Done")
+	// hashes of IDs of recently passed reads, for detecting out-of-order records,
+	// two generations are used to limit the memory.
+	passed     map[uint64]interface{}
+	passedPrev map[uint64]interface{}
+
+	Records uint64 // number of records read
+}
+
+// / The maximum number of read IDs in a generation of passed reads.
+const maxPassedReads = 1 << 20
+
+// / Create a ReportReader, the header line is skipped if present.
+// / Besides the basic columns, some other columns can be required, see BinAssigner.RequiredColumns().
+func NewReportReader(r io.Reader, bufferSize int, required ...int) (*ReportReader, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, bufferSize), bufferSize)
+
+	rr := &ReportReader{
+		scanner:    scanner,
+		passed:     make(map[uint64]interface{}, 1024),
+		passedPrev: make(map[uint64]interface{}),
+	}
+	if !scanner.Scan() {
+		return rr, scanner.Err()
+	}
+	line := scanner.Text()
+	required = append([]int{colQuery, colSgenome, colQcovGnm, colQcovHSP, colPident}, required...)
+	colIdx, _, isHeader, err := parseSearchHeader(line, required...)
+	if err != nil {
+		return nil, err
+	}
+	rr.colIdx = colIdx
+	if !isHeader {
+		rr.set(line)
+		return rr, nil
+	}
+	rr.scan()
+	return rr, nil
+}
+
+func (rr *ReportReader) set(line string) {
+	if line == "" {
+		return
+	}
+	rr.next = ProcessInput(line, rr.colIdx)
+	rr.Records++
+}
+
+// / Read the next non-empty line.
+func (rr *ReportReader) scan() {
+	rr.next = nil
+	for rr.next == nil && rr.scanner.Scan() {
+		rr.set(rr.scanner.Text())
+	}
+	checkError(rr.scanner.Err())
+}
+
+// / Read consecutive records of any of the given queries, which are appended to hits.
+// / Nothing is consumed if the next record belongs to another query, i.e., the read is not mapped.
+// / An error is returned if the next record belongs to a read already passed in the current input,
+// / i.e., records of the report are not in the same order as the reads.
+func (rr *ReportReader) Read(queries []string, hits []*SearchFields) ([]*SearchFields, error) {
+	// it's checked before consuming records of the current reads, rather than after consuming
+	// those of the previous reads, because the next record might belong to the next input file,
+	// where read IDs could be the same as those in the previous file, e.g., R1 and R2 files.
+	if rr.next != nil && !slices.Contains(queries, rr.next.query) {
+		h := wyhash.HashString(rr.next.query, 0)
+		_, ok1 := rr.passed[h]
+		_, ok2 := rr.passedPrev[h]
+		if ok1 || ok2 {
+			return hits, fmt.Errorf(`records of query "%s" in the report are not in the same order as the reads. `+
+				`Records of the report should be in the same order as the reads, `+
+				`e.g., the output of "lexicmap search --keep-order" with the same input files`, rr.next.query)
 		}
+	}
 
-	},
+	for rr.next != nil && slices.Contains(queries, rr.next.query) {
+		hits = append(hits, rr.next)
+		rr.scan()
+	}
+
+	if len(rr.passed) >= maxPassedReads {
+		rr.passedPrev, rr.passed = rr.passed, rr.passedPrev
+		clear(rr.passed)
+	}
+	for _, q := range queries {
+		rr.passed[wyhash.HashString(q, 0)] = struct{}{}
+	}
+	return hits, nil
 }
 
-// / Needed to prevent the overhead of string creation as map keys need to be strings
-// / in golang. This function comes from: https://syslog.ravelin.com/byte-vs-string-in-go-d645b67ca7ff
-func ByteSliceToString(bs []byte) string {
-	return *(*string)(unsafe.Pointer(&bs))
+// / Start a new input file, IDs of passed reads are forgotten, as they might appear again
+// / in the new file, e.g., R1 and R2 files searched in the single-end mode.
+func (rr *ReportReader) NewInput() {
+	clear(rr.passed)
+	clear(rr.passedPrev)
 }
 
-func CreateOutputGroups(genomes *map[string]bool, unspecified_bin string) map[string][]*[]byte {
-	outputWrites := make(map[string][]*[]byte)
-	for key := range *genomes {
-		outputWrites[key] = make([]*[]byte, 0, 10)
+// / Return the query of the record not consumed yet, or an empty string if all records are read.
+func (rr *ReportReader) Pending() string {
+	if rr.next == nil {
+		return ""
 	}
-	outputWrites[unspecified_bin] = make([]*[]byte, 0, 10)
-	return outputWrites
+	return rr.next.query
 }
 
-// / Get output file name per a file
-func GetOutputFile(input_file string, output_directory string, output_name string, nested_directory string) string {
-	var output_file string
-	var output string
-	if StringContains(input_file, &FastqList) {
-		output_file = fmt.Sprintf("%s.fastq.gz", output_name)
-	} else if StringContains(input_file, &FastaList) {
-		output_file = fmt.Sprintf("%s.fasta.gz", output_name)
-	} else {
-		checkError(fmt.Errorf("unrecognized input type %s", input_file))
+// / Strategies of assigning reads to genomes (or bins).
+const (
+	BinStrategyAll      = "all"       // all genomes passing the filters
+	BinStrategyBest     = "best"      // the genome with the highest score, the first one for ties
+	BinStrategyBestTies = "best-ties" // all genomes with the highest score
+	BinStrategyLCA      = "lca"       // the lowest common ancestor of lineages of all genomes
+)
+
+var BinStrategies = []string{BinStrategyAll, BinStrategyBest, BinStrategyBestTies, BinStrategyLCA}
+
+// / Bin of reads mapped to genomes without a common ancestor in the lca strategy.
+const AmbiguousBin = "Ambiguous"
+
+// / BinAssigner decides the bins of a read from its hits in the report.
+type BinAssigner struct {
+	MinPident     float64 // minimum pident of an HSP
+	MinQcovHSP    float64 // minimum qcovHSP of an HSP
+	MinQcovGenome float64 // minimum qcovGnm of a genome
+	Strategy      string
+
+	// Genome-to-bin mapping, e.g., assemblies to species. The values could also be lineages
+	// separated by semicolons or tabs, where the last taxon is the bin, and they are needed
+	// in the lca strategy. Genomes absent in the map use their own IDs.
+	BinMap map[string]string
+
+	// For paired-end reads, only genomes hit by both mates are used.
+	BothMates bool
+
+	hits       []genomeHit
+	genome2hit map[string]int
+	genomes    []string
+	scores     []float64
+	bins       []string
+	seen       map[string]struct{}
+	lineage    []string
+}
+
+// / Best scores of the two mates in a genome.
+type genomeHit struct {
+	genome string
+	scores [2]float64
+	hit    [2]bool
+}
+
+// / Columns needed in the report besides the basic ones, i.e., bitscore as the score
+// / of the best and best-ties strategies.
+func (a *BinAssigner) RequiredColumns() []int {
+	switch a.Strategy {
+	case BinStrategyBest, BinStrategyBestTies:
+		return []int{colBitscore}
 	}
-	if nested_directory == "" {
-		output = filepath.Join(output_directory, output_file)
+	return nil
+}
+
+// / Clear hits of the previous read.
+func (a *BinAssigner) Reset() {
+	a.genomes = a.genomes[:0]
+	a.scores = a.scores[:0]
+	a.bins = a.bins[:0]
+	a.hits = a.hits[:0]
+	if a.genome2hit == nil {
+		a.genome2hit = make(map[string]int, 128)
 	} else {
-		output = filepath.Join(output_directory, nested_directory, output_file)
+		clear(a.genome2hit)
 	}
-	return output
 }
 
-func WriteBinnedReads(outputs *map[string][]*[]byte, file_name string, output_directory string, nested_string string, clear_records bool, compression_level int) {
-	for key, val := range *outputs {
-		output := GetOutputFile(file_name, output_directory, key, nested_string)
-		outfh, gw, w, err := outStream(output, strings.HasSuffix(output, ".gz"), compression_level)
-		checkError(err)
+// / Add an HSP of a read (mate 0) or the second mate of a read pair (mate 1) in a genome,
+// / HSPs not passing the filters are ignored.
+func (a *BinAssigner) AddHit(mate int, genome string, pident, qcovHSP, qcovGnm, bitscore float64) {
+	if pident < a.MinPident || qcovHSP < a.MinQcovHSP || qcovGnm < a.MinQcovGenome {
+		return
+	}
 
-		for _, record := range val {
-			outfh.Write(*record)
-			if clear_records {
-				record = nil
+	i, ok := a.genome2hit[genome]
+	if !ok {
+		i = len(a.hits)
+		a.genome2hit[genome] = i
+		a.hits = append(a.hits, genomeHit{genome: genome})
+	}
+	h := &a.hits[i]
+	if !h.hit[mate] || bitscore > h.scores[mate] {
+		h.scores[mate] = bitscore
+	}
+	h.hit[mate] = true
+}
+
+// / Return the bins of a read with the given hits, an empty list means the read is not mapped.
+// / Hits of a read pair could contain records of two queries (mates). The score of a genome is
+// / the bit score of its best HSP, or the sum of those of two mates for paired-end reads.
+func (a *BinAssigner) Assign(hits []*SearchFields) ([]string, error) {
+	a.Reset()
+
+	var query0 string // query of the first mate
+	var pident, qcovHSP, qcovGnm float64
+	var mate int
+	var err error
+	for _, value := range hits {
+		if pident, err = parseBinFloat(value, "pident", value.pident); err != nil {
+			return nil, err
+		}
+		if qcovHSP, err = parseBinFloat(value, "qcovHSP", value.qcovHSP); err != nil {
+			return nil, err
+		}
+		if qcovGnm, err = parseBinFloat(value, "qcovGnm", value.qcovGnm); err != nil {
+			return nil, err
+		}
+
+		if query0 == "" {
+			query0 = value.query
+		}
+		if value.query == query0 {
+			mate = 0
+		} else {
+			mate = 1
+		}
+		a.AddHit(mate, value.sgenome, pident, qcovHSP, qcovGnm, value.bitscore)
+	}
+
+	return a.Bins(), nil
+}
+
+func parseBinFloat(value *SearchFields, col string, s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value of column %s in the record of query %s and genome %s: %q",
+			col, value.query, value.sgenome, s)
+	}
+	return v, nil
+}
+
+// / Return the bins of the read from the added hits.
+func (a *BinAssigner) Bins() []string {
+	for _, h := range a.hits {
+		if a.BothMates && !(h.hit[0] && h.hit[1]) {
+			continue
+		}
+		a.genomes = append(a.genomes, h.genome)
+		a.scores = append(a.scores, h.scores[0]+h.scores[1])
+	}
+	if len(a.genomes) == 0 {
+		return a.bins
+	}
+
+	switch a.Strategy {
+	case BinStrategyBest, BinStrategyBestTies:
+		var iBest int
+		for i, score := range a.scores {
+			if score > a.scores[iBest] {
+				iBest = i
 			}
 		}
-		if clear_records {
-			val = nil
+		if a.Strategy == BinStrategyBest {
+			return a.addBin(a.genomes[iBest])
 		}
-		outfh.Flush()
-		if gw != nil {
-			gw.Close()
+		for i, score := range a.scores {
+			if score == a.scores[iBest] {
+				a.addBin(a.genomes[i])
+			}
 		}
-		w.Close()
+		return a.bins
+	case BinStrategyLCA:
+		return a.lca()
+	}
+
+	for _, genome := range a.genomes {
+		a.addBin(genome)
+	}
+	return a.bins
+}
+
+// / Add the bin of a genome, duplicated bins are ignored.
+func (a *BinAssigner) addBin(genome string) []string {
+	if a.BinMap == nil { // genomes are unique
+		a.bins = append(a.bins, genome)
+		return a.bins
+	}
+
+	bin, ok := a.BinMap[genome]
+	if !ok {
+		bin = genome
+	} else if i := strings.LastIndexAny(bin, ";\t"); i >= 0 {
+		bin = strings.TrimSpace(bin[i+1:])
+	}
+	if len(a.bins) == 0 {
+		if a.seen == nil {
+			a.seen = make(map[string]struct{}, 128)
+		} else {
+			clear(a.seen)
+		}
+	} else if _, ok = a.seen[bin]; ok {
+		return a.bins
+	}
+	a.seen[bin] = struct{}{}
+	a.bins = append(a.bins, bin)
+	return a.bins
+}
+
+func isLineageSep(r rune) bool {
+	return r == ';' || r == '\t'
+}
+
+// / Assign the read to the last taxon of the lowest common ancestor of lineages of all genomes.
+func (a *BinAssigner) lca() []string {
+	var lineage string
+	var ok bool
+	var n, i int
+	for j, genome := range a.genomes {
+		if lineage, ok = a.BinMap[genome]; !ok {
+			lineage = genome
+		}
+		if j == 0 {
+			a.lineage = append(a.lineage[:0], strings.FieldsFunc(lineage, isLineageSep)...)
+			n = len(a.lineage)
+			continue
+		}
+		i = 0
+		for _, taxon := range strings.FieldsFunc(lineage, isLineageSep) {
+			if i >= n || taxon != a.lineage[i] {
+				break
+			}
+			i++
+		}
+		if n = i; n == 0 {
+			break
+		}
+	}
+	if n == 0 {
+		a.bins = append(a.bins, AmbiguousBin)
+	} else {
+		a.bins = append(a.bins, strings.TrimSpace(a.lineage[n-1]))
+	}
+	return a.bins
+}
+
+// / FlushBasesThreshold is the number of bases in memory to trigger writing reads into files.
+var FlushBasesThreshold int = 1_000_000_000
+
+// / ReadBinner distributes reads into files of genomes they are mapped to.
+// / Reads are buffered in memory and appended to the output files periodically,
+// / so the memory usage does not grow with the number of reads.
+type ReadBinner struct {
+	outDirectory     string
+	binUnique        bool
+	compressionLevel int
+	outputWrites     map[string][]*[]byte // output file -> reads
+	basesInMemory    int
+	Reads, Mapped    uint64
+
+	// Suffix of the compression format of output files, e.g., ".gz", usually the same as the input.
+	// It should be set before adding reads from each input file.
+	Compression string
+}
+
+// / Create a ReadBinner. Output files are saved in subdirectories All and Unique of
+// / the output directory if binUnique is true, or in the output directory otherwise.
+func NewReadBinner(outDirectory string, binUnique bool, compressionLevel int) *ReadBinner {
+	return &ReadBinner{
+		outDirectory:     outDirectory,
+		binUnique:        binUnique,
+		compressionLevel: compressionLevel,
+		outputWrites:     make(map[string][]*[]byte, 1024),
+		Compression:      ".gz",
+	}
+}
+
+// / Add a read (a formatted FASTA/Q record) mapped to the given bins.
+// / Reads without bins go to the bin of unmapped reads.
+func (b *ReadBinner) Add(read *[]byte, bins []string) {
+	b.count(bins)
+	b.add(read, bins, "")
+	b.checkMemory()
+}
+
+// / Add a read pair mapped to the given bins, the two mates are written
+// / into files with suffixes _R1 and _R2, respectively.
+func (b *ReadBinner) AddPair(read1 *[]byte, read2 *[]byte, bins []string) {
+	b.count(bins)
+	b.add(read1, bins, "_R1")
+	b.add(read2, bins, "_R2")
+	b.checkMemory()
+}
+
+func (b *ReadBinner) count(bins []string) {
+	b.Reads++
+	if len(bins) > 0 {
+		b.Mapped++
 	}
-	runtime.GC()
 }
 
-// / Check if any string contains a substring
-func StringContains(input string, substr *[]string) bool {
-	for _, key := range *substr {
-		if strings.Contains(input, key) {
-			return true
+func (b *ReadBinner) add(read *[]byte, bins []string, mate string) {
+	fileType := "fasta"
+	if len(*read) > 0 && (*read)[0] == '@' { // the format is decided for each read, so mixed inputs are supported
+		fileType = "fastq"
+	}
+
+	b.basesInMemory += len(*read)
+
+	var file string
+	if len(bins) == 0 {
+		// Unspecified is always unique, but no need to track it twice
+		if b.binUnique {
+			file = GetOutputFile(AllBinned, UnspecifiedBin, mate, fileType, b.Compression)
+		} else {
+			file = GetOutputFile("", UnspecifiedBin, mate, fileType, b.Compression)
+		}
+		b.outputWrites[file] = Append(b.outputWrites[file], read)
+		return
+	}
+
+	for _, bin := range bins {
+		bin = CleanBinName(bin)
+		if b.binUnique {
+			file = GetOutputFile(AllBinned, bin, mate, fileType, b.Compression)
+		} else {
+			file = GetOutputFile("", bin, mate, fileType, b.Compression)
 		}
+		b.outputWrites[file] = Append(b.outputWrites[file], read)
 	}
-	return false
+	if len(bins) == 1 && b.binUnique {
+		file = GetOutputFile(UniqueBinned, CleanBinName(bins[0]), mate, fileType, b.Compression)
+		b.outputWrites[file] = Append(b.outputWrites[file], read)
+	}
+}
+
+func (b *ReadBinner) checkMemory() {
+	if b.basesInMemory >= FlushBasesThreshold {
+		// Flush the outputs periodically to prevent the maps from growing too large
+		// causing paging to disk
+		b.Flush()
+	}
+}
+
+// / Append reads in memory to the output files.
+func (b *ReadBinner) Flush() {
+	WriteBinnedReads(&b.outputWrites, b.outDirectory, b.compressionLevel)
+	b.basesInMemory = 0
+}
+
+// / Write the remaining reads.
+func (b *ReadBinner) Close() {
+	b.Flush()
 }
 
-// / A function for future iteration for identification of an
-// / optimal hit per a read if one exists.
-func IdentifyBestHit(search_output []*SearchFields, output_genomes *map[string][]*[]byte, output_genomes_unq *map[string][]*[]byte, read *[]byte, id_best bool) {
-	var previous string = ""
-	sgenomes_out := 0
-	for _, value := range search_output {
-		if value.sgenome != previous {
-			sgenomes_out++
-			(*output_genomes)[value.sgenome] = Append((*output_genomes)[value.sgenome], read)
+// / Return the suffix of the compression format of a file, e.g., ".gz".
+func CompressionSuffix(file string) string {
+	file = strings.ToLower(file)
+	for _, suffix := range []string{".gz", ".xz", ".zst", ".bz2"} {
+		if strings.HasSuffix(file, suffix) {
+			return suffix
 		}
-		previous = value.sgenome
 	}
+	return ""
+}
 
-	if sgenomes_out == 1 && id_best {
-		(*output_genomes_unq)[previous] = Append((*output_genomes_unq)[previous], read)
+// / Needed to prevent the overhead of string creation as map keys need to be strings
+// / in golang. This function comes from: https://syslog.ravelin.com/byte-vs-string-in-go-d645b67ca7ff
+func ByteSliceToString(bs []byte) string {
+	return *(*string)(unsafe.Pointer(&bs))
+}
+
+// / Replace path separators in a bin name, e.g., a species name with a slash,
+// / so a bin is always a file directly in the output directory.
+func CleanBinName(name string) string {
+	if name == "" {
+		return "_"
+	}
+	if !strings.ContainsAny(name, `/\`) {
+		return name
 	}
+	return strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// / Get the output file path of a bin (relative to the output directory), file_type is "fastq" or "fasta",
+// / mate is "_R1" or "_R2" for paired-end reads, and compression is the suffix of the compression format.
+func GetOutputFile(nested_directory string, output_name string, mate string, file_type string, compression string) string {
+	return filepath.Join(nested_directory, output_name+mate+"."+file_type+compression)
+}
+
+// / Append binned reads to output files, and clear the records in memory.
+func WriteBinnedReads(outputs *map[string][]*[]byte, output_directory string, compression_level int) {
+	for key, val := range *outputs {
+		outfh, err := appendStream(filepath.Join(output_directory, key), compression_level)
+		checkError(err)
+
+		for _, record := range val {
+			_, err = outfh.Write(*record)
+			checkError(err)
+		}
+		checkError(outfh.Close())
+	}
+	clear(*outputs)
+	runtime.GC()
 }
 
 // / Process new input line
-func ProcessInput(line string) *SearchFields {
+func ProcessInput(line string, colIdx []int) *SearchFields {
 	line_stripped := strings.TrimRight(line, "\r\n")
-	new_line := SearchFromLine(line_stripped, '\t')
+	new_line := SearchFromLine(line_stripped, '\t', colIdx)
 	return &new_line
 }
 
-// / Determine if the header field is present in the file input
-// / line string: The incoming text line to check
-// / header_match string: The pattern to test for
-func CheckRegex(line string, header_match string) bool {
-	match, err := regexp.MatchString(header_match, line)
-	checkError(err)
-	return match
-}
-
 func Append(slice []*[]byte, new_value ...*[]byte) []*[]byte {
 	n := len(slice)
 	total := len(slice) + len(new_value)
@@ -402,7 +911,7 @@ func init() {
 		formatFlagUsage(`Output directory, supports and recommends a ".gz" suffix ("-" for stdout).`))
 
 	binCmd.Flags().StringP("report", "r", "",
-		formatFlagUsage(`The generated output of the lexicmap`))
+		formatFlagUsage(`The generated output of "lexicmap search", in the same order as the reads (see "lexicmap search --keep-order").`))
 
 	binCmd.Flags().StringP("buffer-size", "b", "20M",
 		formatFlagUsage(`Size of buffer, supported unit: K, M, G. You need increase the value when "bufio.Scanner: token too long" error reported`))
@@ -410,5 +919,27 @@ func init() {
 	binCmd.Flags().BoolP("bin-unique-reads", "u", true,
 		formatFlagUsage("Create separate reads from unique source into a separate folder."))
 
+	binCmd.Flags().Float64P("min-pident", "i", 0,
+		formatFlagUsage(`Minimum percentage of identity of HSPs.`))
+
+	binCmd.Flags().Float64P("min-qcov-hsp", "q", 0,
+		formatFlagUsage(`Minimum query coverage (percentage) of HSPs.`))
+
+	binCmd.Flags().Float64P("min-qcov-genome", "Q", 0,
+		formatFlagUsage(`Minimum query coverage (percentage) in a genome, i.e., qcovGnm.`))
+
+	binCmd.Flags().StringP("strategy", "s", BinStrategyAll,
+		formatFlagUsage(`Strategy of assigning reads to genomes. Available values: all, best, best-ties, lca.`))
+
+	binCmd.Flags().StringP("bin-map", "m", "",
+		formatFlagUsage(`Tabular file mapping genome IDs (the first column) to bins (e.g., species) or lineages, for binning reads by bins rather than genomes. `+
+			`Lineages are needed for the lca strategy.`))
+
+	binCmd.Flags().BoolP("paired", "", false,
+		formatFlagUsage(`Paired-end mode. Files of read 1 and read 2 are given in pairs, and mates are kept synchronized in the same bins, according to the output of "lexicmap search --paired".`))
+
+	binCmd.Flags().StringP("pair-mode", "", "either",
+		formatFlagUsage(`Bin a read pair to a genome if "either" mate or "both" mates hit the genome.`))
+
 	binCmd.SetUsageTemplate(usageTemplate(""))
 }
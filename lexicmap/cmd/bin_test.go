@@ -0,0 +1,383 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/shenwei356/xopen"
+)
+
+func TestReportReader(t *testing.T) {
+	header := strings.Join(searchColumns[:nColsBasic], "\t") + "\t" + strings.Join(searchColumnsTail, "\t")
+	record := func(query, genome string, evalue string) string {
+		// query, qlen, hits, sgenome, sseqid, qcovGnm, hsp, qcovHSP, alenHSP, pident, gaps,
+		// qstart, qend, sstart, send, sstr, slen
+		items := []string{query, "100", "1", genome, genome + "_1", "100.000", "1", "100.000", "100", "98.000", "0",
+			"1", "100", "1", "100", "+", "1000"}
+		if evalue != "" {
+			items = append(items, evalue, "180.0", "60")
+		}
+		return strings.Join(items, "\t")
+	}
+
+	// read2 is not mapped
+	report := strings.Join([]string{
+		header,
+		record("read1", "g1", "1.00e-50"),
+		record("read1", "g2", "1.00e-40"),
+		record("read3", "g1", "1.00e-30"),
+	}, "\n") + "\n"
+
+	rr, err := NewReportReader(strings.NewReader(report), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits := make([]*SearchFields, 0, 8)
+	for _, c := range []struct {
+		query   string
+		genomes []string
+	}{
+		{"read1", []string{"g1", "g2"}},
+		{"read2", nil},
+		{"read3", []string{"g1"}},
+		{"read4", nil},
+	} {
+		hits, err = rr.Read([]string{c.query}, hits[:0])
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", c.query, err)
+		}
+		if len(hits) != len(c.genomes) {
+			t.Fatalf("%s: %d hits expected, %d returned", c.query, len(c.genomes), len(hits))
+		}
+		for i, h := range hits {
+			if h.query != c.query || h.sgenome != c.genomes[i] {
+				t.Errorf("%s: unexpected hit: %s %s", c.query, h.query, h.sgenome)
+			}
+			if h.evalue == 0 || h.bitscore != 180 || h.mapq != 60 {
+				t.Errorf("%s: columns at the end not parsed: %v %v %d", c.query, h.evalue, h.bitscore, h.mapq)
+			}
+		}
+	}
+	if rr.Pending() != "" {
+		t.Errorf("unexpected pending query: %s", rr.Pending())
+	}
+	if rr.Records != 3 {
+		t.Errorf("3 records expected, %d read", rr.Records)
+	}
+
+	// old reports without a header line and the columns at the end
+	report = record("read1", "g1", "") + "\n" + record("read2", "g1", "") + "\n"
+	rr, err = NewReportReader(strings.NewReader(report), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{"read1", "read2"} {
+		hits, err = rr.Read([]string{query}, hits[:0])
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", query, err)
+		}
+		if len(hits) != 1 || hits[0].sgenome != "g1" || hits[0].pident != "98.000" {
+			t.Fatalf("%s: unexpected hits of a headerless report", query)
+		}
+		if hits[0].evalue != 0 || hits[0].mapq != 0 {
+			t.Errorf("%s: missing columns should be unset", query)
+		}
+	}
+
+	// records in a different order from the reads
+	report = strings.Join([]string{
+		header,
+		record("read2", "g1", "1.00e-50"),
+		record("read1", "g1", "1.00e-50"),
+	}, "\n") + "\n"
+	rr, err = NewReportReader(strings.NewReader(report), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hits, err = rr.Read([]string{"read1"}, hits[:0])
+	if err != nil || len(hits) != 0 || rr.Pending() != "read2" {
+		t.Fatalf("read1 should not be mapped before read2: %v", err)
+	}
+	hits, err = rr.Read([]string{"read2"}, hits[:0])
+	if err != nil || len(hits) != 1 {
+		t.Fatalf("records of read2 should be returned: %v", err)
+	}
+	hits, err = rr.Read([]string{"read3"}, hits[:0])
+	if err == nil {
+		t.Errorf("an error is expected for records out of order")
+	}
+	if len(hits) != 0 {
+		t.Errorf("records of read1 should not be returned for read3")
+	}
+
+	// two input files with the same read IDs, e.g., R1 and R2 files in the single-end mode
+	report = strings.Join([]string{
+		header,
+		record("read1", "g1", "1.00e-50"),
+		record("read3", "g1", "1.00e-50"),
+		record("read2", "g2", "1.00e-50"),
+	}, "\n") + "\n"
+	rr, err = NewReportReader(strings.NewReader(report), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, genomes := range []map[string]string{
+		{"read1": "g1", "read2": "", "read3": "g1"},
+		{"read1": "", "read2": "g2", "read3": ""}, // read2 has been passed in the first file
+	} {
+		rr.NewInput()
+		for _, query := range []string{"read1", "read2", "read3"} {
+			hits, err = rr.Read([]string{query}, hits[:0])
+			if err != nil {
+				t.Fatalf("file %d, %s: unexpected error: %s", i+1, query, err)
+			}
+			if genomes[query] == "" {
+				if len(hits) != 0 {
+					t.Errorf("file %d, %s: no hits expected", i+1, query)
+				}
+			} else if len(hits) != 1 || hits[0].sgenome != genomes[query] {
+				t.Errorf("file %d, %s: unexpected hits", i+1, query)
+			}
+		}
+	}
+	if rr.Pending() != "" {
+		t.Errorf("unexpected pending query: %s", rr.Pending())
+	}
+}
+
+func TestReportReaderRequiredColumns(t *testing.T) {
+	// a report without the columns at the end, e.g., from old versions or --outfmt subsets
+	header := strings.Join(searchColumns[:nColsBasic], "\t")
+	report := header + "\n"
+
+	for _, c := range []struct {
+		strategy string
+		ok       bool
+	}{
+		{BinStrategyAll, true},
+		{BinStrategyLCA, true},
+		{BinStrategyBest, false},
+		{BinStrategyBestTies, false},
+	} {
+		a := &BinAssigner{Strategy: c.strategy}
+		_, err := NewReportReader(strings.NewReader(report), 1<<20, a.RequiredColumns()...)
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error: %s", c.strategy, err)
+		} else if !c.ok && (err == nil || !strings.Contains(err.Error(), "bitscore")) {
+			t.Errorf("%s: an error of missing bitscore expected, returned: %v", c.strategy, err)
+		}
+	}
+
+	// headerless reports only have the basic columns
+	_, err := NewReportReader(strings.NewReader(strings.Repeat("x\t", nColsBasic-1)+"x\n"), 1<<20, colBitscore)
+	if err == nil {
+		t.Errorf("an error of missing bitscore expected for a headerless report")
+	}
+}
+
+func TestReadBinner(t *testing.T) {
+	outDir := filepath.Join(t.TempDir(), "bins")
+	if err := os.Mkdir(outDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewReadBinner(outDir, true, 5)
+	b.Compression = ""
+
+	r1 := []byte(">r1\nACGT\n")
+	r2 := []byte(">r2\nCCGG\n")
+	r3 := []byte(">r3\nTTTT\n")
+	p1 := []byte("@p/1\nACGT\n+\nIIII\n")
+	p2 := []byte("@p/2\nTGCA\n+\nIIII\n")
+
+	b.Add(&r1, []string{"g1"})
+	b.Flush() // reads should be appended across flushes
+	b.Add(&r2, []string{"g1", "g2"})
+	b.Add(&r3, nil)
+	b.AddPair(&p1, &p2, []string{"g2"})
+	b.Close()
+
+	if b.Reads != 4 || b.Mapped != 3 {
+		t.Errorf("unexpected numbers of reads: %d, mapped: %d", b.Reads, b.Mapped)
+	}
+
+	for file, expected := range map[string]string{
+		"All/g1.fasta":           string(r1) + string(r2),
+		"All/g2.fasta":           string(r2),
+		"All/NotMapped.fasta":    string(r3),
+		"All/g2_R1.fastq":        string(p1),
+		"All/g2_R2.fastq":        string(p2),
+		"Unique/g1.fasta":        string(r1),
+		"Unique/g2_R1.fastq":     string(p1),
+		"Unique/g2_R2.fastq":     string(p2),
+		"Unique/NotMapped.fasta": "",
+		"Unique/g2.fasta":        "",
+	} {
+		data, err := os.ReadFile(filepath.Join(outDir, file))
+		if expected == "" {
+			if err == nil {
+				t.Errorf("%s: unexpected file", file)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", file, err)
+			continue
+		}
+		if string(data) != expected {
+			t.Errorf("%s: unexpected content: %q", file, data)
+		}
+	}
+}
+
+func TestBinAssigner(t *testing.T) {
+	hit := func(query, genome, pident, qcovHSP string, bitscore float64) *SearchFields {
+		return &SearchFields{query: query, sgenome: genome, pident: pident, qcovHSP: qcovHSP,
+			qcovGnm: qcovHSP, bitscore: bitscore}
+	}
+	hits := []*SearchFields{
+		hit("r", "g1", "99.000", "100.000", 180),
+		hit("r", "g2", "95.000", "100.000", 200),
+		hit("r", "g3", "99.000", "50.000", 200),
+		hit("r", "g4", "99.000", "100.000", 170),
+	}
+	binMap := map[string]string{
+		"g1": "Bacteria;Pseudomonadota;Escherichia coli",
+		"g2": "Bacteria;Pseudomonadota;Shigella flexneri",
+		"g4": "Bacteria;Bacillota;Bacillus subtilis",
+	}
+
+	for _, c := range []struct {
+		strategy  string
+		minPident float64
+		binMap    map[string]string
+		hits      []*SearchFields
+		bins      []string
+	}{
+		{BinStrategyAll, 0, nil, hits, []string{"g1", "g2", "g3", "g4"}},
+		{BinStrategyAll, 98, nil, hits, []string{"g1", "g3", "g4"}},
+		{BinStrategyBest, 0, nil, hits, []string{"g2"}},
+		{BinStrategyBestTies, 0, nil, hits, []string{"g2", "g3"}},
+		{BinStrategyBest, 98, binMap, hits, []string{"g3"}},
+		{BinStrategyAll, 0, binMap, hits, []string{"Escherichia coli", "Shigella flexneri", "g3", "Bacillus subtilis"}},
+		{BinStrategyLCA, 0, binMap, hits[:2], []string{"Pseudomonadota"}},
+		{BinStrategyLCA, 0, binMap, hits[:1], []string{"Escherichia coli"}},
+		{BinStrategyLCA, 0, binMap, hits, []string{AmbiguousBin}},
+		{BinStrategyAll, 0, nil, nil, []string{}},
+	} {
+		a := &BinAssigner{Strategy: c.strategy, MinPident: c.minPident, BinMap: c.binMap}
+		bins, err := a.Assign(c.hits)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !slices.Equal(bins, c.bins) {
+			t.Errorf("strategy %s, min pident %.0f: expected %v, returned %v", c.strategy, c.minPident, c.bins, bins)
+		}
+	}
+
+	// mates: genomes hit by both mates, scores are summed
+	a := &BinAssigner{Strategy: BinStrategyBest, BothMates: true}
+	bins, _ := a.Assign([]*SearchFields{
+		hit("r/1", "g1", "99.000", "100.000", 150),
+		hit("r/1", "g2", "99.000", "100.000", 200),
+		hit("r/2", "g1", "99.000", "100.000", 150),
+	})
+	if !slices.Equal(bins, []string{"g1"}) {
+		t.Errorf("unexpected bins of a read pair: %v", bins)
+	}
+
+	// invalid values
+	a = &BinAssigner{Strategy: BinStrategyAll}
+	if _, err := a.Assign([]*SearchFields{hit("r", "g1", "99.000", "", 150)}); err == nil {
+		t.Errorf("an error is expected for an invalid value of qcovHSP")
+	}
+}
+
+func TestCleanBinName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"GCF_000017205.1":        "GCF_000017205.1",
+		"Pseudomonas aeruginosa": "Pseudomonas aeruginosa",
+		"Escherichia sp. AB/12":  "Escherichia sp. AB_12",
+		"../../etc/passwd":       ".._.._etc_passwd",
+		`a\b`:                    "a_b",
+		"":                       "_",
+	} {
+		if s := CleanBinName(name); s != expected {
+			t.Errorf("%q: expected %q, returned %q", name, expected, s)
+		}
+	}
+}
+
+func TestMateBaseID(t *testing.T) {
+	for id, expected := range map[string]string{
+		"read1":             "read1",
+		"read1/1":           "read1",
+		"read1/2":           "read1",
+		"read1/3":           "read1/3",
+		"read1/1 1:N:0:ACG": "read1",
+		"read1 2:N:0:ACG":   "read1",
+		"read1/2\tcomment":  "read1",
+		"/1":                "/1",
+	} {
+		if s := string(MateBaseID([]byte(id))); s != expected {
+			t.Errorf("%q: expected %q, returned %q", id, expected, s)
+		}
+	}
+}
+
+func TestReadBinnerCompression(t *testing.T) {
+	level := xopen.Level
+	outDir := t.TempDir()
+	r1 := []byte(">r1\nACGT\n")
+	r2 := []byte(">r2\nCCGG\n")
+
+	for _, suffix := range []string{".gz", ".xz", ".zst", ".bz2"} {
+		b := NewReadBinner(outDir, false, 9)
+		b.Compression = suffix
+		b.Add(&r1, []string{"g1"})
+		b.Flush() // a new compressed stream is appended
+		b.Add(&r2, []string{"g1"})
+		b.Close()
+
+		fh, err := xopen.Ropen(filepath.Join(outDir, "g1.fasta"+suffix))
+		if err != nil {
+			t.Fatalf("%s: %s", suffix, err)
+		}
+		data, err := io.ReadAll(fh)
+		fh.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", suffix, err)
+		}
+		if string(data) != string(r1)+string(r2) {
+			t.Errorf("%s: unexpected content: %q", suffix, data)
+		}
+	}
+
+	if xopen.Level != level {
+		t.Errorf("the global compression level should not be changed")
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var clusterCmd = &cobra.Command{
+	Use:   "cluster",
+	Short: "Cluster genomes in the index by similarities estimated from shared seeds",
+	Long: `Cluster genomes in the index by similarities estimated from shared seeds
+
+How:
+  1. Seed data are scanned once, and for each mask, genomes sharing the same k-mers
+     are found from values of seeds, which encode batch and genome indexes.
+     So no alignment or all-vs-all searching is performed.
+  2. The fraction of masks with shared k-mers is an estimate of the Jaccard index of two genomes,
+     from which the containment of the larger genome and ANI (C^(1/k)) are computed,
+     the same as "lexicmap utils dist".
+  3. Genomes are linked if their ANI >= -a/--ani, and then clustered (-m/--method) with:
+       greedy,     Genomes are processed in descending order of genome sizes, a genome is assigned
+                   to the most similar representative, or becomes a new representative.
+                   ANIs between genomes and representatives are all >= -a/--ani.
+       connected,  Connected components of the similarity graph, the largest genome of each
+                   component is chosen as the representative. Genomes in a component might be
+                   less similar than the threshold because of single-linkage.
+
+Attention:
+  1. Estimates are only reliable for closely related genomes (e.g., ANI >= 95%), and they might be
+     a little higher than the true values, because of extra seeds for filling sketching deserts.
+  2. Memory usage grows with the number of genome pairs sharing seeds, i.e., the number and
+     the similarities of genomes.
+  3. As the number of genome pairs of a seed grows quadratically with the number of genomes
+     sharing it, seeds shared by more than --max-genomes-per-seed genomes are skipped in
+     counting shared seeds. ANIs of genomes in big groups of highly similar genomes might be
+     underestimated, please increase the value for such indexes if time is affordable.
+
+Output (-o/--out-file), tab-delimited format with a header line:
+    1. cluster,         Cluster number, 1-based, in the order of representatives.
+    2. genome,          Genome ID.
+    3. gsize,           Genome size.
+    4. representative,  Representative genome of the cluster.
+    5. ani,             Estimated ANI (percentage) between the genome and the representative,
+                        0 for genomes not sharing seeds with the representative (connected components).
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		opt := getOptions(cmd)
+		outputLog := opt.Verbose || opt.Log2File
+
+		dbDir := getFlagString(cmd, "index")
+		if dbDir == "" {
+			checkError(fmt.Errorf("flag -d/--index needed"))
+		}
+		outFile := getFlagString(cmd, "out-file")
+		repsFile := getFlagString(cmd, "reps-file")
+		pairsFile := getFlagString(cmd, "pairs-file")
+
+		minANI := getFlagNonNegativeFloat64(cmd, "ani")
+		if minANI > 100 {
+			checkError(fmt.Errorf("the value of flag -a/--ani (%f) should be in range of [0, 100]", minANI))
+		}
+		minANI /= 100
+
+		maxGenomesPerSeed := getFlagNonNegativeInt(cmd, "max-genomes-per-seed")
+
+		method := getFlagString(cmd, "method")
+		switch method {
+		case "greedy", "connected":
+		default:
+			checkError(fmt.Errorf("invalid value of flag -m/--method: %s. available values: greedy, connected", method))
+		}
+
+		timeStart := time.Now()
+		defer func() {
+			if outputLog {
+				log.Info()
+				log.Infof("elapsed time: %s", time.Since(timeStart))
+				log.Info()
+			}
+		}()
+
+		// ---------------------------------------------------------------
+		// shared seeds
+
+		if outputLog {
+			log.Infof("counting shared seeds between genomes in the index: %s", dbDir)
+		}
+
+		s, err := NewIndexSeedSharing(dbDir, opt.NumCPUs, maxGenomesPerSeed)
+		checkError(err)
+
+		n := len(s.IDs)
+		if outputLog {
+			log.Infof("  %d genomes, %d pairs of genomes sharing seeds", n, len(s.Shared))
+			if maxGenomesPerSeed > 0 {
+				log.Infof("  %d seeds shared by > %d genomes skipped", s.SkippedSeeds, maxGenomesPerSeed)
+			}
+		}
+
+		neighbors := s.Neighbors(minANI)
+
+		// ---------------------------------------------------------------
+		// clustering
+
+		// larger genomes first
+		order := make([]int, n)
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return s.Sizes[order[i]] > s.Sizes[order[j]]
+		})
+
+		var reps []int
+		if method == "greedy" {
+			reps = clusterGenomesGreedy(order, neighbors, s.ANI)
+		} else {
+			reps = clusterGenomesConnected(order, neighbors)
+		}
+
+		// cluster numbers, in the order of representatives
+		clusters := make(map[int]int, 1024) // representative -> cluster number
+		members := make([][]int, 0, 1024)   // genomes of each cluster
+		var c int
+		var ok bool
+		for _, i := range order {
+			if reps[i] == i {
+				clusters[i] = len(members)
+				members = append(members, make([]int, 0, 1))
+			}
+		}
+		for _, i := range order {
+			c, ok = clusters[reps[i]]
+			if !ok { // impossible
+				checkError(fmt.Errorf("representative of genome %s not found", s.IDs[i]))
+			}
+			members[c] = append(members[c], i)
+		}
+
+		if outputLog {
+			log.Infof("%d genomes are clustered into %d clusters (method: %s, ANI >= %.2f%%)",
+				n, len(members), method, minANI*100)
+		}
+
+		// ---------------------------------------------------------------
+		// output
+
+		outfh, gw, w, err := outStream(outFile, strings.HasSuffix(outFile, ".gz"), opt.CompressionLevel)
+		checkError(err)
+
+		fmt.Fprintln(outfh, "cluster\tgenome\tgsize\trepresentative\tani")
+		var rep int
+		for c, gs := range members {
+			rep = gs[0]
+			for _, i := range gs {
+				fmt.Fprintf(outfh, "%d\t%s\t%d\t%s\t%.3f\n", c+1, s.IDs[i], s.Sizes[i], s.IDs[rep], s.ANI(i, rep)*100)
+			}
+		}
+
+		outfh.Flush()
+		if gw != nil {
+			gw.Close()
+		}
+		w.Close()
+
+		if repsFile != "" {
+			outfh, gw, w, err := outStream(repsFile, strings.HasSuffix(repsFile, ".gz"), opt.CompressionLevel)
+			checkError(err)
+
+			for _, gs := range members {
+				fmt.Fprintln(outfh, s.IDs[gs[0]])
+			}
+
+			outfh.Flush()
+			if gw != nil {
+				gw.Close()
+			}
+			w.Close()
+
+			if outputLog {
+				log.Infof("representatives saved to: %s", repsFile)
+			}
+		}
+
+		if pairsFile != "" {
+			outfh, gw, w, err := outStream(pairsFile, strings.HasSuffix(pairsFile, ".gz"), opt.CompressionLevel)
+			checkError(err)
+
+			fmt.Fprintln(outfh, "genome1\tgenome2\tshared\tjaccard\tani")
+			for i, ns := range neighbors {
+				for _, j := range ns {
+					if j < i {
+						continue
+					}
+					fmt.Fprintf(outfh, "%s\t%s\t%d\t%.6f\t%.3f\n", s.IDs[i], s.IDs[j],
+						s.Shared[uint64(i)<<32|uint64(j)], s.Jaccard(i, j), s.ANI(i, j)*100)
+				}
+			}
+
+			outfh.Flush()
+			if gw != nil {
+				gw.Close()
+			}
+			w.Close()
+
+			if outputLog {
+				log.Infof("similarities of linked genome pairs saved to: %s", pairsFile)
+			}
+		}
+	},
+}
+
+func init() {
+	utilsCmd.AddCommand(clusterCmd)
+
+	clusterCmd.Flags().StringP("index", "d", "",
+		formatFlagUsage(`Index directory created by "lexicmap index".`))
+
+	clusterCmd.Flags().StringP("out-file", "o", "-",
+		formatFlagUsage(`Out file of cluster assignments, supports a ".gz" suffix ("-" for stdout).`))
+
+	clusterCmd.Flags().StringP("reps-file", "r", "",
+		formatFlagUsage(`Out file of representative genome IDs, one ID per line.`))
+
+	clusterCmd.Flags().StringP("pairs-file", "p", "",
+		formatFlagUsage(`Out file of similarities of linked genome pairs, i.e., with ANI >= -a/--ani.`))
+
+	clusterCmd.Flags().Float64P("ani", "a", 99,
+		formatFlagUsage(`Minimum estimated ANI (percentage) for linking two genomes.`))
+
+	clusterCmd.Flags().StringP("method", "m", "greedy",
+		formatFlagUsage(`Clustering method: greedy, connected.`))
+
+	clusterCmd.Flags().IntP("max-genomes-per-seed", "", 1000,
+		formatFlagUsage(`Skip seeds (k-mers) shared by more than this number of genomes in counting shared seeds, to avoid quadratic growth of genome pairs. 0 for no limit.`))
+
+	clusterCmd.SetUsageTemplate(usageTemplate("-d <index path> [-a 99] [-o clusters.tsv]"))
+}
@@ -0,0 +1,444 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/LexicMap/lexicmap/cmd/genome"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+var coverageCmd = &cobra.Command{
+	Use:   "coverage",
+	Short: "Compute coverage and depth of reference sequences from search results",
+	Long: `Compute coverage and depth of reference sequences from search results
+
+Input:
+   - Output of 'lexicmap search', with a header line. Only HSPs passing the filters
+     (-i/--min-pident and -q/--min-qcov-hsp) are used.
+   - Or SAM format (--sam), where reference sequence IDs (RNAME) should be unique in the index.
+     Unmapped records and secondary alignments (FLAG 0x100) are skipped,
+     while supplementary ones (FLAG 0x800) are kept.
+   - Lengths of reference sequences are read from the index, so uncovered sequences are reported too.
+   - Alignments across the origin of circular sequences (send > slen) are wrapped.
+
+Output:
+   1. Depth of covered regions in bedGraph format (-o/--out-file), i.e., 4 columns:
+      sequence ID, 0-based start, end, depth. Uncovered regions are omitted unless --zero is given.
+   2. Per-genome summary (-s/--summary-file), for genomes with at least one alignment (or all
+      genomes with --all-genomes):
+        genome,           Genome ID.
+        genome_size,      Genome size.
+        seqs,             Number of sequences.
+        covered_seqs,     Number of sequences with at least one alignment.
+        breadth,          Number of covered bases.
+        covered_fraction, Percentage of covered bases.
+        mean_depth,       Mean depth of all bases.
+   3. Per-sequence summary (-S/--seq-summary-file), with the columns:
+        genome, seqid, length, breadth, covered_fraction, mean_depth.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		opt := getOptions(cmd)
+		outputLog := opt.Verbose || opt.Log2File
+
+		dbDir := getFlagString(cmd, "index")
+		if dbDir == "" {
+			checkError(fmt.Errorf("flag -d/--index needed"))
+		}
+
+		outFile := getFlagString(cmd, "out-file")
+		summaryFile := getFlagString(cmd, "summary-file")
+		seqSummaryFile := getFlagString(cmd, "seq-summary-file")
+		zero := getFlagBool(cmd, "zero")
+		allGenomes := getFlagBool(cmd, "all-genomes")
+		samFormat := getFlagBool(cmd, "sam")
+
+		bufferSizeS := getFlagString(cmd, "buffer-size")
+		if bufferSizeS == "" {
+			checkError(fmt.Errorf("value of buffer size. supported unit: K, M, G"))
+		}
+		bufferSize, err := ParseByteSize(bufferSizeS)
+		if err != nil {
+			checkError(fmt.Errorf("invalid value of buffer size. supported unit: K, M, G"))
+		}
+
+		minPident := getFlagNonNegativeFloat64(cmd, "min-pident")
+		if minPident > 100 {
+			checkError(fmt.Errorf("the value of flag -i/--min-pident (%f) should be in range of [0, 100]", minPident))
+		}
+		minQcov := getFlagNonNegativeFloat64(cmd, "min-qcov-hsp")
+		if minQcov > 100 {
+			checkError(fmt.Errorf("the value of flag -q/--min-qcov-hsp (%f) should be in range of [0, 100]", minQcov))
+		}
+
+		// ---------------------------------------------------------------
+		// sequences in the index
+
+		if outputLog {
+			log.Infof("reading sequence information from the index: %s", dbDir)
+		}
+		genomes, err := readGenomeSeqs(dbDir)
+		checkError(err)
+
+		genome2idx := make(map[string]int, len(genomes))
+		var nSeqs int
+		for i, g := range genomes {
+			genome2idx[g.ID] = i
+			nSeqs += len(g.SeqIDs)
+		}
+		if outputLog {
+			log.Infof("  %d genomes with %d sequences", len(genomes), nSeqs)
+		}
+
+		// only for SAM format
+		var seq2idx map[string][2]int
+		if samFormat {
+			seq2idx = make(map[string][2]int, nSeqs)
+			var dup int
+			for i, g := range genomes {
+				for j, id := range g.SeqIDs {
+					if _, ok := seq2idx[id]; ok {
+						dup++
+						continue
+					}
+					seq2idx[id] = [2]int{i, j}
+				}
+			}
+			if dup > 0 {
+				log.Warningf("%d sequence IDs are duplicated in the index, only the first ones are used for SAM records", dup)
+			}
+		}
+
+		// ---------------------------------------------------------------
+		// alignments
+
+		var nAlignments int
+		addAlignment := func(g *genomeSeqs, j int, start, end int) {
+			if g.Covs == nil {
+				g.Covs = make([]*SeqCoverage, len(g.SeqIDs))
+			}
+			if g.Covs[j] == nil {
+				g.Covs[j] = NewSeqCoverage(g.SeqSizes[j])
+			}
+			g.Covs[j].Add(start, end)
+			nAlignments++
+		}
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		buf := make([]byte, bufferSize)
+		rp := newSearchResultParser(colSgenome, colSseqid, colQcovHSP, colPident, colSstart, colSend)
+
+		for _, file := range files {
+			fh, err := xopen.Ropen(file)
+			checkError(err)
+
+			rp.Reset(file)
+			scanner := bufio.NewScanner(fh)
+			scanner.Buffer(buf, int(bufferSize))
+			for scanner.Scan() {
+				if samFormat {
+					a, ok, err := ParseSAMRecord(scanner.Bytes())
+					checkError(err)
+					if !ok {
+						continue
+					}
+					ij, ok := seq2idx[a.SeqID]
+					if !ok {
+						checkError(fmt.Errorf("sequence %s not found in the index: %s", a.SeqID, dbDir))
+					}
+					addAlignment(genomes[ij[0]], ij[1], a.Start, a.End)
+					continue
+				}
+
+				line := strings.TrimRight(scanner.Text(), "\r\n")
+				if line == "" {
+					continue
+				}
+				ok, err := rp.Parse(line)
+				checkError(err)
+				if !ok {
+					continue
+				}
+
+				if minPident > 0 {
+					if v, _ := strconv.ParseFloat(rp.Field(colPident), 64); v < minPident {
+						continue
+					}
+				}
+				if minQcov > 0 {
+					if v, _ := strconv.ParseFloat(rp.Field(colQcovHSP), 64); v < minQcov {
+						continue
+					}
+				}
+
+				i, ok := genome2idx[rp.Field(colSgenome)]
+				if !ok {
+					checkError(fmt.Errorf("genome %s not found in the index: %s", rp.Field(colSgenome), dbDir))
+				}
+				g := genomes[i]
+				j := g.seqIndex(rp.Field(colSseqid))
+				if j < 0 {
+					checkError(fmt.Errorf("sequence %s not found in genome %s", rp.Field(colSseqid), g.ID))
+				}
+
+				sstart, _ := strconv.Atoi(rp.Field(colSstart))
+				send, _ := strconv.Atoi(rp.Field(colSend))
+				if sstart > send {
+					sstart, send = send, sstart
+				}
+				addAlignment(g, j, sstart-1, send)
+			}
+			checkError(scanner.Err())
+			checkError(fh.Close())
+		}
+
+		if outputLog {
+			log.Infof("%d alignments loaded", nAlignments)
+		}
+
+		// ---------------------------------------------------------------
+		// output
+
+		outfh, gw, w, err := outStream(outFile, strings.HasSuffix(outFile, ".gz"), opt.CompressionLevel)
+		checkError(err)
+		defer func() {
+			outfh.Flush()
+			if gw != nil {
+				gw.Close()
+			}
+			w.Close()
+		}()
+
+		var outfh2, outfh3 *bufio.Writer
+		if summaryFile != "" {
+			var gw2 io.WriteCloser
+			var w2 *os.File
+			outfh2, gw2, w2, err = outStream(summaryFile, strings.HasSuffix(summaryFile, ".gz"), opt.CompressionLevel)
+			checkError(err)
+			defer func() {
+				outfh2.Flush()
+				if gw2 != nil {
+					gw2.Close()
+				}
+				w2.Close()
+			}()
+			outfh2.WriteString("genome\tgenome_size\tseqs\tcovered_seqs\tbreadth\tcovered_fraction\tmean_depth\n")
+		}
+		if seqSummaryFile != "" {
+			var gw3 io.WriteCloser
+			var w3 *os.File
+			outfh3, gw3, w3, err = outStream(seqSummaryFile, strings.HasSuffix(seqSummaryFile, ".gz"), opt.CompressionLevel)
+			checkError(err)
+			defer func() {
+				outfh3.Flush()
+				if gw3 != nil {
+					gw3.Close()
+				}
+				w3.Close()
+			}()
+			outfh3.WriteString("genome\tseqid\tlength\tbreadth\tcovered_fraction\tmean_depth\n")
+		}
+
+		var covered, gCovered, gSize, gCoveredSeqs int
+		var bases, gBases int64
+		for _, g := range genomes {
+			if g.Covs == nil && !allGenomes {
+				continue
+			}
+
+			gCovered, gBases, gSize, gCoveredSeqs = 0, 0, 0, 0
+			for j, id := range g.SeqIDs {
+				gSize += g.SeqSizes[j]
+
+				var c *SeqCoverage
+				if g.Covs != nil {
+					c = g.Covs[j]
+				}
+				if c == nil {
+					covered, bases = 0, 0
+					if zero && g.SeqSizes[j] > 0 {
+						fmt.Fprintf(outfh, "%s\t0\t%d\t0\n", id, g.SeqSizes[j])
+					}
+				} else {
+					covered, bases = c.Stats()
+					gCoveredSeqs++
+					c.Runs(func(start, end int, depth int32) {
+						if depth > 0 || zero {
+							fmt.Fprintf(outfh, "%s\t%d\t%d\t%d\n", id, start, end, depth)
+						}
+					})
+				}
+				gCovered += covered
+				gBases += bases
+
+				if outfh3 != nil {
+					fmt.Fprintf(outfh3, "%s\t%s\t%d\t%d\t%.4f\t%.4f\n", g.ID, id, g.SeqSizes[j],
+						covered, percentage(covered, g.SeqSizes[j]), meanDepth(bases, g.SeqSizes[j]))
+				}
+			}
+
+			if outfh2 != nil {
+				fmt.Fprintf(outfh2, "%s\t%d\t%d\t%d\t%d\t%.4f\t%.4f\n", g.ID, gSize, len(g.SeqIDs), gCoveredSeqs,
+					gCovered, percentage(gCovered, gSize), meanDepth(gBases, gSize))
+			}
+		}
+	},
+}
+
+func percentage(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b) * 100
+}
+
+func meanDepth(bases int64, size int) float64 {
+	if size == 0 {
+		return 0
+	}
+	return float64(bases) / float64(size)
+}
+
+// genomeSeqs holds sequence information of a genome in the index.
+type genomeSeqs struct {
+	ID       string
+	SeqIDs   []string
+	SeqSizes []int
+
+	Covs []*SeqCoverage // coverage of sequences, created on demand
+
+	seq2idx map[string]int
+}
+
+// seqIndex returns the index of a sequence, or -1 if not found.
+func (g *genomeSeqs) seqIndex(id string) int {
+	if g.seq2idx == nil {
+		g.seq2idx = make(map[string]int, len(g.SeqIDs))
+		for i, _id := range g.SeqIDs {
+			g.seq2idx[_id] = i
+		}
+	}
+	if i, ok := g.seq2idx[id]; ok {
+		return i
+	}
+	return -1
+}
+
+// readGenomeSeqs reads IDs and sizes of sequences of all genomes in an index,
+// in the order of genomes in the index. Sequences of genome chunks are merged.
+func readGenomeSeqs(dbDir string) ([]*genomeSeqs, error) {
+	name2idx, err := readGenomeMapName2Idx(filepath.Join(dbDir, FileGenomeIndex))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read genome index mapping file: %s", err)
+	}
+
+	names := make([]string, 0, len(name2idx))
+	for name := range name2idx {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return (*name2idx[names[i]])[0] < (*name2idx[names[j]])[0]
+	})
+
+	readers := make(map[int]*genome.Reader, 8)
+	defer func() {
+		for _, rdr := range readers {
+			rdr.Close()
+		}
+	}()
+
+	genomes := make([]*genomeSeqs, 0, len(names))
+	for _, name := range names {
+		gs := &genomeSeqs{ID: name}
+		for _, batchIDAndRefID := range *name2idx[name] {
+			genomeBatch := int(batchIDAndRefID >> BITS_GENOME_IDX)
+			genomeIdx := int(batchIDAndRefID & MASK_GENOME_IDX)
+
+			rdr, ok := readers[genomeBatch]
+			if !ok {
+				rdr, err = genome.NewReader(filepath.Join(dbDir, DirGenomes, batchDir(genomeBatch), FileGenomes))
+				if err != nil {
+					return nil, fmt.Errorf("failed to read genome data file: %s", err)
+				}
+				readers[genomeBatch] = rdr
+			}
+
+			g, err := rdr.GenomeInfo(genomeIdx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read genome information: %s", err)
+			}
+			for i, id := range g.SeqIDs {
+				gs.SeqIDs = append(gs.SeqIDs, string(*id))
+				gs.SeqSizes = append(gs.SeqSizes, g.SeqSizes[i])
+			}
+			genome.RecycleGenome(g)
+		}
+		genomes = append(genomes, gs)
+	}
+	return genomes, nil
+}
+
+func init() {
+	utilsCmd.AddCommand(coverageCmd)
+
+	coverageCmd.Flags().StringP("index", "d", "",
+		formatFlagUsage(`Index directory created by "lexicmap index", for getting lengths of sequences.`))
+
+	coverageCmd.Flags().StringP("out-file", "o", "-",
+		formatFlagUsage(`Out file of depth in bedGraph format, supports a ".gz" suffix ("-" for stdout).`))
+
+	coverageCmd.Flags().StringP("summary-file", "s", "",
+		formatFlagUsage(`Out file of per-genome summary, supports a ".gz" suffix ("-" for stdout).`))
+
+	coverageCmd.Flags().StringP("seq-summary-file", "S", "",
+		formatFlagUsage(`Out file of per-sequence summary, supports a ".gz" suffix ("-" for stdout).`))
+
+	coverageCmd.Flags().BoolP("zero", "z", false,
+		formatFlagUsage(`Output uncovered regions (depth of 0) in the bedGraph file.`))
+
+	coverageCmd.Flags().BoolP("all-genomes", "a", false,
+		formatFlagUsage(`Output all genomes in the index, including those without alignments.`))
+
+	coverageCmd.Flags().BoolP("sam", "", false,
+		formatFlagUsage(`Input is in SAM format.`))
+
+	coverageCmd.Flags().StringP("buffer-size", "b", "20M",
+		formatFlagUsage(`Size of buffer, supported unit: K, M, G. You need increase the value when "bufio.Scanner: token too long" error reported`))
+
+	coverageCmd.Flags().Float64P("min-pident", "i", 0,
+		formatFlagUsage(`Minimum percentage of identity of HSPs.`))
+
+	coverageCmd.Flags().Float64P("min-qcov-hsp", "q", 0,
+		formatFlagUsage(`Minimum query coverage (percentage) of HSPs.`))
+
+	coverageCmd.SetUsageTemplate(usageTemplate(""))
+}
@@ -0,0 +1,245 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/spf13/cobra"
+)
+
+var distCmd = &cobra.Command{
+	Use:   "dist",
+	Short: "Estimate similarities between query genomes and indexed genomes from seeds",
+	Long: `Estimate similarities between query genomes and indexed genomes from seeds
+
+How:
+  1. Each input file is treated as a query genome, sequences of which are concatenated
+     and masked with the masks of the index, like what 'lexicmap index' does.
+  2. K-mers captured by masks are exactly matched in the seed data, and the number of masks
+     sharing k-mers with each indexed genome is counted. No alignment is performed.
+  3. Masks are regarded as a sketch of the two genomes, so the fraction of shared masks
+     is an estimate of the Jaccard index, from which the containment of the query genome
+     and ANI (C^(1/k)) are computed, along with a 95% confidence interval.
+
+Attention:
+  1. The genome ID of a query is extracted from the file name via -N/--ref-name-regexp.
+  2. Estimates are only reliable for closely related genomes (e.g., ANI >= 90%), and
+     they might be a little higher than the true values, because of extra seeds for filling
+     sketching deserts in the index.
+  3. For a genome chunked in indexing, shared masks of all chunks are summed up.
+
+Output format:
+  Tab-delimited format with 11 columns:
+
+    1.  query,       Query genome ID.
+    2.  qsize,       Query genome size.
+    3.  sgenome,     Subject genome ID.
+    4.  gsize,       Subject genome size.
+    5.  masks,       Number of masks capturing k-mers in the query genome.
+    6.  shared,      Number of masks with k-mers shared by the two genomes.
+    7.  jaccard,     Estimated Jaccard index.
+    8.  containment, Estimated containment (percentage) of the query genome in the subject genome.
+    9.  ani,         Estimated average nucleotide identity (percentage).
+    10. ani_low,     Lower bound of the 95% confidence interval of ANI.
+    11. ani_high,    Upper bound of the 95% confidence interval of ANI.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		opt := getOptions(cmd)
+		seq.ValidateSeq = false
+
+		outputLog := opt.Verbose || opt.Log2File
+
+		dbDir := getFlagString(cmd, "index")
+		if dbDir == "" {
+			checkError(fmt.Errorf("flag -d/--index needed"))
+		}
+		outFile := getFlagString(cmd, "out-file")
+		topn := getFlagNonNegativeInt(cmd, "top-n-genomes")
+		minANI := getFlagNonNegativeFloat64(cmd, "min-ani")
+		if minANI > 100 {
+			checkError(fmt.Errorf("the value of flag --min-ani (%f) should be in range of [0, 100]", minANI))
+		}
+		minShared := getFlagPositiveInt(cmd, "min-shared")
+		maxOpenFiles := getFlagPositiveInt(cmd, "max-open-files")
+		inMemorySearch := getFlagBool(cmd, "load-whole-seeds")
+
+		reRefNameStr := getFlagString(cmd, "ref-name-regexp")
+		var reRefName *regexp.Regexp
+		var err error
+		if reRefNameStr != "" {
+			if !regexp.MustCompile(`\(.+\)`).MatchString(reRefNameStr) {
+				checkError(fmt.Errorf(`value of --ref-name-regexp must contains "(" and ")" to capture the ref name from file name`))
+			}
+			if !reIgnoreCase.MatchString(reRefNameStr) {
+				reRefNameStr = reIgnoreCaseStr + reRefNameStr
+			}
+
+			reRefName, err = regexp.Compile(reRefNameStr)
+			if err != nil {
+				checkError(errors.Wrapf(err, "failed to parse regular expression for matching sequence header: %s", reRefName))
+			}
+		}
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		timeStart := time.Now()
+		defer func() {
+			if outputLog {
+				log.Info()
+				log.Infof("elapsed time: %s", time.Since(timeStart))
+				log.Info()
+			}
+		}()
+
+		// ---------------------------------------------------------------
+		// loading index
+
+		if outputLog {
+			log.Infof("loading index: %s", dbDir)
+		}
+
+		sopt := &IndexSearchingOptions{}
+		*sopt = DefaultIndexSearchingOptions
+		sopt.NumCPUs = opt.NumCPUs
+		sopt.Verbose = opt.Verbose
+		sopt.Log2File = opt.Log2File
+		sopt.MaxOpenFiles = maxOpenFiles
+		sopt.InMemorySearch = inMemorySearch
+
+		idx, err := NewIndexSearcher(dbDir, sopt)
+		checkError(err)
+		defer func() {
+			checkError(idx.Close())
+		}()
+
+		if outputLog {
+			log.Infof("index loaded in %s", time.Since(timeStart))
+			log.Info()
+		}
+
+		// ---------------------------------------------------------------
+
+		outfh, gw, w, err := outStream(outFile, strings.HasSuffix(outFile, ".gz"), opt.CompressionLevel)
+		checkError(err)
+		defer func() {
+			outfh.Flush()
+			if gw != nil {
+				gw.Close()
+			}
+			w.Close()
+		}()
+
+		fmt.Fprintln(outfh, "query\tqsize\tsgenome\tgsize\tmasks\tshared\tjaccard\tcontainment\tani\tani_low\tani_high")
+
+		var record *fastx.Record
+		var qSize, nHits int
+		for i, file := range files {
+			baseFile := filepath.Base(file)
+			var queryID string
+			if reRefName != nil && reRefName.MatchString(baseFile) {
+				queryID = reRefName.FindAllStringSubmatch(baseFile, 1)[0][1]
+			} else {
+				queryID, _, _ = filepathTrimExtension(baseFile, nil)
+			}
+
+			seqs := make([][]byte, 0, 8)
+			qSize = 0
+
+			fastxReader, err := fastx.NewReader(nil, file, "")
+			checkError(err)
+			for {
+				record, err = fastxReader.Read()
+				if err != nil {
+					if err == io.EOF {
+						break
+					}
+					checkError(err)
+					break
+				}
+				if len(record.Seq.Seq) == 0 {
+					continue
+				}
+				seqs = append(seqs, bytes.ToUpper(record.Seq.Seq)) // the record is reused by the reader
+				qSize += len(record.Seq.Seq)
+			}
+			fastxReader.Close()
+
+			sims, err := idx.EstimateGenomeSimilarity(seqs, topn)
+			checkError(err)
+
+			nHits = 0
+			for _, sim := range sims {
+				if sim.Shared < minShared || sim.ANI*100 < minANI {
+					continue
+				}
+				nHits++
+				fmt.Fprintf(outfh, "%s\t%d\t%s\t%d\t%d\t%d\t%.6f\t%.3f\t%.3f\t%.3f\t%.3f\n",
+					queryID, qSize, sim.ID, sim.GenomeSize, sim.Masks, sim.Shared,
+					sim.Jaccard, sim.Containment*100, sim.ANI*100, sim.ANILow*100, sim.ANIHigh*100)
+			}
+
+			if outputLog {
+				log.Infof("[%d/%d] %s: %d bases, %d genomes reported", i+1, len(files), queryID, qSize, nHits)
+			}
+		}
+	},
+}
+
+func init() {
+	utilsCmd.AddCommand(distCmd)
+
+	distCmd.Flags().StringP("index", "d", "",
+		formatFlagUsage(`Index directory created by "lexicmap index".`))
+
+	distCmd.Flags().StringP("out-file", "o", "-",
+		formatFlagUsage(`Out file, supports a ".gz" suffix ("-" for stdout).`))
+
+	distCmd.Flags().StringP("ref-name-regexp", "N", `(?i)(.+)\.(f[aq](st[aq])?|fna)(\.gz|\.xz|\.zst|\.bz2)?$`,
+		formatFlagUsage(`Regular expression (must contains "(" and ")") for extracting the query genome ID from the filename.`))
+
+	distCmd.Flags().IntP("top-n-genomes", "n", 10,
+		formatFlagUsage(`Keep top N genomes with the highest ANI for each query genome, 0 for all.`))
+
+	distCmd.Flags().IntP("min-shared", "m", 1,
+		formatFlagUsage(`Minimum number of masks with shared k-mers.`))
+
+	distCmd.Flags().Float64P("min-ani", "a", 0,
+		formatFlagUsage(`Minimum estimated ANI (percentage).`))
+
+	distCmd.Flags().IntP("max-open-files", "", 512,
+		formatFlagUsage(`Maximum opened files. Do not forgot to set a bigger "ulimit -n" in shell if the value is > 1024.`))
+
+	distCmd.Flags().BoolP("load-whole-seeds", "w", false,
+		formatFlagUsage(`Load the whole seed data into memory for faster search.`))
+
+	distCmd.SetUsageTemplate(usageTemplate("-d <index path> [genome.fasta.gz ...] [-o dist.tsv.gz]"))
+}
@@ -0,0 +1,263 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shenwei356/LexicMap/lexicmap/cmd/genome"
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+var exportGenomesCmd = &cobra.Command{
+	Use:   "export-genomes",
+	Short: "Export genome sequences in the index to FASTA files",
+	Long: `Export genome sequences in the index to FASTA files
+
+Output:
+  1. By default, sequences of all genomes are written to a single stream (-o/--out-file).
+     With -O/--out-dir, sequences of each genome are written to a file named by the genome ID
+     with a suffix (-s/--suffix).
+  2. FASTA header: ">seqid genome=genome_id", with " [topology=circular]" appended for circular sequences,
+     which can be recognized by "lexicmap index" with "--circular-keyword circular".
+  3. Sequences of big genomes split into chunks in the index are merged.
+
+Attention:
+  1. All degenerate bases in reference genomes were converted to the lexicographic first bases.
+     E.g., N was converted to A. Gap regions (runs of at least 5 N's) are restored as N's,
+     except for indexes created by versions before v0.4.1, where consecutive A's in output might be
+     N's in the genomes.
+  2. Sequences filtered out in indexing (e.g., via -B/--seq-name-filter or -l/--min-seq-len)
+     are not available, and only sequence IDs are kept in the FASTA headers.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		opt := getOptions(cmd)
+		outputLog := opt.Verbose || opt.Log2File
+		seq.ValidateSeq = false
+
+		dbDir := getFlagString(cmd, "index")
+		if dbDir == "" {
+			checkError(fmt.Errorf("flag -d/--index needed"))
+		}
+
+		outFile := getFlagString(cmd, "out-file")
+		outDir := getFlagString(cmd, "out-dir")
+		suffix := getFlagString(cmd, "suffix")
+		force := getFlagBool(cmd, "force")
+		lineWidth := getFlagNonNegativeInt(cmd, "line-width")
+
+		// ---------------------------------------------------------------
+		// genomes to export
+
+		var ids map[string]interface{}
+		genomeFile := getFlagString(cmd, "genomes")
+		if genomeFile != "" {
+			fh, err := xopen.Ropen(genomeFile)
+			checkError(err)
+			ids = make(map[string]interface{}, 1024)
+			scanner := bufio.NewScanner(fh)
+			var line string
+			for scanner.Scan() {
+				line = strings.TrimSpace(scanner.Text())
+				if line == "" || line[0] == '#' {
+					continue
+				}
+				ids[line] = struct{}{}
+			}
+			checkError(scanner.Err())
+			checkError(fh.Close())
+
+			if len(ids) == 0 {
+				checkError(fmt.Errorf("no genome IDs given in %s", genomeFile))
+			}
+		}
+
+		// ---------------------------------------------------------------
+		// output
+
+		var outfh *bufio.Writer
+		var gw io.WriteCloser
+		var w *os.File
+		var err error
+		if outDir != "" {
+			makeOutDir(outDir, force, "out-dir", outputLog)
+		} else {
+			outfh, gw, w, err = outStream(outFile, strings.HasSuffix(outFile, ".gz"), opt.CompressionLevel)
+			checkError(err)
+			defer func() {
+				outfh.Flush()
+				if gw != nil {
+					gw.Close()
+				}
+				w.Close()
+			}()
+		}
+
+		writeGenome := func(outfh *bufio.Writer, g *indexGenome) {
+			for i, s := range g.Seqs {
+				if g.Circular[i] {
+					fmt.Fprintf(outfh, ">%s genome=%s [topology=circular]\n", g.SeqIDs[i], g.ID)
+				} else {
+					fmt.Fprintf(outfh, ">%s genome=%s\n", g.SeqIDs[i], g.ID)
+				}
+				_s, err := seq.NewSeq(seq.DNAredundant, s)
+				checkError(err)
+				outfh.Write(_s.FormatSeq(lineWidth))
+				outfh.WriteByte('\n')
+			}
+		}
+
+		var nGenomes, nSeqs int
+		err = iterateIndexGenomes(dbDir, ids, func(g *indexGenome) error {
+			nGenomes++
+			nSeqs += len(g.Seqs)
+
+			if outDir == "" {
+				writeGenome(outfh, g)
+				return nil
+			}
+
+			file := filepath.Join(outDir, g.ID+suffix)
+			fh, _gw, _w, err := outStream(file, strings.HasSuffix(file, ".gz"), opt.CompressionLevel)
+			if err != nil {
+				return err
+			}
+			writeGenome(fh, g)
+			fh.Flush()
+			if _gw != nil {
+				_gw.Close()
+			}
+			return _w.Close()
+		})
+		checkError(err)
+
+		if ids != nil && nGenomes < len(ids) && outputLog {
+			log.Warningf("%d genome IDs not found in the index", len(ids)-nGenomes)
+		}
+		if outputLog {
+			log.Infof("%d sequences of %d genomes exported", nSeqs, nGenomes)
+		}
+	},
+}
+
+// iterateIndexGenomes reads genomes from an index in the order of genomes in the index,
+// with sequences of genome chunks merged. If ids is not nil, only these genomes are read.
+func iterateIndexGenomes(dbDir string, ids map[string]interface{}, fn func(g *indexGenome) error) error {
+	// genomes.map file for mapping index to genome id
+	name2idx, err := readGenomeMapName2Idx(filepath.Join(dbDir, FileGenomeIndex))
+	if err != nil {
+		return fmt.Errorf("failed to read genomes index mapping file: %s", err)
+	}
+	names, err := indexGenomeIDs(dbDir)
+	if err != nil {
+		return err
+	}
+
+	// the reader of the current batch, and readers of other batches are only
+	// opened temporarily for chunks of big genomes.
+	var rdr *genome.Reader
+	curBatch := -1
+	defer func() {
+		if rdr != nil {
+			rdr.Close()
+		}
+	}()
+
+	var ok bool
+	for _, name := range names {
+		if ids != nil {
+			if _, ok = ids[name]; !ok {
+				continue
+			}
+		}
+		batchIDAndRefIDs := *name2idx[name]
+
+		g := &indexGenome{ID: name}
+		for _, batchIDAndRefID := range batchIDAndRefIDs {
+			genomeBatch := int(batchIDAndRefID >> BITS_GENOME_IDX)
+			genomeIdx := int(batchIDAndRefID & MASK_GENOME_IDX)
+
+			_rdr := rdr
+			if genomeBatch != curBatch {
+				_rdr, err = genome.NewReader(filepath.Join(dbDir, DirGenomes, batchDir(genomeBatch), FileGenomes))
+				if err != nil {
+					return fmt.Errorf("failed to read genome data file: %s", err)
+				}
+				if curBatch < 0 || genomeBatch > curBatch { // move to the next batch
+					if rdr != nil {
+						rdr.Close()
+					}
+					rdr, curBatch = _rdr, genomeBatch
+				}
+			}
+
+			if err = appendIndexGenomeSeqs(_rdr, genomeIdx, g); err != nil {
+				return fmt.Errorf("failed to read genome %s: %s", name, err)
+			}
+
+			if _rdr != rdr {
+				if err = _rdr.Close(); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err = fn(g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	utilsCmd.AddCommand(exportGenomesCmd)
+
+	exportGenomesCmd.Flags().StringP("index", "d", "",
+		formatFlagUsage(`Index directory created by "lexicmap index".`))
+
+	exportGenomesCmd.Flags().StringP("genomes", "g", "",
+		formatFlagUsage(`A file of genome IDs (one per line) to export. By default, all genomes are exported.`))
+
+	exportGenomesCmd.Flags().StringP("out-file", "o", "-",
+		formatFlagUsage(`Out file for sequences of all genomes, supports the ".gz" suffix ("-" for stdout).`))
+
+	exportGenomesCmd.Flags().StringP("out-dir", "O", "",
+		formatFlagUsage(`Out directory for writing sequences of each genome into a separate file.`))
+
+	exportGenomesCmd.Flags().StringP("suffix", "s", ".fa.gz",
+		formatFlagUsage(`File suffix of genome files in the out directory (-O/--out-dir).`))
+
+	exportGenomesCmd.Flags().BoolP("force", "", false,
+		formatFlagUsage(`Overwrite existing output directory.`))
+
+	exportGenomesCmd.Flags().IntP("line-width", "w", 60,
+		formatFlagUsage("Line width of sequence (0 for no wrap)."))
+
+	exportGenomesCmd.SetUsageTemplate(usageTemplate(""))
+}
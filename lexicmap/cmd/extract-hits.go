@@ -0,0 +1,287 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/LexicMap/lexicmap/cmd/genome"
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+var extractHitsCmd = &cobra.Command{
+	Use:   "extract-hits",
+	Short: "Extract subject sequences of hits along with flanking sequences from the index",
+	Long: `Extract subject sequences of hits along with flanking sequences from the index
+
+Input:
+   - Output of 'lexicmap search', via the flag -r/--report or positional arguments.
+     The flag -a/--all is not needed for the search, as sequences are read from the index.
+
+Output:
+   - Sequences of HSPs in FASTA format, with flanking sequences of -f/--flank bases on both sides.
+   - Sequences of hits on the negative strand are reverse complemented.
+   - Flanking sequences are clipped at the ends of sequences, except for alignments across
+     the origin of circular sequences, where the two parts are concatenated.
+   - FASTA header: ">sseqid:start-end:strand sgenome=... query=... hsp=... hit=sstart-send",
+     where start and end are 1-based positions of the extracted region (flanks included),
+     and sstart and send are the positions of the HSP.
+
+Attention:
+  1. All degenerate bases in reference genomes were converted to the lexicographic first bases.
+     E.g., N was converted to A. Therefore, consecutive A's in output might be N's in the genomes.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		opt := getOptions(cmd)
+		outputLog := opt.Verbose || opt.Log2File
+		seq.ValidateSeq = false
+
+		dbDir := getFlagString(cmd, "index")
+		if dbDir == "" {
+			checkError(fmt.Errorf("flag -d/--index needed"))
+		}
+
+		report := getFlagString(cmd, "report")
+		if report != "" {
+			args = append(args, report)
+		}
+
+		outFile := getFlagString(cmd, "out-file")
+		flank := getFlagNonNegativeInt(cmd, "flank")
+		lineWidth := getFlagNonNegativeInt(cmd, "line-width")
+
+		bufferSizeS := getFlagString(cmd, "buffer-size")
+		if bufferSizeS == "" {
+			checkError(fmt.Errorf("value of buffer size. supported unit: K, M, G"))
+		}
+		bufferSize, err := ParseByteSize(bufferSizeS)
+		if err != nil {
+			checkError(fmt.Errorf("invalid value of buffer size. supported unit: K, M, G"))
+		}
+
+		minPident := getFlagNonNegativeFloat64(cmd, "min-pident")
+		if minPident > 100 {
+			checkError(fmt.Errorf("the value of flag -i/--min-pident (%f) should be in range of [0, 100]", minPident))
+		}
+		minQcov := getFlagNonNegativeFloat64(cmd, "min-qcov-hsp")
+		if minQcov > 100 {
+			checkError(fmt.Errorf("the value of flag -q/--min-qcov-hsp (%f) should be in range of [0, 100]", minQcov))
+		}
+
+		// ---------------------------------------------------------------
+
+		// genomes.map file for mapping index to genome id
+		m, err := readGenomeMapName2Idx(filepath.Join(dbDir, FileGenomeIndex))
+		if err != nil {
+			checkError(fmt.Errorf("failed to read genomes index mapping file: %s", err))
+		}
+
+		// genome readers of batches, opened when needed
+		readers := make(map[int]*genome.Reader, 8)
+		defer func() {
+			for _, rdr := range readers {
+				checkError(rdr.Close())
+			}
+		}()
+
+		// subSeq returns a copy of the subsequence (0-based, end included) of a sequence in a genome,
+		// along with the actual end position.
+		subSeq := func(refname string, seqid []byte, start, end int, s []byte) ([]byte, int) {
+			batchIDAndRefIDs, ok := m[refname]
+			if !ok {
+				checkError(fmt.Errorf("reference name not found in the index: %s", refname))
+			}
+
+			var tSeq *genome.Genome
+			var _end int
+			for _, batchIDAndRefID := range *batchIDAndRefIDs {
+				genomeBatch := int(batchIDAndRefID >> BITS_GENOME_IDX)
+				genomeIdx := int(batchIDAndRefID & MASK_GENOME_IDX)
+
+				rdr, ok := readers[genomeBatch]
+				if !ok {
+					fileGenome := filepath.Join(dbDir, DirGenomes, batchDir(genomeBatch), FileGenomes)
+					rdr, err = genome.NewReader(fileGenome)
+					if err != nil {
+						checkError(fmt.Errorf("failed to read genome data file: %s", err))
+					}
+					readers[genomeBatch] = rdr
+				}
+
+				tSeq, _end, err = rdr.SubSeq2(genomeIdx, seqid, start, end)
+				if err == nil {
+					break
+				}
+			}
+			if err != nil {
+				checkError(fmt.Errorf("failed to read subsequence of %s in %s: %s", seqid, refname, err))
+			}
+			s = append(s, tSeq.Seq...)
+			genome.RecycleGenome(tSeq)
+			return s, _end
+		}
+
+		// ---------------------------------------------------------------
+
+		outfh, gw, w, err := outStream(outFile, strings.HasSuffix(outFile, ".gz"), opt.CompressionLevel)
+		checkError(err)
+		defer func() {
+			outfh.Flush()
+			if gw != nil {
+				gw.Close()
+			}
+			w.Close()
+		}()
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		buf := make([]byte, bufferSize)
+		rp := newSearchResultParser(colQuery, colSgenome, colSseqid, colHSP, colQcovHSP, colPident,
+			colSstart, colSend, colSstr, colSlen)
+		var s []byte
+		var sstart, send, slen, start, end, _end int
+		var nHits int
+
+		for _, file := range files {
+			fh, err := xopen.Ropen(file)
+			checkError(err)
+
+			rp.Reset(file)
+			scanner := bufio.NewScanner(fh)
+			scanner.Buffer(buf, int(bufferSize))
+			for scanner.Scan() {
+				line := strings.TrimRight(scanner.Text(), "\r\n")
+				if line == "" {
+					continue
+				}
+				ok, err := rp.Parse(line)
+				checkError(err)
+				if !ok {
+					continue
+				}
+
+				if minPident > 0 {
+					if v, _ := strconv.ParseFloat(rp.Field(colPident), 64); v < minPident {
+						continue
+					}
+				}
+				if minQcov > 0 {
+					if v, _ := strconv.ParseFloat(rp.Field(colQcovHSP), 64); v < minQcov {
+						continue
+					}
+				}
+
+				sstart, err = strconv.Atoi(rp.Field(colSstart))
+				if err != nil {
+					checkError(fmt.Errorf("invalid sstart: %s", rp.Field(colSstart)))
+				}
+				send, err = strconv.Atoi(rp.Field(colSend))
+				if err != nil {
+					checkError(fmt.Errorf("invalid send: %s", rp.Field(colSend)))
+				}
+				slen, err = strconv.Atoi(rp.Field(colSlen))
+				if err != nil {
+					checkError(fmt.Errorf("invalid slen: %s", rp.Field(colSlen)))
+				}
+				seqid := []byte(rp.Field(colSseqid))
+
+				start, end = hitRegion(sstart, send, slen, flank)
+				s = s[:0]
+				if end <= slen {
+					s, end = subSeq(rp.Field(colSgenome), seqid, start-1, end-1, s)
+					end++ // returned end is 0-based.
+				} else { // across the origin of a circular sequence
+					s, _ = subSeq(rp.Field(colSgenome), seqid, start-1, slen-1, s)
+					s, _end = subSeq(rp.Field(colSgenome), seqid, 0, end-slen-1, s)
+					end = slen + _end + 1
+				}
+
+				strand := rp.Field(colSstr)
+				if strand == "-" {
+					RC(s)
+				}
+
+				fmt.Fprintf(outfh, ">%s:%d-%d:%s sgenome=%s query=%s hsp=%s hit=%d-%d\n",
+					seqid, start, end, strand, rp.Field(colSgenome), rp.Field(colQuery),
+					rp.Field(colHSP), sstart, send)
+				_s, err := seq.NewSeq(seq.DNAredundant, s)
+				checkError(err)
+				outfh.Write(_s.FormatSeq(lineWidth))
+				outfh.WriteByte('\n')
+				nHits++
+			}
+			checkError(scanner.Err())
+			checkError(fh.Close())
+		}
+
+		if outputLog {
+			log.Infof("%d sequences extracted", nHits)
+		}
+	},
+}
+
+// hitRegion returns the 1-based region of a hit with flanking sequences on both sides.
+// For hits across the origin of a circular sequence (send > slen), the end is wrapped (> slen)
+// unless it's clamped to slen, and the region is at most one copy of the sequence.
+func hitRegion(sstart, send, slen, flank int) (start, end int) {
+	start = max(sstart-flank, 1)
+	if send <= slen {
+		return start, min(send+flank, slen)
+	}
+	return start, min(send+flank, slen+start-1)
+}
+
+func init() {
+	utilsCmd.AddCommand(extractHitsCmd)
+
+	extractHitsCmd.Flags().StringP("index", "d", "",
+		formatFlagUsage(`Index directory created by "lexicmap index".`))
+
+	extractHitsCmd.Flags().StringP("report", "r", "",
+		formatFlagUsage(`The generated output of "lexicmap search". Positional arguments are also accepted.`))
+
+	extractHitsCmd.Flags().StringP("out-file", "o", "-",
+		formatFlagUsage(`Out file, supports the ".gz" suffix ("-" for stdout).`))
+
+	extractHitsCmd.Flags().IntP("flank", "f", 0,
+		formatFlagUsage(`Length of flanking sequences on both sides of hits.`))
+
+	extractHitsCmd.Flags().IntP("line-width", "w", 60,
+		formatFlagUsage("Line width of sequence (0 for no wrap)."))
+
+	extractHitsCmd.Flags().StringP("buffer-size", "b", "20M",
+		formatFlagUsage(`Size of buffer, supported unit: K, M, G. You need increase the value when "bufio.Scanner: token too long" error reported`))
+
+	extractHitsCmd.Flags().Float64P("min-pident", "i", 0,
+		formatFlagUsage(`Minimum percentage of identity of HSPs.`))
+
+	extractHitsCmd.Flags().Float64P("min-qcov-hsp", "q", 0,
+		formatFlagUsage(`Minimum query coverage (percentage) of HSPs.`))
+
+	extractHitsCmd.SetUsageTemplate(usageTemplate(""))
+}
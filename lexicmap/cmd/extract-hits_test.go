@@ -0,0 +1,55 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"testing"
+)
+
+func TestHitRegion(t *testing.T) {
+	const slen = 1000
+	for _, c := range []struct {
+		sstart, send, flank int
+		start, end          int
+	}{
+		{101, 200, 0, 101, 200},
+		{101, 200, 50, 51, 250},
+		{31, 200, 50, 1, 250},     // clamped at the beginning
+		{901, 980, 50, 851, 1000}, // clamped at the end
+
+		// across the origin
+		{951, 1050, 0, 951, 1050},
+		{951, 1050, 20, 931, 1070},
+		{951, 1050, 500, 451, 1450}, // at most one copy of the sequence
+		{1, 1050, 0, 1, 1000},       // the whole sequence
+		{1, 1050, 20, 1, 1000},
+		{11, 1050, 20, 1, 1000},
+	} {
+		start, end := hitRegion(c.sstart, c.send, slen, c.flank)
+		if start != c.start || end != c.end {
+			t.Errorf("hit %d-%d, flank %d: expected %d-%d, returned %d-%d",
+				c.sstart, c.send, c.flank, c.start, c.end, start, end)
+		}
+		if end > slen && end-slen > start-1 { // the wrapped part overlaps with the first part
+			t.Errorf("hit %d-%d, flank %d: overlapped region %d-%d", c.sstart, c.send, c.flank, start, end)
+		}
+	}
+}
@@ -46,12 +46,23 @@ var MagicIdx = [8]byte{'.', 'g', 'e', 'n', 'o', 'm', 'e', 'i'}
 // KVIndexFileExt is the file extension of k-mer data index file.
 var GenomeIndexFileExt = ".idx"
 
-// MainVersion is use for checking compatibility
-var MainVersion uint8 = 0
-
-// MinorVersion is less important
+// MainVersion is use for checking compatibility.
+// v1: the topology (circular or not) of sequences is saved in the highest bit of sequence sizes,
+// so files of v1 are refused by readers of v0, while files of v0 can still be read,
+// where the highest bits are always 0.
+var MainVersion uint8 = 1
+
+// MinorVersion is less important.
+// v1.1: gap regions (runs of N's) of genomes are saved after the sequence data,
+// which are skipped by readers of older versions.
 var MinorVersion uint8 = 1
 
+// flagCircular marks a circular sequence in the highest bit of the sequence size.
+const flagCircular uint32 = 1 << 31
+
+// maskSeqSize is for extracting the sequence size.
+const maskSeqSize uint32 = flagCircular - 1
+
 // BufferSize is size of reading and writing buffer
 var BufferSize = 65536 // os.Getpagesize()
 
@@ -80,6 +91,8 @@ type Genome struct {
 	NumSeqs    int       // number of sequences
 	SeqSizes   []int     // sizes of sequences
 	SeqIDs     []*[]byte // IDs of all sequences
+	Circular   []bool    // topology of all sequences, optional in index building
+	Gaps       [][2]int  // gap regions (runs of N's) in the concatenated sequence, 0-based, end excluded
 
 	// only used in index building
 	Kmers     *[]uint64 // lexichash mask result
@@ -123,6 +136,8 @@ func (r *Genome) Reset() {
 	r.NumSeqs = 0
 	r.SeqSizes = r.SeqSizes[:0]
 	r.SeqIDs = r.SeqIDs[:0]
+	r.Circular = r.Circular[:0]
+	r.Gaps = r.Gaps[:0]
 
 	r.GenomeID = -1
 
@@ -140,6 +155,7 @@ func RecycleGenome(g *Genome) {
 	}
 	if g.TwoBit != nil {
 		RecycleTwoBit(g.TwoBit)
+		g.TwoBit = nil // genomes from the pool might not be reset, e.g., in Reader.
 	}
 	for _, id := range g.SeqIDs {
 		poolID.Put(id)
@@ -228,8 +244,12 @@ func (w *Writer) Write(s *Genome) error {
 
 	var seqid []byte
 	for i, size := range s.SeqSizes {
-		// seq sizes
-		be.PutUint32(buf[:4], uint32(size))
+		// seq sizes, with the topology in the highest bit
+		if i < len(s.Circular) && s.Circular[i] {
+			be.PutUint32(buf[:4], uint32(size)|flagCircular)
+		} else {
+			be.PutUint32(buf[:4], uint32(size))
+		}
 		buf0.Write(buf[:4])
 
 		// seq ids
@@ -270,6 +290,21 @@ func (w *Writer) Write(s *Genome) error {
 	}
 	w.offset += buf0.Len() + nbytes
 
+	// gap regions, since v1.1
+	buf0.Reset()
+	be.PutUint32(buf[:4], uint32(len(s.Gaps)))
+	buf0.Write(buf[:4])
+	for _, gap := range s.Gaps {
+		be.PutUint32(buf[:4], uint32(gap[0]))
+		be.PutUint32(buf[4:8], uint32(gap[1]))
+		buf0.Write(buf[:8])
+	}
+	_, err = w.w.Write(buf0.Bytes())
+	if err != nil {
+		return err
+	}
+	w.offset += buf0.Len()
+
 	if newTwoBit {
 		poolTwoBit.Put(b2)
 	}
@@ -344,6 +379,8 @@ type Reader struct {
 	batch uint32
 	nSeqs uint32
 
+	hasGaps bool // gap regions are saved since v1.1
+
 	Index []uint64 // index data of all genome records, (offset, nbases)
 
 	buf []byte
@@ -407,10 +444,11 @@ func NewReader(file string) (*Reader, error) {
 		return nil, ErrBrokenFile
 	}
 
-	// check compatibility
-	if MainVersion != buf[0] {
+	// check compatibility, files created by newer main versions are not supported.
+	if buf[0] > MainVersion {
 		return nil, ErrVersionMismatch
 	}
+	r.hasGaps = buf[0] == 1 && buf[1] >= 1
 
 	// batch number and the number seqs
 	n, err = io.ReadFull(bfh, buf[:8])
@@ -556,8 +594,10 @@ func (r *Reader) GenomeInfo(idx int) (*Genome, error) {
 	// SeqSizes and SeqIDs
 	g.SeqSizes = g.SeqSizes[:0]
 	g.SeqIDs = g.SeqIDs[:0]
+	g.Circular = g.Circular[:0]
 	var j, nappend int
 	var idLen2 int
+	var seqSize uint32
 	for i := 0; i < g.NumSeqs; i++ {
 		n, err = io.ReadFull(r.fhData, buf[:4])
 		if err != nil {
@@ -566,7 +606,9 @@ func (r *Reader) GenomeInfo(idx int) (*Genome, error) {
 		if n < 4 {
 			return nil, ErrBrokenFile
 		}
-		g.SeqSizes = append(g.SeqSizes, int(be.Uint32(buf[:4])))
+		seqSize = be.Uint32(buf[:4])
+		g.SeqSizes = append(g.SeqSizes, int(seqSize&maskSeqSize))
+		g.Circular = append(g.Circular, seqSize&flagCircular > 0)
 
 		// seq id
 		n, err = io.ReadFull(r.fhData, buf[:2])
@@ -602,6 +644,57 @@ func (r *Reader) GenomeInfo(idx int) (*Genome, error) {
 	return g, nil
 }
 
+// Gaps returns gap regions (runs of N's) in the concatenated sequence of a genome (idx is 0-based),
+// which are 0-based, with the end excluded. Nil is returned for files created before v1.1.
+func (r *Reader) Gaps(idx int) ([][2]int, error) {
+	if !r.hasGaps {
+		return nil, nil
+	}
+
+	// the data file is at the end of seq ids after reading the genome information
+	g, err := r.GenomeInfo(idx)
+	if err != nil {
+		return nil, err
+	}
+	RecycleGenome(g)
+
+	buf := r.buf
+	n, err := io.ReadFull(r.fhData, buf[:8])
+	if err != nil {
+		return nil, err
+	}
+	if n < 8 {
+		return nil, ErrBrokenFile
+	}
+	nBytes := int64(be.Uint32(buf[:4]))
+	if _, err = r.fhData.Seek(nBytes, io.SeekCurrent); err != nil {
+		return nil, err
+	}
+
+	br := r.bufReader
+	br.Reset(r.fhData)
+	n, err = io.ReadFull(br, buf[:4])
+	if err != nil {
+		return nil, err
+	}
+	if n < 4 {
+		return nil, ErrBrokenFile
+	}
+	nGaps := int(be.Uint32(buf[:4]))
+	gaps := make([][2]int, nGaps)
+	for i := range gaps {
+		n, err = io.ReadFull(br, buf[:8])
+		if err != nil {
+			return nil, err
+		}
+		if n < 8 {
+			return nil, ErrBrokenFile
+		}
+		gaps[i] = [2]int{int(be.Uint32(buf[:4])), int(be.Uint32(buf[4:8]))}
+	}
+	return gaps, nil
+}
+
 // SubSeq returns the subsequence of a genome (idx is 0-based),
 // from start to end (both are 0-based and included).
 // Please call RecycleGenome() after using the result.
@@ -695,8 +788,10 @@ func (r *Reader) SubSeq(idx int, start int, end int) (*Genome, error) {
 	// SeqSizes and SeqIDs
 	g.SeqSizes = g.SeqSizes[:0]
 	g.SeqIDs = g.SeqIDs[:0]
+	g.Circular = g.Circular[:0]
 	var j, nappend int
 	var idLen2 int
+	var seqSize uint32
 	for i := 0; i < g.NumSeqs; i++ {
 		n, err = io.ReadFull(br, buf[:4])
 		if err != nil {
@@ -705,7 +800,9 @@ func (r *Reader) SubSeq(idx int, start int, end int) (*Genome, error) {
 		if n < 4 {
 			return nil, ErrBrokenFile
 		}
-		g.SeqSizes = append(g.SeqSizes, int(be.Uint32(buf[:4])))
+		seqSize = be.Uint32(buf[:4])
+		g.SeqSizes = append(g.SeqSizes, int(seqSize&maskSeqSize))
+		g.Circular = append(g.Circular, seqSize&flagCircular > 0)
 
 		// seq id
 		n, err = io.ReadFull(br, buf[:2])
@@ -939,6 +1036,7 @@ func (r *Reader) SubSeq2(idx int, seqid []byte, start int, end int) (*Genome, in
 	// SeqSizes and SeqIDs
 	g.SeqSizes = g.SeqSizes[:0]
 	g.SeqIDs = g.SeqIDs[:0]
+	g.Circular = g.Circular[:0]
 	var j, nappend int
 	var idLen2 int
 
@@ -963,8 +1061,9 @@ func (r *Reader) SubSeq2(idx int, seqid []byte, start int, end int) (*Genome, in
 			return nil, -1, ErrBrokenFile
 		}
 
-		seqSize = int(be.Uint32(buf[:4]))
+		seqSize = int(be.Uint32(buf[:4]) & maskSeqSize)
 		g.SeqSizes = append(g.SeqSizes, seqSize)
+		g.Circular = append(g.Circular, be.Uint32(buf[:4])&flagCircular > 0)
 
 		// seq id
 		n, err = io.ReadFull(r.fhData, buf[:2])
@@ -24,6 +24,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -88,6 +89,7 @@ func TestReadAndWrite(t *testing.T) {
 		g.SeqSizes = append(g.SeqSizes, len(s))
 		seqid := []byte("test")
 		g.SeqIDs = append(g.SeqIDs, &seqid)
+		g.Circular = append(g.Circular, i&1 == 1)
 
 		err = w.Write(g)
 		if err != nil {
@@ -134,6 +136,17 @@ func TestReadAndWrite(t *testing.T) {
 			}
 		}
 
+		// sequence size and topology
+		s2, err = r.GenomeInfo(i)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if s2.SeqSizes[0] != len(s) || s2.Circular[0] != (i&1 == 1) {
+			t.Errorf("idx: %d, unexpected sequence size (%d) or topology (%v)", i, s2.SeqSizes[0], s2.Circular[0])
+		}
+		RecycleGenome(s2)
+
 		// whole seq
 		s2, err = r.Seq(i)
 		if err != nil {
@@ -162,3 +175,169 @@ func TestReadAndWrite(t *testing.T) {
 		return
 	}
 }
+
+func TestVersionCompatibility(t *testing.T) {
+	file := "t2.2bit"
+	defer func() {
+		os.RemoveAll(file)
+		os.RemoveAll(file + GenomeIndexFileExt)
+	}()
+
+	w, err := NewWriter(file, 1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	g := PoolGenome.Get().(*Genome)
+	g.Reset()
+	g.ID = append(g.ID, []byte("g1")...)
+	g.Seq = append(g.Seq, []byte("ACGTACGT")...)
+	g.GenomeSize = 8
+	g.Len = 8
+	g.NumSeqs = 1
+	g.SeqSizes = append(g.SeqSizes, 8)
+	seqid := []byte("s1")
+	g.SeqIDs = append(g.SeqIDs, &seqid)
+	g.Circular = append(g.Circular, true)
+	err = w.Write(g)
+	RecycleGenome(g)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	data, err := os.ReadFile(file + GenomeIndexFileExt)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// the main version is right after the magic number
+	for _, v := range []uint8{MainVersion - 1, MainVersion, MainVersion + 1} {
+		data[8] = v
+		if err = os.WriteFile(file+GenomeIndexFileExt, data, 0644); err != nil {
+			t.Error(err)
+			return
+		}
+		r, err := NewReader(file)
+		if v > MainVersion {
+			if err != ErrVersionMismatch {
+				t.Errorf("files of a newer main version (%d) should be refused, error: %v", v, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("files of main version %d should be readable: %s", v, err)
+			continue
+		}
+		r.Close()
+	}
+}
+
+func TestGaps(t *testing.T) {
+	file := "t3.2bit"
+	defer func() {
+		os.RemoveAll(file)
+		os.RemoveAll(file + GenomeIndexFileExt)
+	}()
+
+	seqs := []string{
+		"ACGTNNNNNACGTACGTNNNNNNNNACGT",
+		"ACGTACGTACGTACGT",
+		"NNNNNNACGT",
+	}
+	gapss := [][][2]int{
+		{{4, 9}, {17, 25}},
+		nil,
+		{{0, 6}},
+	}
+
+	w, err := NewWriter(file, 1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i, s := range seqs {
+		g := PoolGenome.Get().(*Genome)
+		g.Reset()
+		g.ID = append(g.ID, []byte(fmt.Sprintf("g%d", i+1))...)
+		g.Seq = append(g.Seq, []byte(s)...)
+		g.GenomeSize = len(s)
+		g.Len = len(s)
+		g.NumSeqs = 1
+		g.SeqSizes = append(g.SeqSizes, len(s))
+		seqid := []byte("s1")
+		g.SeqIDs = append(g.SeqIDs, &seqid)
+		g.Gaps = append(g.Gaps, gapss[i]...)
+		err = w.Write(g)
+		RecycleGenome(g)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	r, err := NewReader(file)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i, s := range seqs {
+		gaps, err := r.Gaps(i)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(gaps) != len(gapss[i]) {
+			t.Errorf("idx: %d, expected gaps: %v, results: %v", i, gapss[i], gaps)
+			continue
+		}
+		for j, gap := range gaps {
+			if gap != gapss[i][j] {
+				t.Errorf("idx: %d, expected gaps: %v, results: %v", i, gapss[i], gaps)
+				break
+			}
+		}
+
+		// sequences are not affected, where N's are saved as A's
+		g, err := r.Seq(i)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(g.Seq) != strings.ReplaceAll(s, "N", "A") {
+			t.Errorf("idx: %d, unexpected sequence: %s", i, g.Seq)
+		}
+		RecycleGenome(g)
+	}
+	r.Close()
+
+	// files of v1.0 have no gap data
+	data, err := os.ReadFile(file + GenomeIndexFileExt)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data[9] = 0 // the minor version
+	if err = os.WriteFile(file+GenomeIndexFileExt, data, 0644); err != nil {
+		t.Error(err)
+		return
+	}
+	r, err = NewReader(file)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if gaps, err := r.Gaps(0); err != nil || gaps != nil {
+		t.Errorf("no gaps expected for files of v1.0: %v, %v", gaps, err)
+	}
+	r.Close()
+}
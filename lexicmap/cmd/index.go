@@ -26,6 +26,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -56,6 +57,11 @@ Input:
      More precisely: $total_bases + ($num_contigs - 1) * 1000 <= 268,435,456, as we concatenate contigs with
      1000-bp intervals of N’s to reduce the sequence scale to index.
   6. A flag -l/--min-seq-len can filter out sequences shorter than the threshold (default is the k value).
+  7. An existing index can also be the input via the flag --from-index, for rebuilding an index with
+     new parameters (e.g., -k/--kmer, -m/--masks, and --seed-max-desert) without the original sequence files.
+     Genome IDs and the topology of sequences are kept, so search results stay comparable across indexes.
+     Gap regions (runs of at least 5 N's) are restored and excluded from seeds as in the original index,
+     while other degenerate bases and soft-masked (lowercase) bases are not saved in the index.
 
   Attention:
    *1) ► You can rename the sequence files for convenience, e.g., GCF_000017205.1.fa.gz, because the genome
@@ -65,6 +71,15 @@ Input:
        and are used to extract subsequences in the command "lexicmap utils subseq".
     2) ► Unwanted sequences like plasmids can be filtered out by content in FASTA/Q header via regular
        expressions (-B/--seq-name-filter).
+       ► Circular sequences (e.g., complete chromosomes and plasmids) can be recognized by a keyword
+       (--circular-keyword, e.g., circular) in FASTA/Q header, e.g., "circular", "circular=true", and
+       "[topology=circular]", or given in a list file (--circular-seqs). Alignments across the origin
+       of these sequences are reported as single HSPs in "lexicmap search". All sequences are
+       treated as linear by default.
+       ► Repetitive and low-complexity regions can be excluded from seeds, either soft-masked (lowercase)
+       bases (--soft-mask) or regions found by DUST (--dust). These regions are still saved in genome data
+       and aligned in "lexicmap search", but they contain no seeds, which reduces near-identical seeds
+       from highly repetitive regions (e.g., IS elements, rRNA operons).
     3) All degenerate bases are converted to their lexicographic first bases. E.g., N is converted to A.
         code  bases    saved
         A     A        A
@@ -86,6 +101,9 @@ Input:
 
         N     A/C/G/T  A
 
+       Gap regions (runs of at least 5 N's) are excluded from seeds, and their positions are saved
+       in the genome data, so they are restored as N's in "lexicmap utils export-genomes" and --from-index.
+
 Important parameters:
 
   --- Genome data ---
@@ -210,6 +228,7 @@ Important parameters:
 
 		inDir := getFlagString(cmd, "in-dir")
 		skipFileCheck := getFlagBool(cmd, "skip-file-check")
+		fromIndex := getFlagString(cmd, "from-index")
 
 		outDir = filepath.Clean(outDir)
 
@@ -217,6 +236,20 @@ Important parameters:
 			checkError(fmt.Errorf("intput and output paths should not be the same: %s", outDir))
 		}
 
+		readFromIndex := fromIndex != ""
+		if readFromIndex {
+			if filepath.Clean(fromIndex) == outDir {
+				checkError(fmt.Errorf("intput and output paths should not be the same: %s", outDir))
+			}
+			if inDir != "" || len(args) > 0 || getFlagString(cmd, "infile-list") != "" {
+				checkError(fmt.Errorf("flag --from-index is incompatible with input files or -I/--in-dir"))
+			}
+			ok, err := pathutil.DirExists(fromIndex)
+			if err != nil || !ok {
+				checkError(fmt.Errorf("index directory not found: %s", fromIndex))
+			}
+		}
+
 		readFromDir := inDir != ""
 		if readFromDir {
 			var isDir bool
@@ -268,6 +301,28 @@ Important parameters:
 			reSeqNames = append(reSeqNames, re)
 		}
 
+		circularKeyword := getFlagString(cmd, "circular-keyword")
+		var reCircular *regexp.Regexp
+		if circularKeyword != "" {
+			reCircular = circularKeywordRegexp(circularKeyword)
+		}
+
+		circularSeqsFile := getFlagString(cmd, "circular-seqs")
+		var circularSeqs map[string]interface{}
+		if circularSeqsFile != "" {
+			lines, err := getFileListFromFile(circularSeqsFile, false)
+			checkError(err)
+			circularSeqs = make(map[string]interface{}, len(lines))
+			for _, line := range lines {
+				circularSeqs[strings.Fields(line)[0]] = struct{}{}
+			}
+		}
+
+		softMask := getFlagBool(cmd, "soft-mask")
+		dust := getFlagBool(cmd, "dust")
+		dustWindow := getFlagPositiveInt(cmd, "dust-window")
+		dustThreshold := getFlagNonNegativeFloat64(cmd, "dust-threshold")
+
 		contigInterval := getFlagPositiveInt(cmd, "contig-interval")
 		if contigInterval < maxDesert {
 			checkError(fmt.Errorf("the value of --contig-interval (%d) should be >= -D/--seed-max-desert (%d)", contigInterval, maxDesert))
@@ -308,6 +363,12 @@ Important parameters:
 			DesertExpectedSeedDist: seedInDesertDist,     // expected distance between seeds
 			DesertSeedPosRange:     seedInDesertDist / 2, // the upstream and down stream region for adding a seeds
 
+			// masking repeats and low-complexity regions
+			SoftMask:      softMask,
+			Dust:          dust,
+			DustWindow:    dustWindow,
+			DustThreshold: dustThreshold,
+
 			// generate masks
 			// TopN:      topN,
 			// PrefixExt: prefixExt,
@@ -325,7 +386,12 @@ Important parameters:
 
 			ContigInterval: contigInterval,
 
+			ReCircular:   reCircular,
+			CircularSeqs: circularSeqs,
+
 			SaveSeedPositions: getFlagBool(cmd, "save-seed-pos"),
+
+			SourceIndex: fromIndex,
 		}
 		err = CheckIndexBuildingOptions(bopt)
 		checkError(err)
@@ -361,7 +427,10 @@ Important parameters:
 		}
 
 		var files []string
-		if readFromDir {
+		if readFromIndex {
+			files, err = indexGenomeIDs(fromIndex)
+			checkError(err)
+		} else if readFromDir {
 			files, err = getFileListFromDir(inDir, reFile, opt.NumCPUs)
 			if err != nil {
 				checkError(errors.Wrapf(err, "walking dir: %s", inDir))
@@ -378,6 +447,9 @@ Important parameters:
 			}
 		}
 		if len(files) < 1 {
+			if readFromIndex {
+				checkError(fmt.Errorf("no genomes found in the index: %s", fromIndex))
+			}
 			checkError(fmt.Errorf("FASTA/Q files needed"))
 		} else if len(files) > 1<<BITS_IDX { // 1<< 34
 			checkError(fmt.Errorf("at most %d files supported, given: %d", 1<<BITS_IDX, len(files)))
@@ -427,10 +499,20 @@ Important parameters:
 			log.Infof("--------------------- [ main parameters ] ---------------------")
 			log.Info()
 			log.Info("input and output:")
-			log.Infof("  input directory: %s", inDir)
+			if readFromIndex {
+				log.Infof("  input index: %s", fromIndex)
+			} else {
+				log.Infof("  input directory: %s", inDir)
+			}
 			log.Infof("    regular expression of input files: %s", reFileStr)
 			log.Infof("    *regular expression for extracting reference name from file name: %s", reRefNameStr)
 			log.Infof("    *regular expressions for filtering out sequences: %s", reSeqNameStrs)
+			if circularKeyword != "" {
+				log.Infof("  keyword of circular sequences: %s", circularKeyword)
+			}
+			if circularSeqsFile != "" {
+				log.Infof("  circular sequences: %d from %s", len(circularSeqs), circularSeqsFile)
+			}
 			log.Infof("  min sequence length: %d", minSeqLen)
 			log.Infof("  max genome size: %d", maxGenomeSize)
 			log.Infof("  output directory: %s", outDir)
@@ -457,6 +539,12 @@ Important parameters:
 				log.Infof("  maximum sketching desert length: %d", maxDesert)
 				log.Infof("  distance of k-mers to fill deserts: %d", seedInDesertDist)
 			}
+			if softMask {
+				log.Infof("  skip soft-masked (lowercase) bases: %v", softMask)
+			}
+			if dust {
+				log.Infof("  skip low-complexity regions found by DUST: window: %d, threshold: %.1f", dustWindow, dustThreshold)
+			}
 			log.Infof("  seeds data chunks: %d", chunks)
 			log.Infof("  seeds data indexing partitions: %d", partitions)
 			log.Info()
@@ -500,9 +588,18 @@ func init() {
 	indexCmd.Flags().StringSliceP("seq-name-filter", "B", []string{},
 		formatFlagUsage(`List of regular expressions for filtering out sequences by contents in FASTA/Q header/name, case ignored.`))
 
+	indexCmd.Flags().StringP("circular-keyword", "", "",
+		formatFlagUsage(`Keyword in FASTA/Q header for recognizing circular sequences, case ignored, e.g., "circular". Forms like "circular=true" and "topology=circular" are also supported.`))
+
+	indexCmd.Flags().StringP("circular-seqs", "", "",
+		formatFlagUsage(`A file of IDs of circular sequences, one ID per line.`))
+
 	indexCmd.Flags().BoolP("skip-file-check", "S", false,
 		formatFlagUsage(`Skip input file checking when given files or a file list.`))
 
+	indexCmd.Flags().StringP("from-index", "", "",
+		formatFlagUsage(`Rebuild an index from genomes in an existing index, rather than FASTA/Q files.`))
+
 	indexCmd.Flags().IntP("min-seq-len", "l", -1,
 		formatFlagUsage(`Maximum sequence length to index. The value would be k for values <= 0`))
 
@@ -548,6 +645,14 @@ func init() {
 		formatFlagUsage(`Maximum length of sketching deserts, or maximum seed distance. Deserts with seed distance larger than this value will be filled by choosing k-mers roughly every --seed-in-desert-dist bases.`))
 	indexCmd.Flags().IntP("seed-in-desert-dist", "d", 50,
 		formatFlagUsage(`Distance of k-mers to fill deserts.`))
+	indexCmd.Flags().BoolP("soft-mask", "", false,
+		formatFlagUsage(`Do not choose seeds from soft-masked (lowercase) bases, e.g., repeats masked by RepeatMasker. The sequences are still saved for alignment.`))
+	indexCmd.Flags().BoolP("dust", "", false,
+		formatFlagUsage(`Do not choose seeds from low-complexity regions found by DUST. The sequences are still saved for alignment.`))
+	indexCmd.Flags().IntP("dust-window", "", 64,
+		formatFlagUsage(`Window size of DUST for --dust.`))
+	indexCmd.Flags().Float64P("dust-threshold", "", 20,
+		formatFlagUsage(`Score threshold of DUST for --dust. Smaller values mask more regions.`))
 
 	// ------  generate mask from the top N biggest genomes
 
@@ -311,3 +311,92 @@ func TestKVData(t *testing.T) {
 		return
 	}
 }
+
+func TestMaxOccurrences(t *testing.T) {
+	var lenPrefix uint8 = 2
+	var k uint8 = 5
+	var prefix uint64 = 5 << ((k - lenPrefix) << 1)
+	nMasks := 2
+
+	// k-mers with even suffixes have 1 value, odd ones have 10 values
+	var n uint64 = 1 << ((k - lenPrefix) << 1)
+	var i, j uint64
+	data := make([]*map[uint64]*[]uint64, 0, nMasks)
+	for m := 0; m < nMasks; m++ {
+		d := make(map[uint64]*[]uint64, n)
+		for i = 0; i < n; i++ {
+			values := []uint64{i}
+			if i&1 == 1 {
+				for j = 1; j < 10; j++ {
+					values = append(values, i)
+				}
+			}
+			d[prefix|i] = &values
+		}
+		data = append(data, &d)
+	}
+
+	file := "t.maxocc.kv"
+	_, err := WriteKVData(k, 0, data, file, lenPrefix, 2)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer func() {
+		os.RemoveAll(file)
+		os.RemoveAll(filepath.Clean(file) + KVIndexFileExt)
+	}()
+
+	scr, err := NewSearcher(file)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer scr.Close()
+	scr2, err := NewInMemomrySearcher(file)
+	if err != nil {
+		t.Errorf("%s", err)
+		return
+	}
+	defer scr2.Close()
+	scr.MaxOccurrences = 5
+	scr2.MaxOccurrences = 5
+
+	kmers := make([]uint64, nMasks)
+	for i = 1; i < n-1; i++ {
+		for m := 0; m < nMasks; m++ {
+			kmers[m] = prefix | i
+		}
+		var expected int
+		if i&1 == 0 {
+			expected = nMasks
+		}
+
+		results, err := scr.Search(kmers, k, false, false)
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		if len(*results) != expected {
+			t.Errorf("%s: unexpected number of results: %d, expected: %d",
+				lexichash.MustDecode(prefix|i, k), len(*results), expected)
+		}
+		RecycleSearchResults(results)
+
+		results, err = scr2.Search(kmers, k, false, false)
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		if len(*results) != expected {
+			t.Errorf("%s: unexpected number of results from the in-memory searcher: %d, expected: %d",
+				lexichash.MustDecode(prefix|i, k), len(*results), expected)
+		}
+		RecycleSearchResults(results)
+	}
+
+	nDropped := uint64(nMasks) * (n - 2) / 2
+	if scr.Dropped != nDropped || scr2.Dropped != nDropped {
+		t.Errorf("unexpected number of dropped k-mers: %d, %d, expected: %d", scr.Dropped, scr2.Dropped, nDropped)
+	}
+}
@@ -54,6 +54,13 @@ type Searcher struct {
 	maxKmer uint64
 	buf     []byte
 	buf8    []uint8
+
+	// MaxOccurrences is the maximum number of values of a k-mer, 0 for no limit.
+	// Value lists of hub k-mers (e.g., from rRNA genes and transposases) longer than it
+	// are skipped without being read, as their lengths are stored ahead of the values.
+	MaxOccurrences uint64
+	// Dropped is the number of skipped k-mers because of MaxOccurrences.
+	Dropped uint64
 }
 
 // NewSearcher creates a new Searcher for the given kv-data file.
@@ -381,6 +388,10 @@ func (scr *Searcher) Search(kmers []uint64, p uint8, checkFlag bool, reversedKme
 				saveKmer = true
 				// }
 			}
+			if saveKmer && scr.MaxOccurrences > 0 && lenVal1 > scr.MaxOccurrences { // hub k-mer
+				saveKmer = false
+				scr.Dropped++
+			}
 			if saveKmer {
 				sr1 = poolSearchResult.Get().(*SearchResult)
 				sr1.IQuery = iQ + chunkIndex // do not forget to add mask offset
@@ -451,6 +462,10 @@ func (scr *Searcher) Search(kmers []uint64, p uint8, checkFlag bool, reversedKme
 				// }
 			}
 
+			if saveKmer && scr.MaxOccurrences > 0 && lenVal2 > scr.MaxOccurrences { // hub k-mer
+				saveKmer = false
+				scr.Dropped++
+			}
 			if saveKmer {
 				sr2 = poolSearchResult.Get().(*SearchResult)
 				sr2.IQuery = iQ + chunkIndex // do not forget to add mask offset
@@ -758,6 +773,10 @@ func (scr *Searcher) Search2(kmers []*[]uint64, p uint8, checkFlag bool, reverse
 					saveKmer = true
 					// }
 				}
+				if saveKmer && scr.MaxOccurrences > 0 && lenVal1 > scr.MaxOccurrences { // hub k-mer
+					saveKmer = false
+					scr.Dropped++
+				}
 				if saveKmer {
 					sr1 = poolSearchResult.Get().(*SearchResult)
 					sr1.IQuery = iQ + chunkIndex // do not forget to add mask offset
@@ -822,6 +841,10 @@ func (scr *Searcher) Search2(kmers []*[]uint64, p uint8, checkFlag bool, reverse
 					// }
 				}
 
+				if saveKmer && scr.MaxOccurrences > 0 && lenVal2 > scr.MaxOccurrences { // hub k-mer
+					saveKmer = false
+					scr.Dropped++
+				}
 				if saveKmer {
 					sr2 = poolSearchResult.Get().(*SearchResult)
 					sr2.IQuery = iQ + chunkIndex // do not forget to add mask offset
@@ -43,6 +43,11 @@ type InMemorySearcher struct {
 	getAnchor func(uint64) uint64
 
 	maxKmer uint64
+
+	// MaxOccurrences is the maximum number of values of a k-mer, 0 for no limit.
+	MaxOccurrences uint64
+	// Dropped is the number of skipped k-mers because of MaxOccurrences.
+	Dropped uint64
 }
 
 // NewSearcher creates a new Searcher for the given kv-data file.
@@ -129,6 +134,7 @@ func (scr *InMemorySearcher) Search(kmers []uint64, p uint8, checkFlag bool, rev
 	var found, saveKmer bool
 	// var mismatch uint8
 	var sr1 *SearchResult
+	var nValues uint64 // number of values of the current k-mer, including those of the other strand
 
 	var kmer uint64
 	prefixSearch := p < k
@@ -261,7 +267,7 @@ func (scr *InMemorySearcher) Search(kmers []uint64, p uint8, checkFlag bool, rev
 				if kmer1 != kmer0 || first { // new kmer
 					if sr1 != nil {
 						// fmt.Printf("  record new result: %p\n", sr1)
-						*results = append(*results, sr1) // previous one
+						scr.appendResult(results, sr1, nValues) // previous one
 					}
 
 					sr1 = poolSearchResult.Get().(*SearchResult)
@@ -271,12 +277,14 @@ func (scr *InMemorySearcher) Search(kmers []uint64, p uint8, checkFlag bool, rev
 					sr1.IsSuffix = reversedKmer
 					// sr1.Mismatch = mismatch
 					sr1.Values = sr1.Values[:0]
+					nValues = 0
 
 					//	fmt.Printf("  create new result: %p\n", sr1)
 
 					first = false
 				}
 
+				nValues++
 				if !checkFlag || data[i+1]&MASK_REVERSE == rvflag {
 					sr1.Values = append(sr1.Values, data[i+1])
 				}
@@ -293,7 +301,7 @@ func (scr *InMemorySearcher) Search(kmers []uint64, p uint8, checkFlag bool, rev
 			i += 2
 		}
 		if sr1 != nil {
-			*results = append(*results, sr1)
+			scr.appendResult(results, sr1, nValues)
 		}
 	}
 
@@ -340,6 +348,7 @@ func (scr *InMemorySearcher) Search2(kmers []*[]uint64, p uint8, checkFlag bool,
 	var found, saveKmer bool
 	// var mismatch uint8
 	var sr1 *SearchResult
+	var nValues uint64 // number of values of the current k-mer, including those of the other strand
 
 	var kmer uint64
 	prefixSearch := p < k
@@ -475,7 +484,7 @@ func (scr *InMemorySearcher) Search2(kmers []*[]uint64, p uint8, checkFlag bool,
 					if kmer1 != kmer0 || first { // new kmer
 						if sr1 != nil {
 							// fmt.Printf("  record new result: %p\n", sr1)
-							*results = append(*results, sr1) // previous one
+							scr.appendResult(results, sr1, nValues) // previous one
 						}
 
 						sr1 = poolSearchResult.Get().(*SearchResult)
@@ -486,11 +495,13 @@ func (scr *InMemorySearcher) Search2(kmers []*[]uint64, p uint8, checkFlag bool,
 						sr1.IQuery2 = iKmer
 						// sr1.Mismatch = mismatch
 						sr1.Values = sr1.Values[:0]
+						nValues = 0
 						// fmt.Printf("  create new result: %p\n", sr1)
 
 						first = false
 					}
 
+					nValues++
 					if !checkFlag || data[i+1]&MASK_REVERSE == rvflag {
 						// fmt.Printf("  save: %s, %d\n", lexichash.MustDecode(kmer1, k), data[i+1])
 						sr1.Values = append(sr1.Values, data[i+1])
@@ -508,7 +519,7 @@ func (scr *InMemorySearcher) Search2(kmers []*[]uint64, p uint8, checkFlag bool,
 				i += 2
 			}
 			if sr1 != nil {
-				*results = append(*results, sr1)
+				scr.appendResult(results, sr1, nValues)
 			}
 		}
 	}
@@ -516,6 +527,16 @@ func (scr *InMemorySearcher) Search2(kmers []*[]uint64, p uint8, checkFlag bool,
 	return results, nil
 }
 
+// appendResult appends a search result, or drops it if the k-mer has too many values.
+func (scr *InMemorySearcher) appendResult(results *[]*SearchResult, sr *SearchResult, nValues uint64) {
+	if scr.MaxOccurrences > 0 && nValues > scr.MaxOccurrences { // hub k-mer
+		scr.Dropped++
+		poolSearchResult.Put(sr)
+		return
+	}
+	*results = append(*results, sr)
+}
+
 // Close closes the searcher.
 func (scr *InMemorySearcher) Close() error {
 	return scr.rdr.Close()
@@ -108,11 +108,19 @@ type Chain2Result struct {
 	AlignedLength int     // Aligned length, might be longer than AlignedBasesQ or AlignedBasesT
 	Gaps          int     // The number of gaps
 
+	Score    int     // raw alignment score
+	BitScore float64 // bit score
+	Evalue   float64 // expect value
+
 	QBegin, QEnd int // Query begin/end position (0-based)
 	TBegin, TEnd int // Target begin/end position (0-based)
 
 	tPosOffsetBegin int // start position of the sequence in the concatenated genome
 
+	// for long reads, only set by AnnotateSegments
+	SegType    uint8       // type of the segment: primary, supplementary, or secondary
+	Breakpoint *Breakpoint // breakpoint between the previous segment and this one, nil for none
+
 	// for output
 	CIGAR     []byte // cigar string
 	QSeq      []byte // query seq
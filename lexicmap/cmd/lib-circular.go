@@ -0,0 +1,321 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/shenwei356/LexicMap/lexicmap/cmd/genome"
+	"github.com/shenwei356/wfa"
+)
+
+// circularEndDist is the maximum distance between an alignment and the end of a circular sequence
+// for trying to extend the alignment across the origin.
+const circularEndDist = 50
+
+// circularContainSlack is the tolerance of positions when checking if an HSP is contained
+// in an alignment across the origin.
+const circularContainSlack = 10
+
+// circularMinEndMatches is the minimum length of the last match of an alignment
+// for clipping the unmatched end.
+const circularMinEndMatches = 11
+
+// extendAcrossOrigin tries to extend an alignment close to either end of a circular sequence
+// across the origin, with the unaligned part of the query aligned to the other end of the sequence.
+//
+// Coordinates of an extended alignment are wrapped: TBegin is in [0, slen), while TEnd >= slen,
+// i.e., positions >= slen are on the next copy of the sequence.
+// For the negative strand, coordinates are still on the positive strand.
+//
+// It only returns the new alignment (along with the aligned query and target sequences)
+// if it crosses the origin and has a higher score than the original one,
+// and the chain is updated in place. Otherwise, nil is returned.
+func (idx *Index) extendAcrossOrigin(algn *wfa.Aligner, rdr *genome.Reader, refID int,
+	seqid []byte, slen int, rc bool, s []byte, c *Chain2Result, queryLen int) (*wfa.AlignmentResult, []byte, []byte) {
+
+	qlen := len(s)
+
+	// positions in the strand-oriented target sequence
+	b, e := c.TBegin, c.TEnd
+	if rc {
+		b, e = slen-1-c.TEnd, slen-1-c.TBegin
+	}
+
+	var qs, ts []byte
+	var qOff, tOff, junction int
+	var backward bool
+
+	if d, tail := slen-1-e, qlen-1-c.QEnd; d <= circularEndDist && tail > d { // extend the end
+		n := tail - d + tail/10 + 20
+		if n > b {
+			n = b
+		}
+		if n <= 0 {
+			return nil, nil, nil
+		}
+		ts = orientedSubSeq(rdr, refID, seqid, slen, rc, b, slen)
+		ts = append(ts, orientedSubSeq(rdr, refID, seqid, slen, rc, 0, n)...)
+		qs = s[c.QBegin:]
+		qOff, tOff, junction = c.QBegin, b, slen-b
+	} else if d, head := b, c.QBegin; d <= circularEndDist && head > d { // extend the beginning
+		n := head - d + head/10 + 20
+		if n > slen-1-e {
+			n = slen - 1 - e
+		}
+		if n <= 0 {
+			return nil, nil, nil
+		}
+		ts = orientedSubSeq(rdr, refID, seqid, slen, rc, slen-n, slen)
+		ts = append(ts, orientedSubSeq(rdr, refID, seqid, slen, rc, 0, e+1)...)
+		qs = s[:c.QEnd+1]
+		qOff, tOff, junction = 0, slen-n, n
+		backward = true
+	} else {
+		return nil, nil, nil
+	}
+
+	// global alignment, with the unmatched end clipped.
+	// For extending the beginning, sequences are reversed, so the beginning is clipped.
+	_qs, _ts := qs, ts
+	if backward {
+		_qs = reversed(qs)
+		_ts = reversed(ts)
+	}
+	cigar, err := algn.Align(_qs, _ts)
+	if err != nil {
+		checkError(fmt.Errorf("fail to align sequence"))
+	}
+	qn, tn := clippedAlignedBases(cigar.Ops)
+	wfa.RecycleAlignmentResult(cigar)
+	if qn == 0 || tn == 0 {
+		return nil, nil, nil
+	}
+
+	// 0-based positions in qs and ts
+	qb, qe, tb, te := 0, qn-1, 0, tn-1
+	if backward {
+		qb, qe = len(qs)-qn, len(qs)-1
+		tb, te = len(ts)-tn, len(ts)-1
+	}
+
+	if !(tb < junction && te >= junction) { // not across the origin
+		return nil, nil, nil
+	}
+
+	// global alignment of the final region
+	_qseq := s[qOff+qb : qOff+qe+1]
+	_tseq := ts[tb : te+1]
+	cigar, err = algn.Align(_qseq, _tseq)
+	if err != nil {
+		checkError(fmt.Errorf("fail to align sequence"))
+	}
+	if idx.opt.Scoring.RawScore(cigar.Ops) <= c.Score {
+		wfa.RecycleAlignmentResult(cigar)
+		return nil, nil, nil
+	}
+
+	// update the chain
+	c.QBegin, c.QEnd = qOff+qb, qOff+qe
+	b, e = tOff+tb, tOff+te // unrolled positions in the strand-oriented sequence
+	if rc {
+		c.TBegin, c.TEnd = 2*slen-1-e, 2*slen-1-b
+	} else {
+		c.TBegin, c.TEnd = b, e
+	}
+
+	c.AlignedBasesQ = c.QEnd - c.QBegin + 1
+	c.AlignedLength = int(cigar.AlignLen)
+	c.MatchedBases = int(cigar.Matches)
+	c.Gaps = int(cigar.Gaps)
+	c.AlignedFraction = float64(c.AlignedBasesQ) / float64(queryLen) * 100
+	if c.AlignedFraction > 100 {
+		c.AlignedFraction = 100
+	}
+	c.PIdent = float64(c.MatchedBases) / float64(cigar.AlignLen) * 100
+	idx.scoreChain(c, cigar.Ops, qlen)
+
+	return cigar, _qseq, _tseq
+}
+
+// clippedAlignedBases returns the numbers of aligned bases in the query and target
+// of a global alignment, after clipping the end after the last long match.
+func clippedAlignedBases(ops []*wfa.CIGARRecord) (qn, tn int) {
+	end := len(ops) - 1
+	for ; end >= 0; end-- {
+		if ops[end].Op == 'M' && int(ops[end].N) >= circularMinEndMatches {
+			break
+		}
+	}
+	for _, op := range ops[:end+1] {
+		switch op.Op {
+		case 'M', 'X':
+			qn += int(op.N)
+			tn += int(op.N)
+		case 'I':
+			tn += int(op.N)
+		case 'D', 'H':
+			qn += int(op.N)
+		}
+	}
+	return qn, tn
+}
+
+// orientedSubSeq returns a copy of the subsequence [start, end) of the strand-oriented sequence.
+func orientedSubSeq(rdr *genome.Reader, refID int, seqid []byte, slen int, rc bool, start, end int) []byte {
+	if rc {
+		start, end = slen-end, slen-start
+	}
+	tSeq, _, err := rdr.SubSeq2(refID, seqid, start, end-1)
+	if err != nil {
+		checkError(fmt.Errorf("failed to read subsequence: %s", err))
+	}
+	seq := make([]byte, len(tSeq.Seq))
+	copy(seq, tSeq.Seq)
+	genome.RecycleGenome(tSeq)
+	if rc {
+		RC(seq)
+	}
+	return seq
+}
+
+// reversed returns a reversed copy of a sequence.
+func reversed(s []byte) []byte {
+	r := make([]byte, len(s))
+	for i, j := 0, len(s)-1; j >= 0; i, j = i+1, j-1 {
+		r[i] = s[j]
+	}
+	return r
+}
+
+// removeHSPsInWrappedOnes removes HSPs contained in alignments across the origin of circular
+// sequences, i.e., the partial alignments at both ends of the sequences.
+// Empty SimilarityDetails are removed, and the others with alignments across the origin are updated.
+func removeHSPsInWrappedOnes(sds *[]*SimilarityDetail, queryLen int, sortByBitScore bool) {
+	var hasWrapped bool
+	for _, sd := range *sds {
+		for _, c := range *sd.Similarity.Chains {
+			if c != nil && c.TEnd >= sd.SeqLen {
+				hasWrapped = true
+				break
+			}
+		}
+	}
+	if !hasWrapped {
+		return
+	}
+
+	updated := make(map[*SimilarityDetail]interface{}, len(*sds))
+	for _, sd1 := range *sds {
+		for _, c1 := range *sd1.Similarity.Chains {
+			if c1 == nil || c1.TEnd < sd1.SeqLen {
+				continue
+			}
+			updated[sd1] = struct{}{}
+
+			for _, sd2 := range *sds {
+				if sd2.RC != sd1.RC || !bytes.Equal(sd2.SeqID, sd1.SeqID) {
+					continue
+				}
+				for i, c2 := range *sd2.Similarity.Chains {
+					if c2 == nil || c2 == c1 || !containedInWrapped(c1, c2, sd1.SeqLen) {
+						continue
+					}
+					poolChain2.Put(c2)
+					(*sd2.Similarity.Chains)[i] = nil
+					updated[sd2] = struct{}{}
+				}
+			}
+		}
+	}
+
+	j := 0
+	for _, sd := range *sds {
+		if _, ok := updated[sd]; ok && !updateSimilarityDetail(sd, queryLen, sortByBitScore) {
+			RecycleSeqComparatorResult(sd.Similarity)
+			poolSimilarityDetail.Put(sd)
+			continue
+		}
+		(*sds)[j] = sd
+		j++
+	}
+	*sds = (*sds)[:j]
+}
+
+// containedInWrapped checks if an HSP is contained in an alignment across the origin.
+func containedInWrapped(w, c *Chain2Result, slen int) bool {
+	if c.QBegin < w.QBegin-circularContainSlack || c.QEnd > w.QEnd+circularContainSlack {
+		return false
+	}
+	if c.TBegin >= w.TBegin-circularContainSlack && c.TEnd <= w.TEnd+circularContainSlack {
+		return true
+	}
+	// on the next copy of the sequence
+	return c.TBegin+slen >= w.TBegin-circularContainSlack && c.TEnd+slen <= w.TEnd+circularContainSlack
+}
+
+// updateSimilarityDetail recomputes the aligned bases and the similarity score
+// from valid chains, it returns false if there's no valid chains.
+func updateSimilarityDetail(sd *SimilarityDetail, queryLen int, sortByBitScore bool) bool {
+	r := sd.Similarity
+
+	var first *Chain2Result
+	var maxBitScore float64
+	r.MatchedBases = 0
+	regions := poolRegions.Get().(*[]*[2]int)
+	*regions = (*regions)[:0]
+	for _, c := range *r.Chains {
+		if c == nil {
+			continue
+		}
+		if first == nil {
+			first = c
+		}
+		if c.BitScore > maxBitScore {
+			maxBitScore = c.BitScore
+		}
+		region := poolRegion.Get().(*[2]int)
+		region[0], region[1] = c.QBegin, c.QEnd
+		*regions = append(*regions, region)
+
+		r.MatchedBases += c.MatchedBases
+	}
+	r.AlignedBases = coverageLen(regions)
+	recycleRegions(regions)
+
+	if first == nil {
+		return false
+	}
+
+	r.AlignedFraction = float64(r.AlignedBases) / float64(queryLen) * 100
+	r.PIdent = float64(r.MatchedBases) / float64(r.AlignedBases) * 100
+	if r.PIdent > 100 {
+		r.PIdent = 100
+	}
+
+	if sortByBitScore {
+		sd.SimilarityScore = maxBitScore
+	} else {
+		sd.SimilarityScore = float64(r.AlignedBases) * first.PIdent
+	}
+	return true
+}
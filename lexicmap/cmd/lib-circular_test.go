@@ -0,0 +1,168 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/shenwei356/LexicMap/lexicmap/cmd/genome"
+	"github.com/shenwei356/wfa"
+)
+
+func TestExtendAcrossOrigin(t *testing.T) {
+	// a circular sequence
+	slen := 1000
+	r := rand.New(rand.NewSource(1))
+	seq := make([]byte, slen)
+	for i := range seq {
+		seq[i] = "ACGT"[r.Intn(4)]
+	}
+
+	file := filepath.Join(t.TempDir(), "genomes.bin")
+	w, err := genome.NewWriter(file, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := genome.PoolGenome.Get().(*genome.Genome)
+	g.Reset()
+	g.ID = append(g.ID, []byte("g1")...)
+	g.Seq = append(g.Seq, seq...)
+	g.GenomeSize = slen
+	g.Len = slen
+	g.NumSeqs = 1
+	g.SeqSizes = append(g.SeqSizes, slen)
+	seqid := []byte("s1")
+	g.SeqIDs = append(g.SeqIDs, &seqid)
+	g.Circular = append(g.Circular, true)
+	if err = w.Write(g); err != nil {
+		t.Fatal(err)
+	}
+	genome.RecycleGenome(g)
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rdr, err := genome.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Close()
+
+	idx := &Index{opt: &IndexSearchingOptions{Scoring: DefaultScoringOptions}, totalBases: int64(slen)}
+	algn := wfa.New(wfa.DefaultPenalties, &wfa.Options{GlobalAlignment: true})
+
+	// the query spans the origin: seq[900:1000] + seq[0:100]
+	query := append(append([]byte{}, seq[900:]...), seq[:100]...)
+	queryRC := append([]byte{}, query...)
+	RC(queryRC)
+
+	tests := []struct {
+		name  string
+		query []byte
+		rc    bool
+		hsp   [4]int // QBegin, QEnd, TBegin, TEnd of the partial HSP
+		ok    bool
+		want  [4]int
+	}{
+		{"extend the end", query, false, [4]int{0, 99, 900, 999}, true, [4]int{0, 199, 900, 1099}},
+		{"extend the beginning", query, false, [4]int{100, 199, 0, 99}, true, [4]int{0, 199, 900, 1099}},
+		{"extend the end, negative strand", queryRC, true, [4]int{0, 99, 0, 99}, true, [4]int{0, 199, 900, 1099}},
+		{"extend the beginning, negative strand", queryRC, true, [4]int{100, 199, 900, 999}, true, [4]int{0, 199, 900, 1099}},
+		{"far from the ends", seq[400:600], false, [4]int{0, 199, 400, 599}, false, [4]int{0, 199, 400, 599}},
+	}
+
+	for _, test := range tests {
+		c := &Chain2Result{QBegin: test.hsp[0], QEnd: test.hsp[1], TBegin: test.hsp[2], TEnd: test.hsp[3]}
+		c.Score = 2 * (c.QEnd - c.QBegin + 1)
+
+		cigar, _, _ := idx.extendAcrossOrigin(algn, rdr, 0, seqid, slen, test.rc, test.query, c, len(test.query))
+		if (cigar != nil) != test.ok {
+			t.Errorf("%s: extended: %v, expected: %v", test.name, cigar != nil, test.ok)
+			continue
+		}
+		if cigar != nil {
+			wfa.RecycleAlignmentResult(cigar)
+		}
+		got := [4]int{c.QBegin, c.QEnd, c.TBegin, c.TEnd}
+		if got != test.want {
+			t.Errorf("%s: unexpected HSP: %v, expected: %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRemoveHSPsInWrappedOnes(t *testing.T) {
+	newSD := func(seqid string, rc bool, slen int, hsps ...[4]int) *SimilarityDetail {
+		chains := make([]*Chain2Result, 0, len(hsps))
+		for _, h := range hsps {
+			chains = append(chains, &Chain2Result{QBegin: h[0], QEnd: h[1], TBegin: h[2], TEnd: h[3],
+				MatchedBases: h[1] - h[0] + 1, PIdent: 100})
+		}
+		return &SimilarityDetail{RC: rc, SeqID: []byte(seqid), SeqLen: slen,
+			Similarity: &SeqComparatorResult{Chains: &chains}}
+	}
+
+	// a query of 200 bp spanning the origin of a sequence of 1000 bp
+	wrapped := [4]int{0, 199, 900, 1099}
+	tail := [4]int{0, 99, 900, 999}
+	head := [4]int{100, 199, 0, 99}
+	other := [4]int{0, 99, 500, 599}
+
+	tests := []struct {
+		name string
+		sds  []*SimilarityDetail
+		want []int // numbers of remaining HSPs in each SimilarityDetail
+	}{
+		{"partial HSPs at both ends",
+			[]*SimilarityDetail{newSD("s1", false, 1000, wrapped, tail, head)}, []int{1}},
+		{"partial HSPs in another SimilarityDetail",
+			[]*SimilarityDetail{newSD("s1", false, 1000, wrapped), newSD("s1", false, 1000, tail, head)}, []int{1}},
+		{"HSPs elsewhere",
+			[]*SimilarityDetail{newSD("s1", false, 1000, wrapped, other)}, []int{2}},
+		{"different strands",
+			[]*SimilarityDetail{newSD("s1", false, 1000, wrapped), newSD("s1", true, 1000, tail)}, []int{1, 1}},
+		{"different sequences",
+			[]*SimilarityDetail{newSD("s1", false, 1000, wrapped), newSD("s2", false, 1000, tail)}, []int{1, 1}},
+		{"no alignments across the origin",
+			[]*SimilarityDetail{newSD("s1", false, 1000, tail, head)}, []int{2}},
+	}
+
+	for _, test := range tests {
+		sds := test.sds
+		removeHSPsInWrappedOnes(&sds, 200, false)
+		if len(sds) != len(test.want) {
+			t.Errorf("%s: %d SimilarityDetails remained, expected: %d", test.name, len(sds), len(test.want))
+			continue
+		}
+		for i, sd := range sds {
+			var n int
+			for _, c := range *sd.Similarity.Chains {
+				if c != nil {
+					n++
+				}
+			}
+			if n != test.want[i] {
+				t.Errorf("%s: %d HSPs remained in SimilarityDetail #%d, expected: %d", test.name, n, i+1, test.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// SeqCoverage records alignments in a sequence, and computes the sequencing depth
+// by sweeping the sorted start and end positions of the alignments,
+// so the memory is proportional to the number of alignments rather than the sequence length.
+type SeqCoverage struct {
+	Len int // sequence length
+
+	starts []int // 0-based start positions
+	ends   []int // 0-based end positions, exclusive
+	sorted bool
+}
+
+// NewSeqCoverage creates a SeqCoverage for a sequence of the given length.
+func NewSeqCoverage(length int) *SeqCoverage {
+	return &SeqCoverage{Len: length, starts: make([]int, 0, 8), ends: make([]int, 0, 8)}
+}
+
+// Add adds an alignment in the region of [start, end), with 0-based coordinates.
+// Regions exceeding the end of the sequence (alignments across the origin of
+// circular sequences) are wrapped to the start of the sequence.
+func (c *SeqCoverage) Add(start, end int) {
+	if start < 0 {
+		start = 0
+	}
+	if start >= end || start >= c.Len {
+		return
+	}
+	if end > c.Len {
+		c.Add(0, min(end-c.Len, start)) // the wrapped part
+		end = c.Len
+	}
+	c.starts = append(c.starts, start)
+	c.ends = append(c.ends, end)
+	c.sorted = false
+}
+
+// Runs calls fn for each run of positions with the same depth, including those with a depth of 0.
+// Coordinates are 0-based, and the end is exclusive, i.e., the same as BED format.
+func (c *SeqCoverage) Runs(fn func(start, end int, depth int32)) {
+	if c.Len <= 0 {
+		return
+	}
+	if !c.sorted {
+		sort.Ints(c.starts)
+		sort.Ints(c.ends)
+		c.sorted = true
+	}
+
+	var i, j, pos, next, start int
+	var depth, pre int32
+	for pos < c.Len {
+		for i < len(c.starts) && c.starts[i] == pos {
+			depth++
+			i++
+		}
+		for j < len(c.ends) && c.ends[j] == pos {
+			depth--
+			j++
+		}
+		if pos > 0 && depth != pre {
+			fn(start, pos, pre)
+			start = pos
+		}
+		pre = depth
+
+		// the next position where the depth might change
+		next = c.Len
+		if i < len(c.starts) && c.starts[i] < next {
+			next = c.starts[i]
+		}
+		if j < len(c.ends) && c.ends[j] < next {
+			next = c.ends[j]
+		}
+		pos = next
+	}
+	fn(start, c.Len, pre)
+}
+
+// Stats returns the number of covered positions, and the sum of depths of all positions.
+func (c *SeqCoverage) Stats() (covered int, bases int64) {
+	c.Runs(func(start, end int, depth int32) {
+		if depth > 0 {
+			covered += end - start
+			bases += int64(depth) * int64(end-start)
+		}
+	})
+	return covered, bases
+}
+
+// SAMAlignment is the region of a SAM record in the reference sequence.
+type SAMAlignment struct {
+	SeqID string // reference sequence ID
+	Start int    // 0-based start position
+	End   int    // 0-based end position, exclusive
+}
+
+// ParseSAMRecord parses the aligned region of a SAM record. The second returned value
+// is false for header lines, unmapped records, and secondary alignments (FLAG 0x100),
+// the latter of which would inflate the depth of multi-mapped reads.
+func ParseSAMRecord(line []byte) (*SAMAlignment, bool, error) {
+	if len(line) == 0 || line[0] == '@' {
+		return nil, false, nil
+	}
+
+	var fields [6][]byte // QNAME, FLAG, RNAME, POS, MAPQ, CIGAR
+	var i, j int
+	record := line
+	for i = 0; i < 6; i++ {
+		j = bytes.IndexByte(line, '\t')
+		if j < 0 {
+			if i < 5 {
+				return nil, false, fmt.Errorf("invalid SAM record with less than 6 columns: %s", record)
+			}
+			j = len(line) // the last needed column
+		}
+		fields[i] = line[:j]
+		if j < len(line) {
+			line = line[j+1:]
+		}
+	}
+
+	flag, err := strconv.Atoi(string(fields[1]))
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid FLAG of SAM record: %s", fields[1])
+	}
+	if flag&4 > 0 || string(fields[2]) == "*" || string(fields[5]) == "*" { // unmapped
+		return nil, false, nil
+	}
+	if flag&0x100 > 0 { // secondary alignment
+		return nil, false, nil
+	}
+
+	pos, err := strconv.Atoi(string(fields[3]))
+	if err != nil || pos < 1 {
+		return nil, false, fmt.Errorf("invalid POS of SAM record: %s", fields[3])
+	}
+
+	// reference length from the CIGAR, only M, D, N, =, and X consume the reference
+	var n, rlen int
+	for _, b := range fields[5] {
+		if b >= '0' && b <= '9' {
+			n = n*10 + int(b-'0')
+			continue
+		}
+		switch b {
+		case 'M', 'D', 'N', '=', 'X':
+			rlen += n
+		case 'I', 'S', 'H', 'P':
+		default:
+			return nil, false, fmt.Errorf("invalid CIGAR of SAM record: %s", fields[5])
+		}
+		n = 0
+	}
+
+	return &SAMAlignment{SeqID: string(fields[2]), Start: pos - 1, End: pos - 1 + rlen}, true, nil
+}
@@ -0,0 +1,123 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSeqCoverage(t *testing.T) {
+	c := NewSeqCoverage(10)
+	c.Add(0, 4)
+	c.Add(2, 6)
+	c.Add(8, 12) // across the origin: 8-9 and 0-1
+
+	depths := []int32{2, 2, 2, 2, 1, 1, 0, 0, 1, 1}
+	var n int
+	c.Runs(func(start, end int, depth int32) {
+		for i := start; i < end; i++ {
+			if depth != depths[i] {
+				t.Errorf("unexpected depth at %d: %d, expected: %d", i, depth, depths[i])
+			}
+		}
+		n += end - start
+	})
+	if n != 10 {
+		t.Errorf("runs cover %d positions, expected: 10", n)
+	}
+
+	covered, bases := c.Stats()
+	if covered != 8 || bases != 12 {
+		t.Errorf("unexpected stats: %d covered positions and %d bases, expected: 8 and 12", covered, bases)
+	}
+}
+
+func TestSeqCoverageRuns(t *testing.T) {
+	c := NewSeqCoverage(10)
+	c.Add(0, 3)
+	c.Add(3, 5)  // adjacent to the previous one, the run should be merged
+	c.Add(7, 10) // to the end
+	c.Add(7, 8)
+
+	type run struct {
+		start, end int
+		depth      int32
+	}
+	expected := []run{{0, 5, 1}, {5, 7, 0}, {7, 8, 2}, {8, 10, 1}}
+	runs := make([]run, 0, len(expected))
+	c.Runs(func(start, end int, depth int32) {
+		runs = append(runs, run{start, end, depth})
+	})
+	if len(runs) != len(expected) {
+		t.Fatalf("unexpected runs: %v, expected: %v", runs, expected)
+	}
+	for i, r := range runs {
+		if r != expected[i] {
+			t.Errorf("unexpected run #%d: %v, expected: %v", i+1, r, expected[i])
+		}
+	}
+
+	empty := NewSeqCoverage(5)
+	covered, bases := empty.Stats()
+	if covered != 0 || bases != 0 {
+		t.Errorf("unexpected stats of an uncovered sequence: %d and %d", covered, bases)
+	}
+}
+
+func TestParseSAMRecord(t *testing.T) {
+	a, ok, err := ParseSAMRecord([]byte("r1\t0\tseq1\t101\t60\t5S10M2D3M1I4M\t*\t0\t0\tACGT\t*"))
+	if err != nil || !ok {
+		t.Fatalf("failed to parse a SAM record: %v", err)
+	}
+	if a.SeqID != "seq1" || a.Start != 100 || a.End != 119 {
+		t.Errorf("unexpected alignment: %s:%d-%d, expected: seq1:100-119", a.SeqID, a.Start, a.End)
+	}
+
+	for _, line := range []string{
+		"@SQ\tSN:seq1\tLN:1000",
+		"r2\t4\t*\t0\t0\t*\t*\t0\t0\tACGT\t*",
+		"r1\t256\tseq2\t11\t0\t10M\t*\t0\t0\t*\t*",
+	} {
+		if _, ok, err = ParseSAMRecord([]byte(line)); ok || err != nil {
+			t.Errorf("headers, unmapped records, and secondary alignments should be skipped: %s", line)
+		}
+	}
+
+	// supplementary alignments are kept
+	a, ok, err = ParseSAMRecord([]byte("r1\t2048\tseq2\t11\t60\t10M5H\t*\t0\t0\tACGT\t*"))
+	if err != nil || !ok {
+		t.Fatalf("failed to parse a supplementary alignment: %v", err)
+	}
+	if a.SeqID != "seq2" || a.Start != 10 || a.End != 20 {
+		t.Errorf("unexpected alignment: %s:%d-%d, expected: seq2:10-20", a.SeqID, a.Start, a.End)
+	}
+
+	// only the first 6 columns are needed
+	if a, ok, err = ParseSAMRecord([]byte("r3\t0\tseq1\t1\t60\t8M")); err != nil || !ok || a.End != 8 {
+		t.Errorf("failed to parse a SAM record with 6 columns: %v", err)
+	}
+	if _, _, err = ParseSAMRecord([]byte("r3\t0\tseq1\t1\t60")); err == nil {
+		t.Errorf("a SAM record with 5 columns should be rejected")
+	} else if !strings.Contains(err.Error(), "less than 6 columns") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
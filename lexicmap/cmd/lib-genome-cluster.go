@@ -0,0 +1,333 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/shenwei356/LexicMap/lexicmap/cmd/genome"
+	"github.com/shenwei356/LexicMap/lexicmap/cmd/kv"
+)
+
+// IndexSeedSharing stores the numbers of masks sharing k-mers between all pairs of genomes in an index,
+// which are counted from genome indexes (batch+genome) in values of the seed data, with no alignment.
+type IndexSeedSharing struct {
+	K     int
+	IDs   []string // genome IDs, in the order of the index
+	Sizes []int    // genome sizes, summed up for chunks of split genomes
+	Masks []int    // numbers of masks capturing k-mers of genomes
+
+	Shared map[uint64]uint32 // i<<32 | j (i < j) -> number of masks with k-mers shared by genome i and j
+
+	SkippedSeeds int // seeds (k-mers) shared by too many genomes, which are not used for counting shared masks
+}
+
+// NewIndexSeedSharing counts shared masks between all pairs of genomes in an index.
+// Seed data files are read in parallel with the given number of threads.
+// Chunks of split big genomes are regarded as one genome.
+// As the number of genome pairs of a seed grows quadratically, seeds shared by more than
+// maxGenomesPerSeed genomes are skipped in counting shared masks, 0 for no limit.
+func NewIndexSeedSharing(dbDir string, threads int, maxGenomesPerSeed int) (*IndexSeedSharing, error) {
+	info, err := readIndexInfo(filepath.Join(dbDir, FileInfo))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read info file: %s", err)
+	}
+
+	ids, err := indexGenomeIDs(dbDir)
+	if err != nil {
+		return nil, err
+	}
+	name2idx, err := readGenomeMapName2Idx(filepath.Join(dbDir, FileGenomeIndex))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read genomes index mapping file: %s", err)
+	}
+
+	n := len(ids)
+	if n > math.MaxUint32 {
+		return nil, fmt.Errorf("too many genomes: %d", n)
+	}
+
+	// batch+genome index (of all chunks) -> genome index in ids
+	chunk2genome := make(map[uint64]int, len(name2idx))
+	for i, id := range ids {
+		for _, batchIDAndRefID := range *name2idx[id] {
+			chunk2genome[batchIDAndRefID] = i
+		}
+	}
+
+	s := &IndexSeedSharing{
+		K:      int(info.K),
+		IDs:    ids,
+		Sizes:  make([]int, n),
+		Masks:  make([]int, n),
+		Shared: make(map[uint64]uint32, n<<2),
+	}
+
+	// ----------------------------------------------------------------
+	// genome sizes
+
+	// sizes of split genomes are summed up from all chunks, which might be in different batches.
+	batch2chunks := make(map[int][]uint64, info.GenomeBatches) // batch -> batch+genome indexes
+	for batchIDAndRefID := range chunk2genome {
+		batch := int(batchIDAndRefID >> BITS_GENOME_IDX)
+		batch2chunks[batch] = append(batch2chunks[batch], batchIDAndRefID)
+	}
+	for batch, chunks := range batch2chunks {
+		rdr, err := genome.NewReader(filepath.Join(dbDir, DirGenomes, batchDir(batch), FileGenomes))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read genome data file: %s", err)
+		}
+		for _, batchIDAndRefID := range chunks {
+			i := chunk2genome[batchIDAndRefID]
+			g, err := rdr.GenomeInfo(int(batchIDAndRefID & MASK_GENOME_IDX))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read genome info of %s: %s", ids[i], err)
+			}
+			s.Sizes[i] += g.GenomeSize
+			genome.RecycleGenome(g)
+		}
+		err = rdr.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to close genome data file: %s", err)
+		}
+	}
+
+	// ----------------------------------------------------------------
+	// shared masks
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	tokens := make(chan int, max(1, threads))
+	errs := make(chan error, info.Chunks)
+	for chunk := 0; chunk < info.Chunks; chunk++ {
+		wg.Add(1)
+		tokens <- 1
+		go func(chunk int) {
+			defer func() {
+				<-tokens
+				wg.Done()
+			}()
+
+			file := filepath.Join(dbDir, DirSeeds, chunkFile(chunk))
+			rdr, err := kv.NewReader(file)
+			if err != nil {
+				errs <- fmt.Errorf("failed to read kv-data file: %s", err)
+				return
+			}
+			defer rdr.Close()
+
+			masks := make([]int, n)
+			shared := make(map[uint64]uint32, n<<2)
+			var skipped int
+
+			pairsInMask := make(map[uint64]interface{}, 1024)
+			lastMask := make([]int, n) // for counting a genome once in a mask
+			lastKmer := make([]int, n) // for counting a genome once for a k-mer
+			var iKmer int
+			gs := make([]int, 0, 128) // genomes having a k-mer
+			var i, g, a, b int
+			var key, chunkIdx uint64
+			var ok bool
+
+			for c := 1; c <= rdr.ChunkSize; c++ { // for all mask, 0 is the initial value of lastMask
+				m, err := rdr.ReadDataOfAMaskAsMap()
+				if err != nil {
+					errs <- fmt.Errorf("failed to read data of mask %d from file %s: %s", c-1+rdr.ChunkIndex, file, err)
+					return
+				}
+
+				clear(pairsInMask)
+				for _, values := range *m {
+					iKmer++
+					gs = gs[:0]
+					for _, v := range *values {
+						if v&MASK_REVERSE > 0 { // reversed k-mers for suffix matching
+							continue
+						}
+						chunkIdx = v >> BITS_NONE_IDX
+						if g, ok = chunk2genome[chunkIdx]; !ok {
+							kv.RecycleKmerData(m)
+							errs <- fmt.Errorf("genome of batch %d and index %d not found in the genomes index mapping file",
+								chunkIdx>>BITS_GENOME_IDX, chunkIdx&MASK_GENOME_IDX)
+							return
+						}
+						if lastKmer[g] == iKmer {
+							continue
+						}
+						lastKmer[g] = iKmer
+						gs = append(gs, g)
+
+						if lastMask[g] != c {
+							lastMask[g] = c
+							masks[g]++
+						}
+					}
+
+					if maxGenomesPerSeed > 0 && len(gs) > maxGenomesPerSeed {
+						skipped++
+						continue
+					}
+
+					sort.Ints(gs)
+					for i, a = range gs[:max(0, len(gs)-1)] {
+						for _, b = range gs[i+1:] {
+							key = uint64(a)<<32 | uint64(b)
+							if _, ok = pairsInMask[key]; ok {
+								continue
+							}
+							pairsInMask[key] = struct{}{}
+							shared[key]++
+						}
+					}
+				}
+				kv.RecycleKmerData(m)
+			}
+
+			mu.Lock()
+			for i, c := range masks {
+				s.Masks[i] += c
+			}
+			for key, c := range shared {
+				s.Shared[key] += c
+			}
+			s.SkippedSeeds += skipped
+			mu.Unlock()
+		}(chunk)
+	}
+	wg.Wait()
+	close(errs)
+	for err = range errs {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Jaccard returns the estimated Jaccard index of genome i and j,
+// i.e., the fraction of masks with shared k-mers.
+func (s *IndexSeedSharing) Jaccard(i, j int) float64 {
+	if i == j {
+		return 1
+	}
+	if i > j {
+		i, j = j, i
+	}
+	shared := s.Shared[uint64(i)<<32|uint64(j)]
+	if shared == 0 {
+		return 0
+	}
+	masks := max(s.Masks[i], s.Masks[j])
+	return min(1, float64(shared)/float64(masks))
+}
+
+// ANI returns the estimated ANI of genome i and j, computed in the same way as utils dist,
+// with the larger genome as the query to make it symmetric.
+func (s *IndexSeedSharing) ANI(i, j int) float64 {
+	qSize, gSize := s.Sizes[i], s.Sizes[j]
+	if qSize < gSize {
+		qSize, gSize = gSize, qSize
+	}
+	return jaccard2ANI(s.Jaccard(i, j), qSize, gSize, s.K)
+}
+
+// Neighbors returns genomes with ANI >= minANI of each genome.
+func (s *IndexSeedSharing) Neighbors(minANI float64) [][]int {
+	neighbors := make([][]int, len(s.IDs))
+	var i, j int
+	for key := range s.Shared {
+		i, j = int(key>>32), int(key&math.MaxUint32)
+		if s.ANI(i, j) < minANI {
+			continue
+		}
+		neighbors[i] = append(neighbors[i], j)
+		neighbors[j] = append(neighbors[j], i)
+	}
+	for _, ns := range neighbors {
+		sort.Ints(ns)
+	}
+	return neighbors
+}
+
+// clusterGenomesGreedy clusters genomes greedily in the given order, a genome is assigned
+// to the most similar representative among its neighbors, or becomes a new representative.
+// It returns the representative of each genome.
+func clusterGenomesGreedy(order []int, neighbors [][]int, ani func(i, j int) float64) []int {
+	reps := make([]int, len(neighbors))
+	for i := range reps {
+		reps[i] = -1
+	}
+
+	var best int
+	var v, bestANI float64
+	for _, i := range order {
+		best, bestANI = -1, -1
+		for _, j := range neighbors[i] {
+			if reps[j] != j { // not a representative, or not assigned yet
+				continue
+			}
+			v = ani(i, j)
+			if v > bestANI {
+				best, bestANI = j, v
+			}
+		}
+		if best < 0 {
+			reps[i] = i
+		} else {
+			reps[i] = best
+		}
+	}
+	return reps
+}
+
+// clusterGenomesConnected clusters genomes into connected components of the similarity graph,
+// the first genome of each component in the given order is chosen as the representative.
+// It returns the representative of each genome.
+func clusterGenomesConnected(order []int, neighbors [][]int) []int {
+	reps := make([]int, len(neighbors))
+	for i := range reps {
+		reps[i] = -1
+	}
+
+	stack := make([]int, 0, 128)
+	var i int
+	for _, rep := range order {
+		if reps[rep] >= 0 {
+			continue
+		}
+		reps[rep] = rep
+		stack = append(stack[:0], rep)
+		for len(stack) > 0 {
+			i = stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			for _, j := range neighbors[i] {
+				if reps[j] < 0 {
+					reps[j] = rep
+					stack = append(stack, j)
+				}
+			}
+		}
+	}
+	return reps
+}
@@ -0,0 +1,162 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClusterGenomes(t *testing.T) {
+	// 0-1: 99.5, 1-2: 99.2, 0-2: 98.5 (not linked), 3 is a singleton
+	anis := map[[2]int]float64{{0, 1}: 0.995, {1, 2}: 0.992, {0, 2}: 0.985}
+	ani := func(i, j int) float64 {
+		if i > j {
+			i, j = j, i
+		}
+		return anis[[2]int{i, j}]
+	}
+	neighbors := [][]int{{1}, {0, 2}, {1}, {}}
+	order := []int{1, 0, 2, 3}
+
+	reps := clusterGenomesGreedy(order, neighbors, ani)
+	expected := []int{1, 1, 1, 3}
+	for i, r := range reps {
+		if r != expected[i] {
+			t.Errorf("greedy: unexpected representative of genome %d: %d, expected: %d", i, r, expected[i])
+		}
+	}
+
+	// with genome 0 first, genome 2 is not similar enough to it and becomes a representative,
+	// then genome 1 is assigned to the more similar one.
+	order = []int{0, 2, 1, 3}
+	reps = clusterGenomesGreedy(order, neighbors, ani)
+	expected = []int{0, 0, 2, 3}
+	for i, r := range reps {
+		if r != expected[i] {
+			t.Errorf("greedy: unexpected representative of genome %d: %d, expected: %d", i, r, expected[i])
+		}
+	}
+
+	// all linked genomes are in the same component
+	reps = clusterGenomesConnected(order, neighbors)
+	expected = []int{0, 0, 0, 3}
+	for i, r := range reps {
+		if r != expected[i] {
+			t.Errorf("connected: unexpected representative of genome %d: %d, expected: %d", i, r, expected[i])
+		}
+	}
+}
+
+func TestJaccard2ANI(t *testing.T) {
+	if v := jaccard2ANI(1, 5000000, 5000000, 31); v != 1 {
+		t.Errorf("unexpected ANI of J=1: %f", v)
+	}
+	if v := jaccard2ANI(0, 5000000, 5000000, 31); v != 0 {
+		t.Errorf("unexpected ANI of J=0: %f", v)
+	}
+	if a, b := jaccard2ANI(0.5, 5000000, 5000000, 31), jaccard2ANI(0.6, 5000000, 5000000, 31); a >= b {
+		t.Errorf("ANI should increase with the Jaccard index: %f >= %f", a, b)
+	}
+
+	// the same as the ANI from utils dist
+	_, _, ani, _, _ := estimateGenomeSimilarity(800, 1000, 5000000, 4000000, 31)
+	if v := jaccard2ANI(0.8, 5000000, 4000000, 31); v != ani {
+		t.Errorf("unexpected ANI: %f, expected: %f", v, ani)
+	}
+
+	// ANI of two genomes is symmetric
+	s := &IndexSeedSharing{K: 31, Sizes: []int{5000000, 4000000}, Masks: []int{1000, 900},
+		Shared: map[uint64]uint32{1: 800}}
+	if a, b := s.ANI(0, 1), s.ANI(1, 0); a != b || a != ani {
+		t.Errorf("unexpected ANIs: %f, %f, expected: %f", a, b, ani)
+	}
+}
+
+func TestIndexSeedSharing(t *testing.T) {
+	dbDir, _ := buildTestGenomesIndex(t, t.TempDir())
+
+	s, err := NewIndexSeedSharing(dbDir, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.IDs) != 3 || s.SkippedSeeds != 0 {
+		t.Fatalf("unexpected genomes: %v, skipped seeds: %d", s.IDs, s.SkippedSeeds)
+	}
+	// g2 is more similar to g1 than g3
+	if a, b := s.ANI(0, 1), s.ANI(0, 2); a <= b || b <= 0.99 {
+		t.Errorf("unexpected ANIs: g1-g2: %f, g1-g3: %f", a, b)
+	}
+
+	// most seeds are shared by all 3 genomes, and they are skipped
+	s2, err := NewIndexSeedSharing(dbDir, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s2.SkippedSeeds == 0 {
+		t.Errorf("no seeds skipped")
+	}
+	for key, c := range s2.Shared {
+		if c >= s.Shared[key] {
+			t.Errorf("shared masks are not reduced with skipped seeds: %d >= %d", c, s.Shared[key])
+		}
+	}
+	for i, c := range s2.Masks {
+		if c != s.Masks[i] {
+			t.Errorf("masks of genome %d should not be affected by skipped seeds: %d != %d", i, c, s.Masks[i])
+		}
+	}
+
+	// genome chunks missing in the genomes index mapping file: only keep the record of g1,
+	// i.e., 2 bytes of the ID length, the ID, and 8 bytes of batch+index.
+	file := filepath.Join(dbDir, FileGenomeIndex)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(file, data[:2+2+8], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewIndexSeedSharing(dbDir, 2, 0); err == nil {
+		t.Errorf("an error expected for genomes missing in the genomes index mapping file")
+	}
+}
+
+func TestIndexSeedSharingChunkedGenomes(t *testing.T) {
+	// g1 with two contigs is split into two chunks, g2 has only the first contig of g1.
+	dbDir, _ := buildTestChunkedGenomesIndex(t, t.TempDir())
+
+	s, err := NewIndexSeedSharing(dbDir, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.IDs) != 2 || s.IDs[0] != "g1" || s.IDs[1] != "g2" {
+		t.Fatalf("unexpected genomes: %v", s.IDs)
+	}
+	// sizes of all chunks are summed up
+	if s.Sizes[0] != 10000 || s.Sizes[1] != 5000 {
+		t.Errorf("unexpected genome sizes: %v, expected: [10000 5000]", s.Sizes)
+	}
+	if s.Shared[1] == 0 || s.Shared[1] > uint32(s.Masks[1]) {
+		t.Errorf("unexpected shared masks: %d, masks: %v", s.Shared[1], s.Masks)
+	}
+}
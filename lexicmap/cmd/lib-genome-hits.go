@@ -0,0 +1,170 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/shenwei356/LexicMap/lexicmap/cmd/genome"
+)
+
+// genomeHits summarizes chained seeds of a query in each genome, with no alignment performed,
+// which is used for fast genome-level screening.
+//
+//   - Score is the chaining score, which is summed up for chunks of a split genome.
+//   - SeedCoverage is the percentage of query bases covered by chained seeds.
+//   - AlignedFraction, i.e., qcovGnm, is estimated from query regions spanned by chains.
+//
+// Genomes are sorted by the chaining score in descending order.
+func (idx *Index) genomeHits(rs *[]*SearchResult, qlen int) *[]*SearchResult {
+	minQcovGnm := idx.opt.MinQueryAlignedFractionInAGenome
+
+	// query regions of seeds and chains in each genome
+	seedRegions := make([]*[]*[2]int, len(*rs))
+	chainRegions := make([]*[]*[2]int, len(*rs))
+
+	var sub *SubstrPair
+	var qb, qe int
+	for i, r := range *rs {
+		// genome information
+		g := idx.genomeInfo(r.GenomeBatch, r.GenomeIndex)
+		r.ID = append(r.ID, g.ID...)
+		r.GenomeSize = g.GenomeSize
+		genome.RecycleGenome(g)
+
+		sRegions := poolRegions.Get().(*[]*[2]int)
+		*sRegions = (*sRegions)[:0]
+		cRegions := poolRegions.Get().(*[]*[2]int)
+		*cRegions = (*cRegions)[:0]
+
+		for _, chain := range *r.Chains {
+			qb, qe = qlen, -1
+			for _, j := range *chain {
+				sub = (*r.Subs)[j]
+
+				region := poolRegion.Get().(*[2]int)
+				region[0], region[1] = int(sub.QBegin), int(sub.QBegin)+int(sub.Len)-1
+				*sRegions = append(*sRegions, region)
+
+				qb = min(qb, region[0])
+				qe = max(qe, region[1])
+			}
+			r.NumSeeds += len(*chain)
+
+			region := poolRegion.Get().(*[2]int)
+			region[0], region[1] = qb, qe
+			*cRegions = append(*cRegions, region)
+		}
+
+		seedRegions[i] = sRegions
+		chainRegions[i] = cRegions
+
+		// chaining data are not needed any more
+		for _, sub := range *r.Subs {
+			poolSub.Put(sub)
+		}
+		poolSubs.Put(r.Subs)
+		r.Subs = nil
+
+		for _, chain := range *r.Chains {
+			poolChain.Put(chain)
+		}
+		poolChains.Put(r.Chains)
+		r.Chains = nil
+	}
+
+	// merge results from genome chunks, if has split genome
+	if idx.hasGenomeChunks {
+		var a, b uint64 // SearchResult.BatchGenomeIndex
+		var ok bool
+		var rp *SearchResult
+		chunks := idx.genomeChunks
+		for i, r := range *rs {
+			for j := 0; j < i; j++ {
+				rp = (*rs)[j]
+				if rp == nil { // it has been merged
+					continue
+				}
+
+				if !bytes.Equal(r.ID, rp.ID) { // unequal genome IDs must not belong to the same genome.
+					continue
+				}
+
+				a, b = r.BatchGenomeIndex, rp.BatchGenomeIndex
+				if a < b {
+					a, b = b, a
+				}
+				if _, ok = chunks[a][b]; !ok {
+					continue
+				}
+
+				// merge r into rp
+				rp.Score += r.Score
+				rp.NumSeeds += r.NumSeeds
+				*seedRegions[j] = append(*seedRegions[j], *seedRegions[i]...)
+				*chainRegions[j] = append(*chainRegions[j], *chainRegions[i]...)
+				poolRegions.Put(seedRegions[i])
+				poolRegions.Put(chainRegions[i])
+
+				idx.RecycleSearchResult(r)
+				(*rs)[i] = nil
+				break
+			}
+		}
+	}
+
+	// coverage
+	var j int
+	for i, r := range *rs {
+		if r == nil {
+			continue
+		}
+
+		r.SeedCoverage = min(100, float64(coverageLen(seedRegions[i]))/float64(qlen)*100)
+		r.AlignedFraction = min(100, float64(coverageLen(chainRegions[i]))/float64(qlen)*100)
+		recycleRegions(seedRegions[i])
+		recycleRegions(chainRegions[i])
+
+		if r.AlignedFraction < minQcovGnm {
+			idx.RecycleSearchResult(r)
+			continue
+		}
+
+		(*rs)[j] = r
+		j++
+	}
+	*rs = (*rs)[:j]
+
+	if len(*rs) == 0 {
+		poolSearchResults.Put(rs)
+		return nil
+	}
+
+	sort.Slice(*rs, func(i, j int) bool {
+		if (*rs)[i].Score == (*rs)[j].Score {
+			return (*rs)[i].AlignedFraction > (*rs)[j].AlignedFraction
+		}
+		return (*rs)[i].Score > (*rs)[j].Score
+	})
+
+	return rs
+}
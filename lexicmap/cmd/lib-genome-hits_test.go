@@ -0,0 +1,139 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenomeHits(t *testing.T) {
+	// g1 is split into two chunks, g2 has only the first contig of g1.
+	dbDir, _ := buildTestChunkedGenomesIndex(t, t.TempDir())
+
+	name2idx, err := readGenomeMapName2Idx(filepath.Join(dbDir, FileGenomeIndex))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*name2idx["g1"]) != 2 || len(*name2idx["g2"]) != 1 {
+		t.Fatalf("unexpected genome chunks: g1: %v, g2: %v", *name2idx["g1"], *name2idx["g2"])
+	}
+
+	opt := DefaultIndexSearchingOptions
+	opt.NumCPUs = 1
+	opt.GenomeHitsOnly = true
+	idx, err := NewIndexSearcher(dbDir, &opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if !idx.hasGenomeChunks {
+		t.Fatalf("genome chunks expected in the index")
+	}
+
+	// a search result of a genome chunk, with chains of seeds, i.e., query begins and lengths.
+	result := func(batchIDAndRefID uint64, score float64, chains ...[][2]int) *SearchResult {
+		subs := make([]*SubstrPair, 0, 8)
+		chs := make([]*[]int, 0, len(chains))
+		for _, chain := range chains {
+			ch := make([]int, 0, len(chain))
+			for _, s := range chain {
+				ch = append(ch, len(subs))
+				subs = append(subs, &SubstrPair{QBegin: int32(s[0]), Len: uint8(s[1])})
+			}
+			chs = append(chs, &ch)
+		}
+		return &SearchResult{
+			BatchGenomeIndex: batchIDAndRefID,
+			GenomeBatch:      int(batchIDAndRefID >> BITS_GENOME_IDX),
+			GenomeIndex:      int(batchIDAndRefID & MASK_GENOME_IDX),
+			Subs:             &subs,
+			Score:            score,
+			Chains:           &chs,
+		}
+	}
+
+	const qlen = 1000
+	hits := func(minQcovGnm float64) *[]*SearchResult {
+		idx.opt.MinQueryAlignedFractionInAGenome = minQcovGnm
+		rs := []*SearchResult{
+			// chunk 1 of g1: 2 overlapping seeds, seeds: 51 bp, chains: 51 bp.
+			result((*name2idx["g1"])[0], 10, [][2]int{{0, 31}, {20, 31}}),
+			// g2: seeds: 93 bp, chains: 331 bp.
+			result((*name2idx["g2"])[0], 12, [][2]int{{0, 31}, {100, 31}, {300, 31}}),
+			// chunk 2 of g1: seeds: 62 bp, chains: 231 bp.
+			result((*name2idx["g1"])[1], 5, [][2]int{{500, 31}, {700, 31}}),
+		}
+		return idx.genomeHits(&rs, qlen)
+	}
+
+	type hit struct {
+		id                            string
+		score                         float64
+		seeds                         int
+		seedCoverage, alignedFraction float64
+	}
+	check := func(minQcovGnm float64, expected []hit) {
+		rs := hits(minQcovGnm)
+		if len(expected) == 0 {
+			if rs != nil {
+				t.Errorf("nil expected with a minimum qcovGnm of %f, got %d hits", minQcovGnm, len(*rs))
+				idx.RecycleSearchResults(rs)
+			}
+			return
+		}
+		if rs == nil || len(*rs) != len(expected) {
+			t.Errorf("%d hits expected with a minimum qcovGnm of %f", len(expected), minQcovGnm)
+			if rs != nil {
+				idx.RecycleSearchResults(rs)
+			}
+			return
+		}
+		for i, r := range *rs {
+			e := expected[i]
+			if string(r.ID) != e.id || r.Score != e.score || r.NumSeeds != e.seeds ||
+				math.Abs(r.SeedCoverage-e.seedCoverage) > 1e-9 ||
+				math.Abs(r.AlignedFraction-e.alignedFraction) > 1e-9 {
+				t.Errorf("unexpected hit %d with a minimum qcovGnm of %f: %s, score: %f, seeds: %d, "+
+					"seed coverage: %f, aligned fraction: %f, expected: %v", i, minQcovGnm, r.ID, r.Score,
+					r.NumSeeds, r.SeedCoverage, r.AlignedFraction, e)
+			}
+		}
+		idx.RecycleSearchResults(rs)
+	}
+
+	// chunks of g1 are merged, with the summed score and seeds, and it's sorted before g2.
+	g1 := hit{"g1", 15, 4, 11.3, 28.2}
+	g2 := hit{"g2", 12, 3, 9.3, 33.1}
+	check(0, []hit{g1, g2})
+
+	// neither chunk of g1 passes the filter alone, but the merged one does.
+	check(25, []hit{g1, g2})
+	check(30, []hit{g2})
+	check(50, nil)
+
+	// no search results
+	rs := make([]*SearchResult, 0)
+	if idx.genomeHits(&rs, qlen) != nil {
+		t.Errorf("nil expected for no search results")
+	}
+}
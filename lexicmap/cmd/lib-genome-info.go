@@ -0,0 +1,62 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/shenwei356/LexicMap/lexicmap/cmd/genome"
+)
+
+// genomeInfo returns the information of a genome in a batch,
+// please call genome.RecycleGenome() after using the result.
+func (idx *Index) genomeInfo(refBatch, refID int) *genome.Genome {
+	var rdr *genome.Reader
+	var err error
+	if idx.hasGenomeRdrs {
+		rdr = <-idx.poolGenomeRdrs[refBatch]
+	} else {
+		idx.openFileTokens <- 1 // genome file
+		fileGenome := filepath.Join(idx.path, DirGenomes, batchDir(refBatch), FileGenomes)
+		rdr, err = genome.NewReader(fileGenome)
+		if err != nil {
+			checkError(fmt.Errorf("failed to read genome data file: %s", err))
+		}
+	}
+
+	g, err := rdr.GenomeInfo(refID)
+	if err != nil {
+		checkError(fmt.Errorf("failed to read genome info of %d in batch %d: %s", refID, refBatch, err))
+	}
+
+	if idx.hasGenomeRdrs {
+		idx.poolGenomeRdrs[refBatch] <- rdr
+	} else {
+		err = rdr.Close()
+		if err != nil {
+			checkError(fmt.Errorf("failed to close genome data file: %s", err))
+		}
+		<-idx.openFileTokens
+	}
+
+	return g
+}
@@ -0,0 +1,313 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/shenwei356/LexicMap/lexicmap/cmd/genome"
+	"github.com/shenwei356/LexicMap/lexicmap/cmd/kv"
+)
+
+// GenomeSimilarity is the similarity between a query genome and an indexed genome,
+// estimated from the k-mers captured by masks (seeds) and shared by the two genomes.
+type GenomeSimilarity struct {
+	BatchGenomeIndex uint64 // the first chunk for chunked genomes
+	ID               []byte // genome ID
+	GenomeSize       int    // bases of all sequences
+
+	Masks  int // number of masks capturing k-mers in the query genome
+	Shared int // number of masks capturing k-mers shared with the genome
+
+	Jaccard     float64 // estimated Jaccard index of k-mer sets
+	Containment float64 // estimated containment of the query genome in the genome
+	ANI         float64 // estimated average nucleotide identity
+	ANILow      float64 // lower bound of the 95% confidence interval of ANI
+	ANIHigh     float64 // upper bound of the 95% confidence interval of ANI
+}
+
+// EstimateGenomeSimilarity estimates similarities between a query genome
+// and indexed genomes from exactly matched seeds, with no alignment performed.
+// Sequences of the query genome are concatenated with intervals, like what index building does.
+// Results are sorted by ANI in descending order, and only the top n genomes are returned if n > 0.
+func (idx *Index) EstimateGenomeSimilarity(seqs [][]byte, n int) ([]*GenomeSimilarity, error) {
+	k := idx.k
+
+	// ----------------------------------------------------------------
+	// 1) concatenate sequences and mask the query genome
+
+	var qSize int
+	for _, s := range seqs {
+		qSize += len(s)
+	}
+	if qSize < k {
+		return nil, nil
+	}
+
+	s := make([]byte, 0, qSize+(len(seqs)-1)*k)
+	skipRegions := make([][2]int, 0, len(seqs)-1)
+	for i, _s := range seqs {
+		if i > 0 {
+			skipRegions = append(skipRegions, [2]int{len(s), len(s) + k - 1})
+			for j := 0; j < k; j++ {
+				s = append(s, 'A')
+			}
+		}
+		s = append(s, _s...)
+	}
+
+	_kmers, _locses, err := idx.lh.MaskKnownDistinctPrefixes(s, skipRegions, true)
+	if err != nil {
+		return nil, err
+	}
+	defer idx.lh.RecycleMaskResult(_kmers, _locses)
+
+	ttt := (uint64(1) << (k << 1)) - 1
+	var nMasks int
+	for _, kmer := range *_kmers {
+		if kmer != 0 && kmer != ttt { // these are skipped in searching
+			nMasks++
+		}
+	}
+	if nMasks == 0 {
+		return nil, nil
+	}
+
+	// ----------------------------------------------------------------
+	// 2) count masks with k-mers exactly matched in each genome.
+	//    chunks of a genome are represented by the first one, so a mask is counted once per genome.
+
+	inMemorySearch := idx.opt.InMemorySearch
+	var nSearchers int
+	if inMemorySearch {
+		nSearchers = len(idx.InMemorySearchers)
+	} else {
+		nSearchers = len(idx.Searchers)
+	}
+
+	counts := make(map[uint64]int, 1024) // batch+refIdx -> number of shared masks
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	p := uint8(k)
+	for iS := 0; iS < nSearchers; iS++ {
+		wg.Add(1)
+		go func(iS int) {
+			defer wg.Done()
+
+			idx.searcherTokens[iS] <- 1 // get the access to the searcher
+			var srs *[]*kv.SearchResult
+			var err error
+			if inMemorySearch {
+				scr := idx.InMemorySearchers[iS]
+				srs, err = scr.Search((*_kmers)[scr.ChunkIndex:scr.ChunkIndex+scr.ChunkSize], p, true, false)
+			} else {
+				scr := idx.Searchers[iS]
+				srs, err = scr.Search((*_kmers)[scr.ChunkIndex:scr.ChunkIndex+scr.ChunkSize], p, true, false)
+			}
+			<-idx.searcherTokens[iS] // return the access
+			if err != nil {
+				checkError(err)
+			}
+
+			// a genome might have multiple k-mers in a mask, only count it once.
+			lastMask := make(map[uint64]int, 1024)
+			_counts := make(map[uint64]int, 1024)
+			var refBatchAndIdx uint64
+			var last int
+			var ok bool
+			for _, sr := range *srs {
+				for _, v := range sr.Values {
+					refBatchAndIdx = v >> BITS_NONE_IDX
+					if idx.hasGenomeChunks {
+						refBatchAndIdx = idx.firstGenomeChunk(refBatchAndIdx)
+					}
+					if last, ok = lastMask[refBatchAndIdx]; ok && last == sr.IQuery {
+						continue
+					}
+					lastMask[refBatchAndIdx] = sr.IQuery
+					_counts[refBatchAndIdx]++
+				}
+			}
+			kv.RecycleSearchResults(srs)
+
+			mu.Lock()
+			for refBatchAndIdx, c := range _counts {
+				counts[refBatchAndIdx] += c
+			}
+			mu.Unlock()
+		}(iS)
+	}
+	wg.Wait()
+
+	if len(counts) == 0 {
+		return nil, nil
+	}
+
+	sims := make([]*GenomeSimilarity, 0, len(counts))
+	for refBatchAndIdx, c := range counts {
+		sims = append(sims, &GenomeSimilarity{
+			BatchGenomeIndex: refBatchAndIdx,
+			Masks:            nMasks,
+			Shared:           min(c, nMasks),
+		})
+	}
+	sort.Slice(sims, func(i, j int) bool {
+		if sims[i].Shared == sims[j].Shared {
+			return sims[i].BatchGenomeIndex < sims[j].BatchGenomeIndex
+		}
+		return sims[i].Shared > sims[j].Shared
+	})
+
+	// ----------------------------------------------------------------
+	// 3) genome information and estimates
+
+	// as the ANI is not proportional to the number of shared masks for genomes of different sizes,
+	// more candidates are kept before the final sorting.
+	if n > 0 && len(sims) > n<<1 {
+		sims = sims[:n<<1]
+	}
+
+	var chunks map[uint64][]uint64 // the first chunk -> other chunks
+	if idx.hasGenomeChunks {
+		chunks = idx.otherGenomeChunks(sims)
+	}
+
+	for _, sim := range sims {
+		refBatch := int(sim.BatchGenomeIndex >> BITS_GENOME_IDX)
+		refID := int(sim.BatchGenomeIndex & MASK_GENOME_IDX)
+
+		g := idx.genomeInfo(refBatch, refID)
+		sim.ID = append(sim.ID, g.ID...)
+		sim.GenomeSize = g.GenomeSize
+		genome.RecycleGenome(g)
+
+		// the size of a chunk only covers its own sequences
+		for _, c := range chunks[sim.BatchGenomeIndex] {
+			g = idx.genomeInfo(int(c>>BITS_GENOME_IDX), int(c&MASK_GENOME_IDX))
+			sim.GenomeSize += g.GenomeSize
+			genome.RecycleGenome(g)
+		}
+
+		sim.Jaccard, sim.Containment, sim.ANI, sim.ANILow, sim.ANIHigh = estimateGenomeSimilarity(
+			sim.Shared, sim.Masks, qSize, sim.GenomeSize, k)
+	}
+
+	sort.Slice(sims, func(i, j int) bool {
+		if sims[i].ANI == sims[j].ANI {
+			return sims[i].Shared > sims[j].Shared
+		}
+		return sims[i].ANI > sims[j].ANI
+	})
+	if n > 0 && len(sims) > n {
+		sims = sims[:n]
+	}
+
+	return sims, nil
+}
+
+// firstGenomeChunk returns the batch+index of the first chunk of a chunked genome,
+// or the batch+index itself for unchunked genomes.
+func (idx *Index) firstGenomeChunk(a uint64) uint64 {
+	first := a
+	for b := range idx.genomeChunks[a] { // chunks with smaller batch+index
+		if b < first {
+			first = b
+		}
+	}
+	return first
+}
+
+// otherGenomeChunks returns the other chunks of chunked genomes in the results,
+// with the first chunk as the key.
+func (idx *Index) otherGenomeChunks(sims []*GenomeSimilarity) map[uint64][]uint64 {
+	chunks := make(map[uint64][]uint64, len(sims))
+	for _, sim := range sims {
+		if _, ok := idx.genomeChunks[sim.BatchGenomeIndex]; ok {
+			chunks[sim.BatchGenomeIndex] = nil
+		}
+	}
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	var first uint64
+	var ok bool
+	for a := range idx.genomeChunks {
+		first = idx.firstGenomeChunk(a)
+		if first == a {
+			continue
+		}
+		if _, ok = chunks[first]; ok {
+			chunks[first] = append(chunks[first], a)
+		}
+	}
+	return chunks
+}
+
+// estimateGenomeSimilarity estimates the Jaccard index, containment of the query,
+// and ANI (with a 95% confidence interval) from the fraction of masks sharing k-mers,
+// in which masks are regarded as a bottom sketch of the union of two k-mer sets.
+// The ANI is computed from the containment, i.e., ANI = C^(1/k), like Mash screen does.
+func estimateGenomeSimilarity(shared, masks, qSize, gSize, k int) (jaccard, containment, ani, aniLow, aniHigh float64) {
+	if masks <= 0 || shared <= 0 || qSize <= 0 {
+		return 0, 0, 0, 0, 0
+	}
+	shared = min(shared, masks)
+
+	jaccard = float64(shared) / float64(masks)
+
+	// Wilson score interval of the Jaccard index
+	const z = 1.96
+	n := float64(masks)
+	z2 := z * z
+	denom := 1 + z2/n
+	center := (jaccard + z2/(2*n)) / denom
+	half := z * math.Sqrt(jaccard*(1-jaccard)/n+z2/(4*n*n)) / denom
+	jLow := max(0, center-half)
+	jHigh := min(1, center+half)
+
+	containment = jaccard2containment(jaccard, qSize, gSize)
+	ani = jaccard2ANI(jaccard, qSize, gSize, k)
+	aniLow = jaccard2ANI(jLow, qSize, gSize, k)
+	aniHigh = jaccard2ANI(jHigh, qSize, gSize, k)
+	return
+}
+
+// jaccard2ANI converts a Jaccard index of k-mer sets to ANI from the containment of the query,
+// i.e., ANI = C^(1/k). It's used by both utils dist and utils cluster.
+func jaccard2ANI(j float64, qSize, gSize, k int) float64 {
+	return math.Pow(jaccard2containment(j, qSize, gSize), 1/float64(k))
+}
+
+// jaccard2containment converts a Jaccard index to the containment of the query,
+// i.e., |Q∩G|/|Q| = J(|Q|+|G|)/((1+J)|Q|), with the numbers of k-mers approximated by sizes.
+func jaccard2containment(j float64, qSize, gSize int) float64 {
+	if j <= 0 {
+		return 0
+	}
+	c := j * float64(qSize+gSize) / ((1 + j) * float64(qSize))
+	if c > 1 {
+		c = 1
+	}
+	return c
+}
@@ -0,0 +1,91 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestEstimateGenomeSimilarity(t *testing.T) {
+	// identical genomes
+	j, c, ani, low, high := estimateGenomeSimilarity(1000, 1000, 5000000, 5000000, 31)
+	if j != 1 || c != 1 || ani != 1 || high != 1 {
+		t.Errorf("unexpected estimates of identical genomes: %f, %f, %f, %f, %f", j, c, ani, low, high)
+	}
+	if low >= 1 || low < 0.99 {
+		t.Errorf("unexpected lower bound of ANI of identical genomes: %f", low)
+	}
+
+	// a query contained in a bigger genome: J = 0.5, C = 0.5*(1+2)/(1.5*1) = 1
+	_, c, _, _, _ = estimateGenomeSimilarity(500, 1000, 1000000, 2000000, 31)
+	if c != 1 {
+		t.Errorf("unexpected containment of a contained query: %f", c)
+	}
+
+	// ANI increases with shared masks, and lies in the confidence interval
+	var pre float64
+	for _, shared := range []int{10, 100, 500, 900} {
+		_, _, ani, low, high = estimateGenomeSimilarity(shared, 1000, 5000000, 5000000, 31)
+		if ani <= pre {
+			t.Errorf("ANI should increase with shared masks: %f <= %f", ani, pre)
+		}
+		if low > ani || high < ani {
+			t.Errorf("ANI %f out of the confidence interval [%f, %f]", ani, low, high)
+		}
+		pre = ani
+	}
+
+	// no shared masks
+	_, _, ani, _, _ = estimateGenomeSimilarity(0, 1000, 5000000, 5000000, 31)
+	if ani != 0 {
+		t.Errorf("unexpected ANI with no shared masks: %f", ani)
+	}
+}
+
+func TestGenomeChunks(t *testing.T) {
+	// a genome split into 3 chunks: 5, 7, 9, and an unchunked genome 11.
+	// the map is from a chunk to the chunks with smaller batch+index, like readGenomeChunksMapBig2Small does.
+	idx := &Index{
+		genomeChunks: map[uint64]map[uint64]interface{}{
+			5: {},
+			7: {5: struct{}{}},
+			9: {5: struct{}{}, 7: struct{}{}},
+		},
+		hasGenomeChunks: true,
+	}
+
+	for a, first := range map[uint64]uint64{5: 5, 7: 5, 9: 5, 11: 11} {
+		if f := idx.firstGenomeChunk(a); f != first {
+			t.Errorf("unexpected first chunk of %d: %d, expected: %d", a, f, first)
+		}
+	}
+
+	chunks := idx.otherGenomeChunks([]*GenomeSimilarity{{BatchGenomeIndex: 5}, {BatchGenomeIndex: 11}})
+	if len(chunks) != 1 {
+		t.Fatalf("unexpected number of chunked genomes: %d", len(chunks))
+	}
+	others := chunks[5]
+	sort.Slice(others, func(i, j int) bool { return others[i] < others[j] })
+	if len(others) != 2 || others[0] != 7 || others[1] != 9 {
+		t.Errorf("unexpected other chunks of 5: %v", others)
+	}
+}
@@ -38,6 +38,7 @@ import (
 	"github.com/shenwei356/LexicMap/lexicmap/cmd/genome"
 	"github.com/shenwei356/LexicMap/lexicmap/cmd/kv"
 	"github.com/shenwei356/LexicMap/lexicmap/cmd/seedposition"
+	"github.com/shenwei356/LexicMap/lexicmap/cmd/util"
 	"github.com/shenwei356/bio/seqio/fastx"
 	"github.com/shenwei356/kmers"
 	"github.com/shenwei356/lexichash"
@@ -129,6 +130,13 @@ type IndexBuildingOptions struct {
 	DesertExpectedSeedDist int    // expected distance between seeds
 	DesertSeedPosRange     int    // the upstream and down stream region for adding a seeds
 
+	// masking repeats and low-complexity regions, which are not used as seeds.
+	// the sequences are still saved for alignment.
+	SoftMask      bool    // skip soft-masked (lowercase) bases
+	Dust          bool    // skip low-complexity regions found by DUST
+	DustWindow    int     // window size of DUST, e.g., 64
+	DustThreshold float64 // score threshold of DUST, e.g., 20
+
 	// generate mask from the top N biggest genomes
 	TopN      int // Select the the top N largest genomes for generating masks
 	PrefixExt int // Extension length of prefixes
@@ -149,7 +157,15 @@ type IndexBuildingOptions struct {
 
 	ContigInterval int // the length of N's between contigs
 
+	// circular sequences
+	ReCircular   *regexp.Regexp         // for detecting circular sequences by contents in the FASTA/Q header
+	CircularSeqs map[string]interface{} // IDs of circular sequences
+
 	SaveSeedPositions bool
+
+	// rebuilding from an existing index, input files are genome IDs in the index
+	SourceIndex string
+	srcIndex    *genomeSourceIndex
 }
 
 // CheckIndexBuildingOptions checks some important options
@@ -180,6 +196,15 @@ func CheckIndexBuildingOptions(opt *IndexBuildingOptions) error {
 		return fmt.Errorf("invalid genome batch size: %d, valid range: [1, %d]", opt.GenomeBatchSize, 1<<BITS_BATCH_IDX)
 	}
 
+	if opt.Dust {
+		if opt.DustWindow < 4 {
+			return fmt.Errorf("invalid DUST window size: %d, should be >= 4", opt.DustWindow)
+		}
+		if opt.DustThreshold <= 0 {
+			return fmt.Errorf("invalid DUST threshold: %f, should be > 0", opt.DustThreshold)
+		}
+	}
+
 	// ------------------------
 
 	if opt.NumCPUs < 1 {
@@ -215,6 +240,17 @@ func BuildIndex(outdir string, infiles []string, opt *IndexBuildingOptions) erro
 	var lh *lexichash.LexicHash
 	var err error
 
+	if opt.SourceIndex != "" {
+		opt.srcIndex, err = newGenomeSourceIndex(opt.SourceIndex)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			opt.srcIndex.Close()
+			opt.srcIndex = nil
+		}()
+	}
+
 	if opt.MaskFile != "" {
 		if opt.Verbose || opt.Log2File {
 			log.Info()
@@ -552,11 +588,13 @@ func buildAnIndex(lh *lexichash.LexicHash, maskPrefix uint8, anchorPrefix uint8,
 	}
 
 	// 2.2) write genomes to file
-	var nFiles int // the total number of indexed files
+	var nFiles int       // the total number of indexed files
+	var totalBases int64 // the total bases of all genomes
 	go func() {
 
 		for refseq := range genomesW { // each genome
 			nFiles++
+			totalBases += int64(refseq.GenomeSize)
 
 			// write the genome to file
 			err = gw.Write(refseq)
@@ -916,8 +954,13 @@ func buildAnIndex(lh *lexichash.LexicHash, maskPrefix uint8, anchorPrefix uint8,
 					_skipRegions = *skipRegions
 				}
 
-				// skip gap regions (N's)
+				// skip gap regions (N's), which are also saved in genome data,
+				// as N's are converted to A's in the 2-bit sequence.
 				gaps := reGaps.FindAllSubmatchIndex(refseq.Seq, -1)
+				refseq.Gaps = refseq.Gaps[:0]
+				for _, gap := range gaps {
+					refseq.Gaps = append(refseq.Gaps, [2]int{gap[0], gap[1]})
+				}
 				if gaps != nil {
 					if _skipRegions == nil {
 						skipRegions = poolSkipRegions.Get().(*[][2]int)
@@ -936,6 +979,30 @@ func buildAnIndex(lh *lexichash.LexicHash, maskPrefix uint8, anchorPrefix uint8,
 
 					_skipRegions = *skipRegions
 				}
+
+				// skip soft-masked and low-complexity regions.
+				// they are not added into skipRegions, which is also used to mark seeds after interval regions.
+				maskRegions := _skipRegions
+				var _maskRegions *[][2]int
+				if opt.SoftMask || opt.Dust {
+					_maskRegions = poolSkipRegions.Get().(*[][2]int)
+					*_maskRegions = append((*_maskRegions)[:0], _skipRegions...)
+
+					if opt.SoftMask {
+						util.LowerCaseRegions(refseq.Seq, _maskRegions)
+					}
+					if opt.Dust {
+						util.Dust(refseq.Seq, opt.DustWindow, opt.DustThreshold, _maskRegions)
+					}
+
+					// also avoid adding seeds in these regions in desert filling
+					for _, r := range (*_maskRegions)[len(_skipRegions):] {
+						_itree.Insert(r[0]-k+1, r[1], 1)
+					}
+
+					util.MergeRegions(_maskRegions)
+					maskRegions = *_maskRegions
+				}
 				//
 
 				var _kmers *[]uint64
@@ -947,7 +1014,11 @@ func buildAnIndex(lh *lexichash.LexicHash, maskPrefix uint8, anchorPrefix uint8,
 				// 	_kmers, locses, err = lh.Mask(refseq.Seq, _skipRegions)
 				// }
 				// _kmers, locses, err = lh.MaskKnownPrefixes(refseq.Seq, _skipRegions)
-				_kmers, locses, err = lh.MaskKnownDistinctPrefixes(refseq.Seq, _skipRegions, true)
+				_kmers, locses, err = lh.MaskKnownDistinctPrefixes(refseq.Seq, maskRegions, true)
+
+				if _maskRegions != nil {
+					poolSkipRegions.Put(_maskRegions)
+				}
 
 				if err != nil {
 					panic(err)
@@ -977,17 +1048,21 @@ func buildAnIndex(lh *lexichash.LexicHash, maskPrefix uint8, anchorPrefix uint8,
 				}
 				sortutil.Uint32s(*locs)
 
-				if refseq.ExtraKmers == nil { // extra k-mers
-					tmp := make([]*[]uint64, opt.Masks)
-					refseq.ExtraKmers = &tmp
-				}
 				extraKmers := refseq.ExtraKmers
-				for _i, k2l := range *extraKmers { // reset
-					if k2l != nil {
-						poolKmerAndLocs.Put(k2l)
-						(*extraKmers)[_i] = nil
+				if extraKmers != nil {
+					for _i, k2l := range *extraKmers { // reset
+						if k2l != nil {
+							poolKmerAndLocs.Put(k2l)
+							(*extraKmers)[_i] = nil
+						}
 					}
 				}
+				// the genome object might be from a previous building with a different number of masks
+				if extraKmers == nil || len(*extraKmers) != opt.Masks { // extra k-mers
+					tmp := make([]*[]uint64, opt.Masks)
+					refseq.ExtraKmers = &tmp
+					extraKmers = refseq.ExtraKmers
+				}
 
 				// ----------------------------------------------------------------
 				// fill sketching deserts
@@ -1396,9 +1471,24 @@ func buildAnIndex(lh *lexichash.LexicHash, maskPrefix uint8, anchorPrefix uint8,
 			// --------------------------------
 			// read sequence
 
-			fastxReader, err := fastx.NewReader(nil, file, "")
-			if err != nil {
-				checkError(fmt.Errorf("failed to read seq file: %s", err))
+			var err error
+			var fastxReader interface {
+				Read() (*fastx.Record, error)
+				Close()
+			}
+			var srcReader *indexRecordReader // reading from an existing index
+			if opt.srcIndex != nil {
+				g, err := opt.srcIndex.Genome(file)
+				if err != nil {
+					checkError(fmt.Errorf("failed to read genome from the index: %s", err))
+				}
+				srcReader = &indexRecordReader{g: g}
+				fastxReader = srcReader
+			} else {
+				fastxReader, err = fastx.NewReader(nil, file, "")
+				if err != nil {
+					checkError(fmt.Errorf("failed to read seq file: %s", err))
+				}
 			}
 			defer fastxReader.Close()
 
@@ -1496,7 +1586,9 @@ func buildAnIndex(lh *lexichash.LexicHash, maskPrefix uint8, anchorPrefix uint8,
 					// ---------------
 
 					var genomeID string // genome id
-					if extractRefName {
+					if srcReader != nil {
+						genomeID = file
+					} else if extractRefName {
 						if reRefName.MatchString(baseFile) {
 							genomeID = reRefName.FindAllStringSubmatch(baseFile, 1)[0][1]
 						} else {
@@ -1542,6 +1634,9 @@ func buildAnIndex(lh *lexichash.LexicHash, maskPrefix uint8, anchorPrefix uint8,
 				// ids of all contigs
 				seqid := []byte(string(record.ID))
 				refseq.SeqIDs = append(refseq.SeqIDs, &seqid)
+				// topology of all contigs
+				refseq.Circular = append(refseq.Circular, isCircularSeq(opt, record.ID, record.Name) ||
+					(srcReader != nil && srcReader.Circular()))
 				refseq.GenomeSize += len(record.Seq.Seq)
 
 				i++
@@ -1607,7 +1702,9 @@ func buildAnIndex(lh *lexichash.LexicHash, maskPrefix uint8, anchorPrefix uint8,
 			}
 
 			var genomeID string // genome id
-			if extractRefName {
+			if srcReader != nil {
+				genomeID = file
+			} else if extractRefName {
 				if reRefName.MatchString(baseFile) {
 					genomeID = reRefName.FindAllStringSubmatch(baseFile, 1)[0][1]
 				} else {
@@ -1696,6 +1793,7 @@ func buildAnIndex(lh *lexichash.LexicHash, maskPrefix uint8, anchorPrefix uint8,
 			GenomeBatchSize: nFiles, // just for this batch
 			GenomeBatches:   1,      // just for this batch
 			ContigInterval:  opt.ContigInterval,
+			TotalBases:      totalBases,
 		}
 		err = writeIndexInfo(filepath.Join(outdir, FileInfo), info)
 		if err != nil {
@@ -1779,6 +1877,7 @@ type IndexInfo struct {
 	GenomeBatchSize  int   `toml:"genome-batch-size"`
 	GenomeBatches    int   `toml:"genome-batches"`
 	ContigInterval   int   `toml:"contig-interval"`
+	TotalBases       int64 `toml:"total-bases" comment:"Total bases of all genomes, used as the search space for computing E-values"`
 }
 
 // writeIndexInfo writes summary of one index
@@ -2130,3 +2229,24 @@ var poolInts = &sync.Pool{New: func() interface{} {
 }}
 
 var cmpFn = func(x, y int) int { return int(x - y) }
+
+// isCircularSeq checks if a sequence is circular, according to the sequence ID list
+// and the keyword in the FASTA/Q header.
+func isCircularSeq(opt *IndexBuildingOptions, id []byte, header []byte) bool {
+	if opt.CircularSeqs != nil {
+		if _, ok := opt.CircularSeqs[string(id)]; ok {
+			return true
+		}
+	}
+	if opt.ReCircular != nil {
+		return opt.ReCircular.Match(header)
+	}
+	return false
+}
+
+// circularKeywordRegexp returns a regular expression for matching the keyword of circular
+// sequences in FASTA/Q header, e.g., "circular", "circular=true", and "[topology=circular]".
+func circularKeywordRegexp(keyword string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)(^|[\s;,\[])(topology=)?` + regexp.QuoteMeta(keyword) +
+		`(=(true|yes|1))?($|[\s;,\]])`)
+}
@@ -287,6 +287,7 @@ func mergeIndexes(lh *lexichash.LexicHash, maskPrefix uint8, anchorPrefix uint8,
 			info.InputGenomes += info2.InputGenomes
 			info.Genomes += info2.Genomes
 			info.GenomeBatches += info2.GenomeBatches
+			info.TotalBases += info2.TotalBases
 		}
 
 		err = writeIndexInfo(filepath.Join(outdir1, FileInfo), info)
@@ -34,6 +34,7 @@ import (
 
 	"github.com/shenwei356/LexicMap/lexicmap/cmd/genome"
 	"github.com/shenwei356/LexicMap/lexicmap/cmd/kv"
+	"github.com/shenwei356/LexicMap/lexicmap/cmd/util"
 	"github.com/shenwei356/kmers"
 	"github.com/shenwei356/lexichash"
 	"github.com/shenwei356/util/pathutil"
@@ -57,6 +58,17 @@ type IndexSearchingOptions struct {
 	// MinMatchedBases uint8 // the total matched bases
 	TopN int // keep the topN scores, e.g, 10
 
+	// genomes (batch+refIdx of all chunks) to skip before keeping the top N scores,
+	// e.g., the query genome itself.
+	ExcludedGenomes map[uint64]interface{}
+
+	MaxSeedOccurrences int // skip k-mers with more values than this, 0 for no limit
+
+	// low-complexity masking of queries, masked regions are not used as seeds
+	QueryDust          bool
+	QueryDustWindow    int     // window size, e.g., 64
+	QueryDustThreshold float64 // score threshold, e.g., 20
+
 	// seeds chaining
 	MaxGap      float64 // e.g., 5000
 	MaxDistance float64 // e.g., 20k
@@ -69,6 +81,14 @@ type IndexSearchingOptions struct {
 	// WFA alignment
 	MoreAccurateAlignment bool
 
+	// only report genome hits after seed chaining, with no alignment
+	GenomeHitsOnly bool
+
+	// alignment score, bit score, and E-value
+	Scoring        ScoringOptions
+	MaxEvalue      float64 // maximum E-value, 0 for no filtering
+	SortByBitScore bool    // sort alignments by bit score, rather than qcovHSP*pident
+
 	// Output
 	OutputSeq bool
 }
@@ -86,6 +106,19 @@ func CheckIndexSearchingOptions(opt *IndexSearchingOptions) error {
 		return fmt.Errorf("invalid MinPrefix: %d, valid range: [3, 32]", opt.MinPrefix)
 	}
 
+	if opt.MaxSeedOccurrences < 0 {
+		return fmt.Errorf("invalid maximum seed occurrences: %d, should be >= 0", opt.MaxSeedOccurrences)
+	}
+
+	if opt.QueryDust {
+		if opt.QueryDustWindow < 4 {
+			return fmt.Errorf("invalid DUST window size: %d, should be >= 4", opt.QueryDustWindow)
+		}
+		if opt.QueryDustThreshold <= 0 {
+			return fmt.Errorf("invalid DUST threshold: %f, should be > 0", opt.QueryDustThreshold)
+		}
+	}
+
 	return nil
 }
 
@@ -94,6 +127,10 @@ var DefaultIndexSearchingOptions = IndexSearchingOptions{
 	MaxOpenFiles: 512,
 
 	MinPrefix: 15,
+
+	QueryDustWindow:    64,
+	QueryDustThreshold: 20,
+
 	// MaxMismatch:     -1,
 	MinSinglePrefix: 17,
 	// MinMatchedBases: 20,
@@ -133,7 +170,10 @@ type Index struct {
 	poolChainers    *sync.Pool
 
 	// for sequence comparing
-	contigInterval    int // read from info file
+	contigInterval    int   // read from info file
+	genomeBatches     int   // read from info file
+	totalBases        int64 // total bases of all genomes, used as the search space for E-values
+	totalBasesOnce    sync.Once
 	seqCompareOption  *SeqComparatorOptions
 	poolSeqComparator *sync.Pool
 	poolChainers2     *sync.Pool
@@ -147,6 +187,49 @@ type Index struct {
 	genomeChunks    map[uint64]map[uint64]interface{}
 }
 
+// totalBasesOfGenomes sums up the bases of all genomes from the genome data files,
+// it's only used for indexes without the total bases in the info file.
+// Genome sizes are used rather than lengths of concatenated sequences in the index,
+// so the interval sequences between contigs are not included, just like index building does.
+func totalBasesOfGenomes(outDir string, batches int) (int64, error) {
+	var total int64
+	for i := 0; i < batches; i++ {
+		fileGenome := filepath.Join(outDir, DirGenomes, batchDir(i), FileGenomes)
+		rdr, err := genome.NewReader(fileGenome)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read genome data file: %s", err)
+		}
+		for j := 0; j < len(rdr.Index)>>1; j++ {
+			g, err := rdr.GenomeInfo(j)
+			if err != nil {
+				return 0, fmt.Errorf("failed to read genome info of %d in batch %d: %s", j, i, err)
+			}
+			total += int64(g.GenomeSize)
+			genome.RecycleGenome(g)
+		}
+		err = rdr.Close()
+		if err != nil {
+			return 0, fmt.Errorf("failed to close genome data file: %s", err)
+		}
+	}
+	return total, nil
+}
+
+// getTotalBases returns the total bases of all genomes.
+// For indexes created by old versions, it's computed from the genome data files
+// on the first call, so it costs nothing if E-values are not needed.
+func (idx *Index) getTotalBases() int64 {
+	idx.totalBasesOnce.Do(func() {
+		if idx.totalBases > 0 {
+			return
+		}
+		var err error
+		idx.totalBases, err = totalBasesOfGenomes(idx.path, idx.genomeBatches)
+		checkError(err)
+	})
+	return idx.totalBases
+}
+
 // SetSeqCompareOptions sets the sequence comparing options
 func (idx *Index) SetSeqCompareOptions(sco *SeqComparatorOptions) {
 	idx.seqCompareOption = sco
@@ -188,6 +271,9 @@ func NewIndexSearcher(outDir string, opt *IndexSearchingOptions) (*Index, error)
 
 	idx.contigInterval = info.ContigInterval
 
+	idx.genomeBatches = info.GenomeBatches
+	idx.totalBases = info.TotalBases // 0 for indexes created by old versions, see getTotalBases
+
 	// -----------------------------------------------------
 	// read masks
 	fileMask := filepath.Join(outDir, FileMasks)
@@ -323,6 +409,7 @@ func NewIndexSearcher(outDir string, opt *IndexSearchingOptions) (*Index, error)
 				if err != nil {
 					checkError(fmt.Errorf("failed to create a in-memory searcher from file: %s: %s", file, err))
 				}
+				scr.MaxOccurrences = uint64(opt.MaxSeedOccurrences)
 
 				chIM <- scr
 			} else { // just read the index data
@@ -330,6 +417,7 @@ func NewIndexSearcher(outDir string, opt *IndexSearchingOptions) (*Index, error)
 				if err != nil {
 					checkError(fmt.Errorf("failed to create a searcher from file: %s: %s", file, err))
 				}
+				scr.MaxOccurrences = uint64(opt.MaxSeedOccurrences)
 
 				ch <- scr
 			}
@@ -404,6 +492,19 @@ func NewIndexSearcher(outDir string, opt *IndexSearchingOptions) (*Index, error)
 	return idx, nil
 }
 
+// DroppedSeeds returns the number of matched k-mers skipped
+// because of MaxSeedOccurrences, for all queries searched.
+// It should be called after all searches are done.
+func (idx *Index) DroppedSeeds() (n uint64) {
+	for _, scr := range idx.Searchers {
+		n += scr.Dropped
+	}
+	for _, scr := range idx.InMemorySearchers {
+		n += scr.Dropped
+	}
+	return n
+}
+
 // Close closes the searcher.
 func (idx *Index) Close() error {
 	var _err error
@@ -613,6 +714,12 @@ type SearchResult struct {
 	// more about the alignment detail
 	SimilarityDetails *[]*SimilarityDetail // sequence comparing
 	AlignedFraction   float64              // query coverage per genome
+
+	// only for the genome-hits-only mode
+	NumSeeds     int     // number of chained seeds
+	SeedCoverage float64 // percentage of query bases covered by chained seeds
+
+	MAPQ uint8 // mapping-quality style uniqueness score of the query-genome hit
 }
 
 func (sr *SearchResult) SortBySeqID() {
@@ -698,6 +805,9 @@ func (r *SearchResult) Reset() {
 	r.Chains = nil
 	r.SimilarityDetails = nil
 	r.AlignedFraction = 0
+	r.NumSeeds = 0
+	r.SeedCoverage = 0
+	r.MAPQ = 0
 }
 
 // RecycleSearchResults recycles a search result object
@@ -753,15 +863,45 @@ var poolSearchResultsMap = &sync.Pool{New: func() interface{} {
 // --------------------------------------------------------------------------
 // searching
 
+// QueryLowComplexityRegions returns low-complexity regions of a query
+// found by DUST with the searching options, which are skipped in seeding.
+// After using the result, do not forget to call RecycleQueryLowComplexityRegions().
+func (idx *Index) QueryLowComplexityRegions(s []byte) *[][2]int {
+	regions := poolSkipRegions.Get().(*[][2]int)
+	*regions = (*regions)[:0]
+	util.Dust(s, idx.opt.QueryDustWindow, idx.opt.QueryDustThreshold, regions)
+	return regions
+}
+
+// RecycleQueryLowComplexityRegions recycles the result of QueryLowComplexityRegions().
+func (idx *Index) RecycleQueryLowComplexityRegions(regions *[][2]int) {
+	poolSkipRegions.Put(regions)
+}
+
 // Search queries the index with a sequence.
+// Low-complexity regions are skipped in seeding if QueryDust is on.
 // After using the result, do not forget to call RecycleSearchResult().
 func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
+	if !idx.opt.QueryDust {
+		return idx.SearchWithSkipRegions(s, nil)
+	}
+
+	regions := idx.QueryLowComplexityRegions(s)
+	defer idx.RecycleQueryLowComplexityRegions(regions)
+	return idx.SearchWithSkipRegions(s, *regions)
+}
+
+// SearchWithSkipRegions queries the index with a sequence,
+// k-mers overlapping with skipRegions (0-based, sorted, closed intervals) are not used as seeds,
+// while these regions are still aligned in extension.
+// After using the result, do not forget to call RecycleSearchResult().
+func (idx *Index) SearchWithSkipRegions(s []byte, skipRegions [][2]int) (*[]*SearchResult, error) {
 	// ----------------------------------------------------------------
 	// 1) mask the query sequence
 
 	// _kmers, _locses, err := idx.lh.Mask(s, nil)
 	// _kmers, _locses, err := idx.lh.MaskKnownPrefixes(s, nil)
-	_kmers, _locses, err := idx.lh.MaskKnownDistinctPrefixes(s, nil, true)
+	_kmers, _locses, err := idx.lh.MaskKnownDistinctPrefixes(s, skipRegions, true)
 	if err != nil {
 		return nil, err
 	}
@@ -1005,6 +1145,9 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 							r.Chains = nil            // important
 							r.SimilarityDetails = nil // important
 							r.AlignedFraction = 0
+							r.NumSeeds = 0
+							r.SeedCoverage = 0
+							r.MAPQ = 0
 
 							(*m)[refBatchAndIdx] = r
 						}
@@ -1119,7 +1262,15 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 		done <- 1
 	}()
 
+	excluded := idx.opt.ExcludedGenomes
 	for _, r := range *m {
+		if excluded != nil {
+			if _, ok := excluded[r.BatchGenomeIndex]; ok {
+				idx.RecycleSearchResult(r)
+				continue
+			}
+		}
+
 		tokens <- 1
 		wg.Add(1)
 
@@ -1172,6 +1323,10 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 		*rs = (*rs)[:topN]
 	}
 
+	if idx.opt.GenomeHitsOnly { // stop here, with no alignment
+		return idx.genomeHits(rs, len(s)), nil
+	}
+
 	// 3.3) alignment
 
 	rs2 := poolSearchResults.Get().(*[]*SearchResult)
@@ -1214,13 +1369,14 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 			minQcovGnm := idx.opt.MinQueryAlignedFractionInAGenome
 			minQcovHSP := idx.seqCompareOption.MinAlignedFraction
 			minPIdent := idx.seqCompareOption.MinIdentity
+			maxEvalue := idx.opt.MaxEvalue
+			sortByBitScore := idx.opt.SortByBitScore
 			extLen := idx.opt.ExtendLength
 			contigInterval := idx.contigInterval
 			outSeq := idx.opt.OutputSeq
 			accurateAlign := idx.opt.MoreAccurateAlignment
 
-			algn := wfa.New(wfa.DefaultPenalties, alignOption)
-			algn.AdaptiveReduction(wfa.DefaultAdaptiveOption)
+			algn := idx.seqCompareOption.newWFAAligner(alignOption)
 			// algn.AdaptiveReduction(&wfa.AdaptiveReductionOption{
 			// 	MinWFLen:    10,
 			// 	MaxDistDiff: 50,
@@ -1497,6 +1653,7 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 
 								hasResult := false
 								j := 0
+								var maxBitScore float64
 								for i, c := range *r2.Chains {
 									if accurateAlign {
 										_qseq = s[c.QBegin : c.QEnd+1]
@@ -1518,6 +1675,16 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 											c.AlignedFraction = 100
 										}
 										c.PIdent = float64(c.MatchedBases) / float64(cigar.AlignLen) * 100
+										idx.scoreChain(c, cigar.Ops, qlen)
+
+										// try to extend the alignment across the origin of a circular sequence
+										if iSeq < len(tSeq.Circular) && tSeq.Circular[iSeq] {
+											if cigar2, q2, t2 := idx.extendAcrossOrigin(algn, rdr, refID, *tSeq.SeqIDs[iSeq], tSeq.SeqSizes[iSeq],
+												rc, s, c, cr.QueryLen); cigar2 != nil {
+												wfa.RecycleAlignmentResult(cigar)
+												cigar, _qseq, _tseq = cigar2, q2, t2
+											}
+										}
 
 										if !outSeq {
 											wfa.RecycleAlignmentResult(cigar)
@@ -1525,9 +1692,10 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 									} else {
 										c.AlignedLength = c.AlignedBasesQ
 										c.Gaps = -1
+										idx.scoreChain(c, nil, qlen)
 									}
 
-									if c.AlignedFraction < minQcovHSP || c.PIdent < minPIdent {
+									if c.AlignedFraction < minQcovHSP || c.PIdent < minPIdent || (maxEvalue > 0 && c.Evalue > maxEvalue) {
 										poolChain2.Put(c)
 										(*r2.Chains)[i] = nil
 										continue
@@ -1578,6 +1746,9 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 									if !hasResult {
 										j = i
 									}
+									if c.BitScore > maxBitScore {
+										maxBitScore = c.BitScore
+									}
 									hasResult = true
 								}
 
@@ -1587,7 +1758,11 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 									// sd.Chain = (*r.Chains)[i]
 									sd.NSeeds = len(*chain)
 									sd.Similarity = r2
-									sd.SimilarityScore = float64(r2.AlignedBases) * (*r2.Chains)[j].PIdent
+									if sortByBitScore {
+										sd.SimilarityScore = maxBitScore
+									} else {
+										sd.SimilarityScore = float64(r2.AlignedBases) * (*r2.Chains)[j].PIdent
+									}
 									sd.SeqID = sd.SeqID[:0]
 									// fmt.Printf("target seq a: iSeq:%d, %s, pident:%f\n", iSeq, *tSeq.SeqIDs[iSeq], (*r2.Chains)[j].PIdent)
 									sd.SeqID = append(sd.SeqID, (*tSeq.SeqIDs[iSeq])...)
@@ -1686,6 +1861,7 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 
 						hasResult := false
 						j := 0
+						var maxBitScore float64
 						for i, c := range *r2.Chains {
 
 							if accurateAlign {
@@ -1709,6 +1885,16 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 									c.AlignedFraction = 100
 								}
 								c.PIdent = float64(c.MatchedBases) / float64(cigar.AlignLen) * 100
+								idx.scoreChain(c, cigar.Ops, qlen)
+
+								// try to extend the alignment across the origin of a circular sequence
+								if iSeq < len(tSeq.Circular) && tSeq.Circular[iSeq] {
+									if cigar2, q2, t2 := idx.extendAcrossOrigin(algn, rdr, refID, *tSeq.SeqIDs[iSeq], tSeq.SeqSizes[iSeq],
+										rc, s, c, cr.QueryLen); cigar2 != nil {
+										wfa.RecycleAlignmentResult(cigar)
+										cigar, _qseq, _tseq = cigar2, q2, t2
+									}
+								}
 
 								if !outSeq {
 									wfa.RecycleAlignmentResult(cigar)
@@ -1716,9 +1902,10 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 							} else {
 								c.AlignedLength = c.AlignedBasesQ
 								c.Gaps = -1
+								idx.scoreChain(c, nil, qlen)
 							}
 
-							if c.AlignedFraction < minQcovHSP || c.PIdent < minPIdent {
+							if c.AlignedFraction < minQcovHSP || c.PIdent < minPIdent || (maxEvalue > 0 && c.Evalue > maxEvalue) {
 								poolChain2.Put(c)
 								(*r2.Chains)[i] = nil
 								continue
@@ -1773,6 +1960,9 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 							if !hasResult {
 								j = i
 							}
+							if c.BitScore > maxBitScore {
+								maxBitScore = c.BitScore
+							}
 							hasResult = true
 						}
 
@@ -1781,7 +1971,11 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 							sd.RC = rc
 							sd.NSeeds = len(*chain)
 							sd.Similarity = r2
-							sd.SimilarityScore = float64(r2.AlignedBases) * (*r2.Chains)[j].PIdent
+							if sortByBitScore {
+								sd.SimilarityScore = maxBitScore
+							} else {
+								sd.SimilarityScore = float64(r2.AlignedBases) * (*r2.Chains)[j].PIdent
+							}
 							sd.SeqID = sd.SeqID[:0]
 							// fmt.Printf("target seq b: iSeq:%d, %s, pident:%f\n", iSeq, *tSeq.SeqIDs[iSeq], (*r2.Chains)[j].PIdent)
 							sd.SeqID = append(sd.SeqID, (*tSeq.SeqIDs[iSeq])...)
@@ -1818,6 +2012,10 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 				return
 			}
 
+			// remove partial alignments at both ends of circular sequences,
+			// which are covered by alignments across the origin.
+			removeHSPsInWrappedOnes(sds, qlen, sortByBitScore)
+
 			if !idx.hasGenomeChunks {
 				// compute aligned bases per genome
 				var alignedBasesGenome int
@@ -1857,7 +2055,12 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 				}
 			}
 
-			// Within each subject genome, alignments (HSP) are sorted by qcovHSP*pident
+			// Within each subject genome, alignments (HSP) are sorted by qcovHSP*pident or bit score
+			if sortByBitScore {
+				for _, sd := range *sds {
+					sortChainsByBitScore(*sd.Similarity.Chains)
+				}
+			}
 			// r.AlignResults = ars
 			sort.Slice(*sds, func(i, j int) bool {
 				return (*sds)[i].SimilarityScore > (*sds)[j].SimilarityScore
@@ -1988,7 +2191,7 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 				continue
 			}
 
-			// Within each subject genome, alignments (HSP) are sorted by qcovHSP*pident
+			// Within each subject genome, alignments (HSP) are sorted by qcovHSP*pident or bit score
 			// r.AlignResults = ars
 			sort.Slice(*r.SimilarityDetails, func(i, j int) bool {
 				return (*r.SimilarityDetails)[i].SimilarityScore > (*r.SimilarityDetails)[j].SimilarityScore
@@ -2000,11 +2203,13 @@ func (idx *Index) Search(s []byte) (*[]*SearchResult, error) {
 		*rs2 = (*rs2)[:j]
 	}
 
-	// sort all genomes, by qcovHSP*pident of the best alignment.
+	// sort all genomes, by qcovHSP*pident or bit score of the best alignment.
 	sort.Slice(*rs2, func(i, j int) bool {
 		return (*(*rs2)[i].SimilarityDetails)[0].SimilarityScore > (*(*rs2)[j].SimilarityDetails)[0].SimilarityScore
 	})
 
+	ComputeMAPQ(*rs2)
+
 	// ----------------------------------
 	// In each target genome, sort alignments by target seq first
 	// query
@@ -0,0 +1,248 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+
+	"github.com/shenwei356/LexicMap/lexicmap/cmd/genome"
+	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/bio/seqio/fastx"
+)
+
+// indexGenome contains sequences of a genome in the index.
+type indexGenome struct {
+	ID       string
+	SeqIDs   []string
+	Seqs     [][]byte
+	Circular []bool
+}
+
+// appendIndexGenomeSeqs splits the concatenated sequence of a genome (chunk) back into sequences,
+// and appends them to g.
+func appendIndexGenomeSeqs(rdr *genome.Reader, idx int, g *indexGenome) error {
+	tSeq, err := rdr.Seq(idx)
+	if err != nil {
+		return err
+	}
+	defer genome.RecycleGenome(tSeq)
+
+	var total int
+	for _, size := range tSeq.SeqSizes {
+		total += size
+	}
+	// the length of intervals between sequences
+	var interval int
+	if n := len(tSeq.SeqSizes); n > 1 {
+		interval = (len(tSeq.Seq) - total) / (n - 1)
+	}
+	if total+interval*max(len(tSeq.SeqSizes)-1, 0) != len(tSeq.Seq) {
+		return fmt.Errorf("unexpected length of the concatenated sequence: %d", len(tSeq.Seq))
+	}
+
+	// restore gap regions, where N's were saved as A's.
+	gaps, err := rdr.Gaps(idx)
+	if err != nil {
+		return err
+	}
+	for _, gap := range gaps {
+		if gap[1] > len(tSeq.Seq) {
+			return fmt.Errorf("gap region out of range: %d-%d", gap[0], gap[1])
+		}
+		for i := gap[0]; i < gap[1]; i++ {
+			tSeq.Seq[i] = 'N'
+		}
+	}
+
+	var start int
+	for i, size := range tSeq.SeqSizes {
+		s := make([]byte, size)
+		copy(s, tSeq.Seq[start:start+size])
+		g.Seqs = append(g.Seqs, s)
+		g.SeqIDs = append(g.SeqIDs, string(*tSeq.SeqIDs[i]))
+		g.Circular = append(g.Circular, i < len(tSeq.Circular) && tSeq.Circular[i])
+		start += size + interval
+	}
+	return nil
+}
+
+// indexGenomeIDs returns IDs of genomes in an index, in the order of genomes in the index.
+// Big genomes split into chunks only appear once.
+func indexGenomeIDs(dbDir string) ([]string, error) {
+	names, err := readGenomeList(filepath.Join(dbDir, FileGenomeIndex))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read genomes index mapping file: %s", err)
+	}
+	ids := make([]string, 0, len(names))
+	done := make(map[string]interface{}, len(names))
+	var ok bool
+	for _, name := range names {
+		if _, ok = done[name]; ok {
+			continue
+		}
+		done[name] = struct{}{}
+		ids = append(ids, name)
+	}
+	return ids, nil
+}
+
+// genomeSourceIndex reads genomes from an existing index, which is used for
+// rebuilding an index with new parameters without the original sequence files.
+// It is safe for concurrent use.
+type genomeSourceIndex struct {
+	dir      string
+	name2idx map[string]*[]uint64
+
+	mu       sync.Mutex
+	free     map[int][]*genome.Reader // idle readers of each batch
+	maxBatch int
+}
+
+// newGenomeSourceIndex creates a genome source from an index.
+func newGenomeSourceIndex(dir string) (*genomeSourceIndex, error) {
+	name2idx, err := readGenomeMapName2Idx(filepath.Join(dir, FileGenomeIndex))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read genomes index mapping file: %s", err)
+	}
+	return &genomeSourceIndex{
+		dir:      dir,
+		name2idx: name2idx,
+		free:     make(map[int][]*genome.Reader, 8),
+	}, nil
+}
+
+// getReader returns an idle reader of a genome batch, or opens a new one.
+func (s *genomeSourceIndex) getReader(batch int) (*genome.Reader, error) {
+	s.mu.Lock()
+	if rdrs := s.free[batch]; len(rdrs) > 0 {
+		rdr := rdrs[len(rdrs)-1]
+		s.free[batch] = rdrs[:len(rdrs)-1]
+		s.mu.Unlock()
+		return rdr, nil
+	}
+	s.maxBatch = max(s.maxBatch, batch)
+	s.mu.Unlock()
+
+	return genome.NewReader(filepath.Join(s.dir, DirGenomes, batchDir(batch), FileGenomes))
+}
+
+// putReader returns a reader. As genomes are read in the order of the index mostly,
+// readers of previous batches are closed to limit the number of opened files.
+func (s *genomeSourceIndex) putReader(batch int, rdr *genome.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if batch < s.maxBatch {
+		return rdr.Close()
+	}
+	s.free[batch] = append(s.free[batch], rdr)
+	return nil
+}
+
+// Close closes all idle readers.
+func (s *genomeSourceIndex) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for batch, rdrs := range s.free {
+		for _, rdr := range rdrs {
+			if _err := rdr.Close(); _err != nil {
+				err = _err
+			}
+		}
+		delete(s.free, batch)
+	}
+	return err
+}
+
+// Genome reads all sequences of a genome, with sequences of genome chunks merged.
+func (s *genomeSourceIndex) Genome(name string) (*indexGenome, error) {
+	batchIDAndRefIDs, ok := s.name2idx[name]
+	if !ok {
+		return nil, fmt.Errorf("genome not found in the index: %s", name)
+	}
+
+	g := &indexGenome{ID: name}
+	for _, batchIDAndRefID := range *batchIDAndRefIDs {
+		genomeBatch := int(batchIDAndRefID >> BITS_GENOME_IDX)
+		genomeIdx := int(batchIDAndRefID & MASK_GENOME_IDX)
+
+		rdr, err := s.getReader(genomeBatch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read genome data file: %s", err)
+		}
+		err = appendIndexGenomeSeqs(rdr, genomeIdx, g)
+		if _err := s.putReader(genomeBatch, rdr); err == nil {
+			err = _err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read genome %s: %s", name, err)
+		}
+	}
+	return g, nil
+}
+
+// GenomeChunks returns batch+refIdx of all chunks of a genome.
+func (s *genomeSourceIndex) GenomeChunks(name string) (map[uint64]interface{}, error) {
+	batchIDAndRefIDs, ok := s.name2idx[name]
+	if !ok {
+		return nil, fmt.Errorf("genome not found in the index: %s", name)
+	}
+
+	chunks := make(map[uint64]interface{}, len(*batchIDAndRefIDs))
+	for _, batchIDAndRefID := range *batchIDAndRefIDs {
+		chunks[batchIDAndRefID] = struct{}{}
+	}
+	return chunks, nil
+}
+
+// indexRecordReader returns sequences of a genome in an index as FASTA records,
+// just like a fastx.Reader.
+type indexRecordReader struct {
+	g *indexGenome
+	i int
+}
+
+// Read returns the next sequence, or io.EOF.
+func (r *indexRecordReader) Read() (*fastx.Record, error) {
+	if r.i == len(r.g.Seqs) {
+		return nil, io.EOF
+	}
+	id := []byte(r.g.SeqIDs[r.i])
+	record, err := fastx.NewRecordWithoutValidation(seq.DNAredundant, id, id, nil, r.g.Seqs[r.i])
+	if err != nil {
+		return nil, err
+	}
+	r.i++
+	return record, nil
+}
+
+// Circular tells if the last returned sequence is circular.
+func (r *indexRecordReader) Circular() bool {
+	return r.i > 0 && r.g.Circular[r.i-1]
+}
+
+// Close does nothing, just for being compatible with fastx.Reader.
+func (r *indexRecordReader) Close() {}
@@ -0,0 +1,188 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIndexRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	r := rand.New(rand.NewSource(1))
+	randSeq := func(n int) string {
+		s := make([]byte, n)
+		for i := range s {
+			s[i] = "ACGT"[r.Intn(4)]
+		}
+		return string(s)
+	}
+
+	// genome -> sequences (seqid, sequence)
+	genomes := map[string][][2]string{
+		"g1": {
+			{"g1_chr", randSeq(3000) + strings.Repeat("N", 20) + randSeq(2000)}, // a gap region
+			{"g1_plasmid", randSeq(2000) + "N" + randSeq(1000)},                 // a single N
+		},
+		"g2": {
+			{"g2_chr", strings.Repeat("N", 10) + randSeq(4000)}, // a gap region at the beginning
+		},
+	}
+	ids := []string{"g1", "g2"}
+	files := make([]string, 0, len(ids))
+	for _, id := range ids {
+		var b strings.Builder
+		for _, s := range genomes[id] {
+			b.WriteString(">" + s[0] + "\n" + s[1] + "\n")
+		}
+		file := filepath.Join(dir, id+".fa")
+		if err := os.WriteFile(file, []byte(b.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+
+	newOptions := func() *IndexBuildingOptions {
+		return &IndexBuildingOptions{
+			NumCPUs:                1,
+			MaxOpenFiles:           512,
+			MergeThreads:           1,
+			MinSeqLen:              -1,
+			MaxGenomeSize:          15000000,
+			K:                      31,
+			Masks:                  64,
+			RandSeed:               1,
+			DesertMaxLen:           200,
+			DesertExpectedSeedDist: 50,
+			DesertSeedPosRange:     25,
+			Chunks:                 2,
+			Partitions:             4,
+			GenomeBatchSize:        5000,
+			ContigInterval:         1000,
+			CircularSeqs:           map[string]interface{}{"g1_plasmid": struct{}{}},
+		}
+	}
+
+	// sequences expected in the index: gap regions are kept, and other N's are saved as A's
+	check := func(dbDir string) {
+		n := 0
+		err := iterateIndexGenomes(dbDir, nil, func(g *indexGenome) error {
+			expected := genomes[g.ID]
+			if len(g.Seqs) != len(expected) {
+				t.Fatalf("%s: %d sequences expected, %d returned", g.ID, len(expected), len(g.Seqs))
+			}
+			for i, s := range expected {
+				if g.SeqIDs[i] != s[0] {
+					t.Errorf("%s: unexpected sequence ID: %s", g.ID, g.SeqIDs[i])
+				}
+				seq := s[1]
+				if !strings.Contains(seq, "NNNNN") {
+					seq = strings.ReplaceAll(seq, "N", "A")
+				}
+				if string(g.Seqs[i]) != seq {
+					t.Errorf("%s: unexpected sequence of %s", g.ID, s[0])
+				}
+				if g.Circular[i] != (s[0] == "g1_plasmid") {
+					t.Errorf("%s: unexpected topology of %s", g.ID, s[0])
+				}
+			}
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(genomes) {
+			t.Errorf("%d genomes expected, %d returned", len(genomes), n)
+		}
+	}
+
+	dbDir1 := filepath.Join(dir, "idx1")
+	if err := BuildIndex(dbDir1, files, newOptions()); err != nil {
+		t.Fatal(err)
+	}
+	check(dbDir1)
+
+	// the search space for E-values of indexes created by old versions,
+	// i.e., without the total bases in the info file, should be the same.
+	info, err := readIndexInfo(filepath.Join(dbDir1, FileInfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	total, err := totalBasesOfGenomes(dbDir1, info.GenomeBatches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.TotalBases == 0 || total != info.TotalBases {
+		t.Errorf("unexpected total bases: %d, expected: %d", total, info.TotalBases)
+	}
+
+	// rebuilding from the index
+	dbDir2 := filepath.Join(dir, "idx2")
+	opt := newOptions()
+	opt.SourceIndex = dbDir1
+	opt.CircularSeqs = nil // the topology is from the source index
+	ids2, err := indexGenomeIDs(dbDir1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = BuildIndex(dbDir2, ids2, opt); err != nil {
+		t.Fatal(err)
+	}
+	check(dbDir2)
+
+	// only some genomes
+	var found []string
+	err = iterateIndexGenomes(dbDir2, map[string]interface{}{"g2": struct{}{}, "g3": struct{}{}},
+		func(g *indexGenome) error {
+			found = append(found, g.ID)
+			return nil
+		})
+	if err != nil || len(found) != 1 || found[0] != "g2" {
+		t.Errorf("unexpected genomes: %v, %v", found, err)
+	}
+
+	// reading sequences as FASTA records
+	src, err := newGenomeSourceIndex(dbDir2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	g, err := src.Genome("g2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdr := &indexRecordReader{g: g}
+	record, err := rdr.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(record.ID) != "g2_chr" || string(record.Seq.Seq) != genomes["g2"][0][1] || rdr.Circular() {
+		t.Errorf("unexpected record: %s", record.ID)
+	}
+	if _, err = rdr.Read(); err != io.EOF {
+		t.Errorf("io.EOF expected, %v returned", err)
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// segment types of HSPs of long reads.
+const (
+	SegPrimary       uint8 = iota // in the best collinear chain of HSPs
+	SegSupplementary              // in other collinear chains, covering other regions of the query
+	SegSecondary                  // alternative alignments of regions already covered by other segments
+)
+
+// SegTypeNames are the names of segment types.
+var SegTypeNames = [3]string{"primary", "supplementary", "secondary"}
+
+// LongReadOptions contains the options for grouping HSPs of long reads into segments.
+type LongReadOptions struct {
+	// maximum distance between two collinear HSPs in the subject sequence,
+	// longer distances are treated as breakpoints (e.g., large deletions).
+	MaxGap int
+	// maximum overlap between two collinear HSPs in the query or subject sequence.
+	MaxOverlap int
+	// HSPs with a higher fraction of query region covered by existing segments are secondary.
+	MaxCoveredFraction float64
+}
+
+// DefaultLongReadOptions is the default LongReadOptions.
+var DefaultLongReadOptions = LongReadOptions{
+	MaxGap:             10000,
+	MaxOverlap:         50,
+	MaxCoveredFraction: 0.5,
+}
+
+// CheckLongReadOptions checks the options.
+func CheckLongReadOptions(opt *LongReadOptions) error {
+	if opt.MaxGap < 0 {
+		return fmt.Errorf("invalid maximum gap: %d, should be >= 0", opt.MaxGap)
+	}
+	if opt.MaxOverlap < 0 {
+		return fmt.Errorf("invalid maximum overlap: %d, should be >= 0", opt.MaxOverlap)
+	}
+	if opt.MaxCoveredFraction <= 0 || opt.MaxCoveredFraction > 1 {
+		return fmt.Errorf("invalid maximum covered fraction: %f, should be in range of (0, 1]", opt.MaxCoveredFraction)
+	}
+	return nil
+}
+
+// Breakpoint is the junction between two adjacent segments (in the query) of a long read.
+type Breakpoint struct {
+	QPos int // start position of the latter segment in the query (0-based)
+
+	PreSeqID  []byte // subject sequence of the previous segment
+	PrePos    int    // end position of the previous segment in the subject (0-based)
+	PreRC     bool   // strand of the previous segment
+	NextSeqID []byte // subject sequence of the latter segment
+	NextPos   int    // start position of the latter segment in the subject (0-based)
+	NextRC    bool   // strand of the latter segment
+}
+
+// StrandSwitch tells if the two segments are on different strands.
+func (b *Breakpoint) StrandSwitch() bool {
+	return b.PreRC != b.NextRC
+}
+
+// hspOfRead is an HSP of a long read with its subject sequence.
+type hspOfRead struct {
+	sd  *SimilarityDetail
+	c   *Chain2Result
+	seg int // segment id, -1 for secondary
+}
+
+// AnnotateSegments groups HSPs of a query in a genome into a primary collinear chain and
+// supplementary segments, while alternative HSPs of query regions already covered are secondary.
+// Breakpoints between adjacent segments (sorted by query positions) are annotated
+// to the first HSP of the latter segment.
+// Note that HSPs in different genomes are not considered.
+func AnnotateSegments(r *SearchResult, opt *LongReadOptions) {
+	hsps := make([]*hspOfRead, 0, 8)
+	for _, sd := range *r.SimilarityDetails {
+		for _, c := range *sd.Similarity.Chains {
+			if c == nil {
+				continue
+			}
+			c.SegType = SegSecondary
+			c.Breakpoint = nil
+			hsps = append(hsps, &hspOfRead{sd: sd, c: c, seg: -1})
+		}
+	}
+	if len(hsps) == 0 {
+		return
+	}
+
+	sort.Slice(hsps, func(i, j int) bool {
+		return hsps[i].c.QBegin < hsps[j].c.QBegin
+	})
+
+	// covered regions of the query
+	covered := make([][2]int, 0, 8)
+
+	remain := make([]*hspOfRead, len(hsps))
+	copy(remain, hsps)
+	scores := make([]int, len(hsps))
+	from := make([]int, len(hsps))
+	var seg int
+	var best, i, j int
+	for len(remain) > 0 {
+		// the best collinear chain, with the largest aligned query bases
+		best = -1
+		for i = range remain {
+			scores[i] = remain[i].c.QEnd - remain[i].c.QBegin + 1
+			from[i] = -1
+			for j = 0; j < i; j++ {
+				if collinearHSPs(remain[j], remain[i], opt) && scores[j]+remain[i].c.QEnd-remain[i].c.QBegin+1 > scores[i] {
+					scores[i] = scores[j] + remain[i].c.QEnd - remain[i].c.QBegin + 1
+					from[i] = j
+				}
+			}
+			if best < 0 || scores[i] > scores[best] {
+				best = i
+			}
+		}
+
+		for i = best; i >= 0; i = from[i] {
+			remain[i].seg = seg
+			if seg == 0 {
+				remain[i].c.SegType = SegPrimary
+			} else {
+				remain[i].c.SegType = SegSupplementary
+			}
+			covered = append(covered, [2]int{remain[i].c.QBegin, remain[i].c.QEnd})
+		}
+		seg++
+
+		// remove HSPs in the chain and secondary ones
+		j = 0
+		for _, h := range remain {
+			if h.seg >= 0 {
+				continue
+			}
+			if coveredFraction(h.c, covered) > opt.MaxCoveredFraction {
+				continue // secondary
+			}
+			remain[j] = h
+			j++
+		}
+		remain = remain[:j]
+	}
+
+	// breakpoints between adjacent segments
+	var pre *hspOfRead
+	for _, h := range hsps {
+		if h.seg < 0 {
+			continue
+		}
+		if pre != nil && pre.seg != h.seg {
+			b := &Breakpoint{
+				QPos:      h.c.QBegin,
+				PreSeqID:  pre.sd.SeqID,
+				PreRC:     pre.sd.RC,
+				NextSeqID: h.sd.SeqID,
+				NextRC:    h.sd.RC,
+			}
+			if pre.sd.RC {
+				b.PrePos = pre.c.TBegin
+			} else {
+				b.PrePos = pre.c.TEnd
+			}
+			if h.sd.RC {
+				b.NextPos = h.c.TEnd
+			} else {
+				b.NextPos = h.c.TBegin
+			}
+			h.c.Breakpoint = b
+		}
+		pre = h
+	}
+}
+
+// collinearHSPs checks if HSP b could follow HSP a in a collinear chain.
+// HSPs should be on the same subject sequence and strand, in the same order in the query
+// and the (strand-oriented) subject, with small overlaps and gaps.
+func collinearHSPs(a, b *hspOfRead, opt *LongReadOptions) bool {
+	if a.sd.RC != b.sd.RC || !bytes.Equal(a.sd.SeqID, b.sd.SeqID) {
+		return false
+	}
+	if b.c.QBegin < a.c.QEnd-opt.MaxOverlap || b.c.QEnd <= a.c.QEnd {
+		return false
+	}
+	var gap int
+	if a.sd.RC {
+		if b.c.TEnd >= a.c.TEnd {
+			return false
+		}
+		gap = a.c.TBegin - b.c.TEnd
+	} else {
+		if b.c.TBegin <= a.c.TBegin {
+			return false
+		}
+		gap = b.c.TBegin - a.c.TEnd
+	}
+	return gap >= -opt.MaxOverlap && gap <= opt.MaxGap
+}
+
+// coveredFraction computes the fraction of the query region of an HSP covered by some regions.
+func coveredFraction(c *Chain2Result, regions [][2]int) float64 {
+	var n, b, e int
+	for _, r := range regions {
+		b, e = max(r[0], c.QBegin), min(r[1], c.QEnd)
+		if e >= b {
+			n += e - b + 1
+		}
+	}
+	f := float64(n) / float64(c.QEnd-c.QBegin+1)
+	if f > 1 {
+		f = 1
+	}
+	return f
+}
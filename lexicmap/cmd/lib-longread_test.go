@@ -0,0 +1,69 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"testing"
+)
+
+func TestAnnotateSegments(t *testing.T) {
+	newSD := func(seqid string, rc bool, hsps ...[4]int) *SimilarityDetail {
+		chains := make([]*Chain2Result, 0, len(hsps))
+		for _, h := range hsps {
+			chains = append(chains, &Chain2Result{QBegin: h[0], QEnd: h[1], TBegin: h[2], TEnd: h[3]})
+		}
+		return &SimilarityDetail{RC: rc, SeqID: []byte(seqid),
+			Similarity: &SeqComparatorResult{Chains: &chains}}
+	}
+
+	// a read with an insertion from another place on the negative strand,
+	// and an alternative alignment of the first part.
+	sd1 := newSD("s1", false, [4]int{0, 2999, 100000, 102999}, [4]int{5000, 6999, 103000, 104999})
+	sd2 := newSD("s1", true, [4]int{3000, 4999, 2000000, 2001999})
+	sd3 := newSD("s2", false, [4]int{100, 2900, 500, 3300})
+	r := &SearchResult{SimilarityDetails: &[]*SimilarityDetail{sd1, sd2, sd3}}
+
+	AnnotateSegments(r, &DefaultLongReadOptions)
+
+	c1, c2 := (*sd1.Similarity.Chains)[0], (*sd1.Similarity.Chains)[1]
+	c3 := (*sd2.Similarity.Chains)[0]
+	c4 := (*sd3.Similarity.Chains)[0]
+
+	if c1.SegType != SegPrimary || c2.SegType != SegPrimary {
+		t.Errorf("primary segments expected")
+	}
+	if c3.SegType != SegSupplementary {
+		t.Errorf("a supplementary segment expected")
+	}
+	if c4.SegType != SegSecondary {
+		t.Errorf("a secondary segment expected")
+	}
+
+	if c1.Breakpoint != nil || c4.Breakpoint != nil {
+		t.Errorf("unexpected breakpoints")
+	}
+	if b := c3.Breakpoint; b == nil || b.QPos != 3000 || b.PrePos != 102999 || b.NextPos != 2001999 || !b.StrandSwitch() {
+		t.Errorf("unexpected breakpoint: %+v", b)
+	}
+	if b := c2.Breakpoint; b == nil || b.QPos != 5000 || b.PrePos != 2000000 || b.NextPos != 103000 || !b.StrandSwitch() {
+		t.Errorf("unexpected breakpoint: %+v", b)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import "math"
+
+// MaxMAPQ is the maximum mapping quality.
+const MaxMAPQ = 60
+
+// genomeHitScore is the score of a query-genome hit for computing MAPQ:
+// the chaining score times the identity of the best HSP (with the highest bit score),
+// so that hits with similar seed matches could still be distinguished by alignments.
+func genomeHitScore(r *SearchResult) float64 {
+	if r.SimilarityDetails == nil {
+		return 0
+	}
+	var best *Chain2Result
+	for _, sd := range *r.SimilarityDetails {
+		for _, c := range *sd.Similarity.Chains {
+			if c == nil {
+				continue
+			}
+			if best == nil || c.BitScore > best.BitScore {
+				best = c
+			}
+		}
+	}
+	if best == nil {
+		return 0
+	}
+	return r.Score * best.PIdent / 100
+}
+
+// ComputeMAPQ computes a mapping-quality style uniqueness score for each query-genome hit,
+// similar to that of minimap2:
+//
+//	mapq = 40 * (1 - s2/s1) * ln(s1)
+//
+// where s1 is the score of the hit, s2 is the highest score of the other genomes,
+// and the score of a hit is the chaining score times the identity of its best HSP.
+// The values are capped to [0, 60], i.e., all hits except the best one have a MAPQ of 0,
+// and the best one gets a low MAPQ if the second best genome is nearly as good.
+func ComputeMAPQ(rs []*SearchResult) {
+	if len(rs) == 0 {
+		return
+	}
+
+	// the highest and second highest scores
+	var s1, s2 float64
+	var s float64
+	i1 := -1
+	for i, r := range rs {
+		s = genomeHitScore(r)
+		if s > s1 {
+			s2 = s1
+			s1 = s
+			i1 = i
+		} else if s > s2 {
+			s2 = s
+		}
+	}
+
+	for i, r := range rs {
+		r.MAPQ = 0
+		if i != i1 || s1 <= 1 {
+			continue
+		}
+		r.MAPQ = uint8(math.Round(math.Min(MaxMAPQ, math.Max(0, 40*(1-s2/s1)*math.Log(s1)))))
+	}
+}
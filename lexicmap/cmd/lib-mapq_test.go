@@ -0,0 +1,62 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"testing"
+)
+
+func TestComputeMAPQ(t *testing.T) {
+	newResult := func(score float64, pident float64) *SearchResult {
+		c := &Chain2Result{PIdent: pident, BitScore: 100}
+		sd := &SimilarityDetail{Similarity: &SeqComparatorResult{Chains: &[]*Chain2Result{c}}}
+		return &SearchResult{Score: score, SimilarityDetails: &[]*SimilarityDetail{sd}}
+	}
+
+	// a unique hit
+	rs := []*SearchResult{newResult(1000, 99)}
+	ComputeMAPQ(rs)
+	if rs[0].MAPQ != MaxMAPQ {
+		t.Errorf("unexpected MAPQ of a unique hit: %d, expected: %d", rs[0].MAPQ, MaxMAPQ)
+	}
+
+	// two equally good hits
+	rs = []*SearchResult{newResult(1000, 99), newResult(1000, 99)}
+	ComputeMAPQ(rs)
+	if rs[0].MAPQ != 0 || rs[1].MAPQ != 0 {
+		t.Errorf("unexpected MAPQs of equal hits: %d, %d, expected: 0", rs[0].MAPQ, rs[1].MAPQ)
+	}
+
+	// the best one is distinguishable
+	rs = []*SearchResult{newResult(600, 95), newResult(1000, 99)}
+	ComputeMAPQ(rs)
+	if rs[0].MAPQ != 0 || rs[1].MAPQ == 0 || rs[1].MAPQ > MaxMAPQ {
+		t.Errorf("unexpected MAPQs: %d, %d", rs[0].MAPQ, rs[1].MAPQ)
+	}
+
+	// a closer second best gives a lower MAPQ
+	mapq := rs[1].MAPQ
+	rs = []*SearchResult{newResult(900, 99), newResult(1000, 99)}
+	ComputeMAPQ(rs)
+	if rs[1].MAPQ >= mapq {
+		t.Errorf("MAPQ should decrease with a closer second best hit: %d >= %d", rs[1].MAPQ, mapq)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// PairingOptions contains the options for pairing alignments of paired-end reads.
+type PairingOptions struct {
+	MinInsertSize int // minimum insert size
+	MaxInsertSize int // maximum insert size
+}
+
+// DefaultPairingOptions is the default options for Illumina paired-end reads.
+var DefaultPairingOptions = PairingOptions{
+	MinInsertSize: 1,
+	MaxInsertSize: 1000,
+}
+
+// CheckPairingOptions checks the pairing options.
+func CheckPairingOptions(opt *PairingOptions) error {
+	if opt.MinInsertSize < 1 {
+		return fmt.Errorf("invalid minimum insert size: %d, should be >= 1", opt.MinInsertSize)
+	}
+	if opt.MaxInsertSize < opt.MinInsertSize {
+		return fmt.Errorf("invalid maximum insert size: %d, should be >= the minimum insert size (%d)",
+			opt.MaxInsertSize, opt.MinInsertSize)
+	}
+	return nil
+}
+
+// MatePair is a pair of HSPs of paired-end reads in a subject genome.
+type MatePair struct {
+	R1, R2   *SearchResult // results of the two mates in the genome
+	SD1, SD2 *SimilarityDetail
+	C1, C2   *Chain2Result
+
+	InsertSize int
+	Score      float64 // the sum of bit scores of the two HSPs
+}
+
+// PairMates finds the best pairs of HSPs in genomes where both mates are aligned
+// to the same sequence, in the forward-reverse orientation (the mate on the positive strand
+// is upstream, i.e., it does not start after the other mate), with an insert size in the given range.
+// Dovetailed pairs are not regarded as proper pairs.
+// Pairs are sorted by score in descending order, so the first one is the pair-level best hit.
+func PairMates(rs1, rs2 *[]*SearchResult, opt *PairingOptions) []*MatePair {
+	if rs1 == nil || rs2 == nil || len(*rs1) == 0 || len(*rs2) == 0 {
+		return nil
+	}
+
+	genomes := make(map[string]*SearchResult, len(*rs2))
+	for _, r := range *rs2 {
+		genomes[string(r.ID)] = r
+	}
+
+	pairs := make([]*MatePair, 0, len(*rs1))
+	var r2 *SearchResult
+	var ok bool
+	var best *MatePair
+	var insertSize int
+	var score float64
+	var fwd, rev *Chain2Result
+	for _, r1 := range *rs1 {
+		if r2, ok = genomes[string(r1.ID)]; !ok {
+			continue
+		}
+
+		best = nil
+		for _, sd1 := range *r1.SimilarityDetails {
+			for _, sd2 := range *r2.SimilarityDetails {
+				if sd1.RC == sd2.RC || !bytes.Equal(sd1.SeqID, sd2.SeqID) {
+					continue
+				}
+				for _, c1 := range *sd1.Similarity.Chains {
+					if c1 == nil {
+						continue
+					}
+					for _, c2 := range *sd2.Similarity.Chains {
+						if c2 == nil {
+							continue
+						}
+
+						fwd, rev = c1, c2
+						if sd1.RC { // R2 is on the positive strand
+							fwd, rev = c2, c1
+						}
+						if fwd.TBegin > rev.TBegin { // dovetailed, the reverse mate starts before the forward one
+							continue
+						}
+						insertSize = rev.TEnd - fwd.TBegin + 1
+						if insertSize < opt.MinInsertSize || insertSize > opt.MaxInsertSize {
+							continue
+						}
+
+						score = c1.BitScore + c2.BitScore
+						if best == nil || score > best.Score {
+							if best == nil {
+								best = &MatePair{}
+							}
+							best.R1, best.R2 = r1, r2
+							best.SD1, best.SD2 = sd1, sd2
+							best.C1, best.C2 = c1, c2
+							best.InsertSize = insertSize
+							best.Score = score
+						}
+					}
+				}
+			}
+		}
+
+		if best != nil {
+			pairs = append(pairs, best)
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].Score > pairs[j].Score
+	})
+
+	return pairs
+}
@@ -0,0 +1,88 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"testing"
+)
+
+func TestPairMates(t *testing.T) {
+	newResult := func(genome string, seqid string, rc bool, tBegin, tEnd int, bitScore float64) *[]*SearchResult {
+		c := &Chain2Result{TBegin: tBegin, TEnd: tEnd, BitScore: bitScore}
+		sd := &SimilarityDetail{RC: rc, SeqID: []byte(seqid),
+			Similarity: &SeqComparatorResult{Chains: &[]*Chain2Result{c}}}
+		r := &SearchResult{ID: []byte(genome), SimilarityDetails: &[]*SimilarityDetail{sd}}
+		return &[]*SearchResult{r}
+	}
+	opt := &DefaultPairingOptions
+
+	// forward-reverse, insert size: 450
+	pairs := PairMates(newResult("g1", "s1", false, 1000, 1149, 200), newResult("g1", "s1", true, 1300, 1449, 200), opt)
+	if len(pairs) != 1 || pairs[0].InsertSize != 450 {
+		t.Errorf("a proper pair expected")
+	}
+
+	// read 1 on the negative strand
+	pairs = PairMates(newResult("g1", "s1", true, 1300, 1449, 200), newResult("g1", "s1", false, 1000, 1149, 200), opt)
+	if len(pairs) != 1 || pairs[0].InsertSize != 450 {
+		t.Errorf("a proper pair expected")
+	}
+
+	// same strand
+	pairs = PairMates(newResult("g1", "s1", false, 1000, 1149, 200), newResult("g1", "s1", false, 1300, 1449, 200), opt)
+	if len(pairs) != 0 {
+		t.Errorf("improper orientation")
+	}
+
+	// reverse-forward
+	pairs = PairMates(newResult("g1", "s1", true, 1000, 1149, 200), newResult("g1", "s1", false, 1300, 1449, 200), opt)
+	if len(pairs) != 0 {
+		t.Errorf("improper orientation")
+	}
+
+	// dovetailed: the reverse mate starts before the forward mate, with a positive insert size of 151
+	pairs = PairMates(newResult("g1", "s1", false, 500, 600, 200), newResult("g1", "s1", true, 450, 650, 200), opt)
+	if len(pairs) != 0 {
+		t.Errorf("dovetailed mates")
+	}
+	pairs = PairMates(newResult("g1", "s1", true, 450, 650, 200), newResult("g1", "s1", false, 500, 600, 200), opt)
+	if len(pairs) != 0 {
+		t.Errorf("dovetailed mates")
+	}
+
+	// overlapping mates starting at the same position, i.e., the insert is shorter than reads
+	pairs = PairMates(newResult("g1", "s1", false, 500, 600, 200), newResult("g1", "s1", true, 500, 600, 200), opt)
+	if len(pairs) != 1 || pairs[0].InsertSize != 101 {
+		t.Errorf("a proper pair expected")
+	}
+
+	// too large insert size
+	pairs = PairMates(newResult("g1", "s1", false, 1000, 1149, 200), newResult("g1", "s1", true, 5300, 5449, 200), opt)
+	if len(pairs) != 0 {
+		t.Errorf("improper insert size")
+	}
+
+	// different sequences
+	pairs = PairMates(newResult("g1", "s1", false, 1000, 1149, 200), newResult("g1", "s2", true, 1300, 1449, 200), opt)
+	if len(pairs) != 0 {
+		t.Errorf("mates on different sequences")
+	}
+}
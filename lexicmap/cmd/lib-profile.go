@@ -0,0 +1,246 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"math"
+)
+
+// ProfileOptions contains the options for read-based abundance profiling.
+type ProfileOptions struct {
+	// per-base error rate, which is used to weight alignments of a multi-mapping read
+	// by the number of mismatched bases.
+	ErrorRate float64
+
+	MaxIterations int     // maximum number of EM iterations
+	Tolerance     float64 // the EM stops when changes of all read fractions are below this value
+}
+
+// DefaultProfileOptions is the default options for profiling.
+var DefaultProfileOptions = ProfileOptions{
+	ErrorRate:     0.01,
+	MaxIterations: 1000,
+	Tolerance:     1e-7,
+}
+
+// CheckProfileOptions checks the profiling options.
+func CheckProfileOptions(opt *ProfileOptions) error {
+	if opt.ErrorRate <= 0 || opt.ErrorRate >= 0.5 {
+		return fmt.Errorf("invalid error rate: %f, valid range: (0, 0.5)", opt.ErrorRate)
+	}
+	if opt.MaxIterations < 1 {
+		return fmt.Errorf("invalid maximum number of iterations: %d, should be >= 1", opt.MaxIterations)
+	}
+	if opt.Tolerance <= 0 {
+		return fmt.Errorf("invalid tolerance: %f, should be > 0", opt.Tolerance)
+	}
+	return nil
+}
+
+// ReadHit is the best alignment of a read in a genome.
+type ReadHit struct {
+	Genome        int     // index of the genome
+	PIdent        float64 // percentage of identity
+	AlignedLength int     // aligned length
+}
+
+// readHit is a ReadHit with the likelihood weight.
+type readHit struct {
+	genome int
+	weight float64
+	alen   float64
+}
+
+// Profiler collects alignments of reads and estimates abundances of genomes
+// with the expectation-maximization (EM) algorithm.
+type Profiler struct {
+	opt *ProfileOptions
+
+	genomeSizes []float64
+
+	lambda float64 // weight of a mismatched base, ln((1-e)/e)
+
+	uniqueReads []int     // numbers of reads only mapped to a genome
+	uniqueBases []float64 // aligned bases of unique reads
+	multiReads  [][]readHit
+}
+
+// NewProfiler creates a Profiler with sizes of all genomes, which are indexed by ReadHit.Genome.
+func NewProfiler(genomeSizes []int, opt *ProfileOptions) *Profiler {
+	p := &Profiler{
+		opt:         opt,
+		genomeSizes: make([]float64, len(genomeSizes)),
+		lambda:      math.Log((1 - opt.ErrorRate) / opt.ErrorRate),
+		uniqueReads: make([]int, len(genomeSizes)),
+		uniqueBases: make([]float64, len(genomeSizes)),
+		multiReads:  make([][]readHit, 0, 1024),
+	}
+	for i, s := range genomeSizes {
+		p.genomeSizes[i] = float64(max(s, 1))
+	}
+	return p
+}
+
+// AddRead adds alignments of a read, with at most one hit for each genome.
+func (p *Profiler) AddRead(hits []ReadHit) {
+	if len(hits) == 0 {
+		return
+	}
+	if len(hits) == 1 {
+		p.uniqueReads[hits[0].Genome]++
+		p.uniqueBases[hits[0].Genome] += float64(hits[0].AlignedLength)
+		return
+	}
+
+	// the likelihood of a read from a genome is (e/(1-e))^m, where m is the number of mismatched bases.
+	// here, m is relative to the minimum one, to avoid underflow.
+	hs := make([]readHit, len(hits))
+	minM := math.MaxFloat64
+	var m float64
+	for i, h := range hits {
+		m = float64(h.AlignedLength) * (100 - h.PIdent) / 100
+		hs[i] = readHit{genome: h.Genome, weight: m, alen: float64(h.AlignedLength)}
+		minM = min(minM, m)
+	}
+	for i := range hs {
+		hs[i].weight = math.Exp(-p.lambda * (hs[i].weight - minM))
+	}
+	p.multiReads = append(p.multiReads, hs)
+}
+
+// ProfileResult is the abundance estimation result, indexed by genome indexes.
+type ProfileResult struct {
+	Reads       []float64 // estimated numbers of reads from genomes
+	UniqueReads []int     // numbers of reads only mapped to genomes
+	Abundance   []float64 // relative abundance (percentage), i.e., reads normalized by genome sizes
+	Coverage    []float64 // estimated sequencing depth
+
+	TotalReads int // total number of reads
+	Iterations int // number of EM iterations
+	Converged  bool
+}
+
+// Estimate estimates abundances of genomes with EM.
+// A read from genome g aligned with weight w has a likelihood of w/L_g,
+// where L_g is the genome size. In each iteration, multi-mapping reads are
+// reassigned to genomes in proportion to the read fraction of genomes times the likelihood,
+// and read fractions are updated with the reassigned reads.
+func (p *Profiler) Estimate() *ProfileResult {
+	n := len(p.genomeSizes)
+	var total int
+	for _, c := range p.uniqueReads {
+		total += c
+	}
+	total += len(p.multiReads)
+
+	res := &ProfileResult{
+		Reads:       make([]float64, n),
+		UniqueReads: p.uniqueReads,
+		Abundance:   make([]float64, n),
+		Coverage:    make([]float64, n),
+		TotalReads:  total,
+	}
+	if total == 0 {
+		return res
+	}
+
+	// initial read fractions, from unique reads plus a pseudo count for genomes with multi-mapping reads
+	frac := make([]float64, n)
+	for _, hs := range p.multiReads {
+		for _, h := range hs {
+			frac[h.genome] = 1
+		}
+	}
+	var sum float64
+	for g, c := range p.uniqueReads {
+		frac[g] += float64(c)
+		sum += frac[g]
+	}
+	for g := range frac {
+		frac[g] /= sum
+	}
+
+	reads := res.Reads
+	var d, z, delta float64
+	for res.Iterations < p.opt.MaxIterations {
+		res.Iterations++
+
+		// E-step
+		for g, c := range p.uniqueReads {
+			reads[g] = float64(c)
+		}
+		for _, hs := range p.multiReads {
+			d = 0
+			for _, h := range hs {
+				d += frac[h.genome] * h.weight / p.genomeSizes[h.genome]
+			}
+			if d == 0 {
+				continue
+			}
+			for _, h := range hs {
+				reads[h.genome] += frac[h.genome] * h.weight / p.genomeSizes[h.genome] / d
+			}
+		}
+
+		// M-step
+		delta = 0
+		for g := range frac {
+			z = reads[g] / float64(total)
+			delta = max(delta, math.Abs(z-frac[g]))
+			frac[g] = z
+		}
+		if delta < p.opt.Tolerance {
+			res.Converged = true
+			break
+		}
+	}
+
+	// final assignments with the estimated read fractions
+	for g, b := range p.uniqueBases {
+		res.Coverage[g] = b
+	}
+	for _, hs := range p.multiReads {
+		d = 0
+		for _, h := range hs {
+			d += frac[h.genome] * h.weight / p.genomeSizes[h.genome]
+		}
+		if d == 0 {
+			continue
+		}
+		for _, h := range hs {
+			res.Coverage[h.genome] += frac[h.genome] * h.weight / p.genomeSizes[h.genome] / d * h.alen
+		}
+	}
+
+	sum = 0
+	for g, r := range reads {
+		res.Coverage[g] /= p.genomeSizes[g]
+		res.Abundance[g] = r / p.genomeSizes[g]
+		sum += res.Abundance[g]
+	}
+	if sum > 0 {
+		for g := range res.Abundance {
+			res.Abundance[g] = res.Abundance[g] / sum * 100
+		}
+	}
+	return res
+}
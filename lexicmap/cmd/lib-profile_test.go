@@ -0,0 +1,71 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"math"
+	"testing"
+)
+
+func TestProfiler(t *testing.T) {
+	// reads are normalized by genome sizes
+	p := NewProfiler([]int{1000, 2000}, &DefaultProfileOptions)
+	for i := 0; i < 10; i++ {
+		p.AddRead([]ReadHit{{Genome: 0, PIdent: 100, AlignedLength: 100}})
+	}
+	for i := 0; i < 20; i++ {
+		p.AddRead([]ReadHit{{Genome: 1, PIdent: 100, AlignedLength: 100}})
+	}
+	res := p.Estimate()
+	if math.Abs(res.Abundance[0]-50) > 1e-6 || math.Abs(res.Abundance[1]-50) > 1e-6 {
+		t.Errorf("unexpected abundances: %v, expected: [50 50]", res.Abundance)
+	}
+	if math.Abs(res.Coverage[0]-1) > 1e-6 || math.Abs(res.Coverage[1]-1) > 1e-6 {
+		t.Errorf("unexpected coverages: %v, expected: [1 1]", res.Coverage)
+	}
+
+	// multi-mapping reads equally similar to the two genomes go to the one with unique reads
+	p = NewProfiler([]int{1000, 1000}, &DefaultProfileOptions)
+	for i := 0; i < 100; i++ {
+		p.AddRead([]ReadHit{{Genome: 0, PIdent: 100, AlignedLength: 100}})
+		p.AddRead([]ReadHit{{Genome: 0, PIdent: 99, AlignedLength: 100}, {Genome: 1, PIdent: 99, AlignedLength: 100}})
+	}
+	res = p.Estimate()
+	if !res.Converged {
+		t.Errorf("EM not converged in %d iterations", res.Iterations)
+	}
+	if res.TotalReads != 200 || math.Abs(res.Reads[0]+res.Reads[1]-200) > 1e-6 {
+		t.Errorf("unexpected total reads: %d, %v", res.TotalReads, res.Reads)
+	}
+	if res.Reads[0] < 199 || res.UniqueReads[0] != 100 {
+		t.Errorf("multi-mapping reads should be assigned to the first genome: %v", res.Reads)
+	}
+
+	// reads with higher identities are preferred
+	p = NewProfiler([]int{1000, 1000}, &DefaultProfileOptions)
+	for i := 0; i < 100; i++ {
+		p.AddRead([]ReadHit{{Genome: 0, PIdent: 100, AlignedLength: 100}, {Genome: 1, PIdent: 97, AlignedLength: 100}})
+	}
+	res = p.Estimate()
+	if res.Reads[0] < 99 {
+		t.Errorf("reads should be assigned to the genome with higher identities: %v", res.Reads)
+	}
+}
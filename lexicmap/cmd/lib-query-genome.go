@@ -0,0 +1,109 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// queryGenomeHit summarizes alignments of all sequences of a query genome in a subject genome.
+type queryGenomeHit struct {
+	Genome       []byte
+	Seqs         int // number of query sequences aligned to the genome
+	AlignedBases int // aligned bases of all query sequences
+
+	pidents float64 // sum of pident*alenHSP
+	alen    int     // sum of alenHSP
+}
+
+// QueryGenomeSummary summarizes search results of sequences of a query genome at the genome level.
+type QueryGenomeSummary struct {
+	ID      string // query genome ID
+	Size    int    // query genome size
+	NumSeqs int    // number of query sequences
+
+	hits map[string]*queryGenomeHit
+}
+
+// NewQueryGenomeSummary creates a QueryGenomeSummary.
+func NewQueryGenomeSummary(id string, size int, numSeqs int) *QueryGenomeSummary {
+	return &QueryGenomeSummary{ID: id, Size: size, NumSeqs: numSeqs,
+		hits: make(map[string]*queryGenomeHit, 128)}
+}
+
+// Add adds search results of a query sequence, rs could be nil.
+func (s *QueryGenomeSummary) Add(qlen int, rs *[]*SearchResult) {
+	if rs == nil {
+		return
+	}
+
+	var h *queryGenomeHit
+	var ok bool
+	for _, r := range *rs {
+		if h, ok = s.hits[string(r.ID)]; !ok {
+			h = &queryGenomeHit{Genome: []byte(string(r.ID))}
+			s.hits[string(r.ID)] = h
+		}
+		h.Seqs++
+		h.AlignedBases += int(r.AlignedFraction*float64(qlen)/100 + 0.5)
+
+		if r.SimilarityDetails == nil { // --genome-hits-only
+			continue
+		}
+		for _, sd := range *r.SimilarityDetails {
+			for _, c := range *sd.Similarity.Chains {
+				if c == nil {
+					continue
+				}
+				h.pidents += c.PIdent * float64(c.AlignedLength)
+				h.alen += c.AlignedLength
+			}
+		}
+	}
+}
+
+// Write outputs the summary with a header line, genomes are sorted by the query coverage.
+func (s *QueryGenomeSummary) Write(w io.Writer) {
+	hits := make([]*queryGenomeHit, 0, len(s.hits))
+	for _, h := range s.hits {
+		hits = append(hits, h)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].AlignedBases == hits[j].AlignedBases {
+			return string(hits[i].Genome) < string(hits[j].Genome)
+		}
+		return hits[i].AlignedBases > hits[j].AlignedBases
+	})
+
+	fmt.Fprintln(w, "query\tqsize\tqseqs\tsgenome\tqseqsAligned\tqcovGnm\tpident")
+	var pident float64
+	for _, h := range hits {
+		pident = 0
+		if h.alen > 0 {
+			pident = h.pidents / float64(h.alen) // weighted by aligned lengths of HSPs
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%.3f\t%.3f\n",
+			s.ID, s.Size, s.NumSeqs, h.Genome, h.Seqs,
+			min(100, float64(h.AlignedBases)/float64(s.Size)*100), pident)
+	}
+}
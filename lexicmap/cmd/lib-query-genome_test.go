@@ -0,0 +1,171 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildTestGenomesIndex builds an index of 3 genomes in dir: g1, and its variants g2 and g3
+// with 5 and 10 mutations, respectively. The sequence of g1 is returned.
+func buildTestGenomesIndex(t *testing.T, dir string) (string, []byte) {
+	r := rand.New(rand.NewSource(1))
+	seq := make([]byte, 5000)
+	for i := range seq {
+		seq[i] = "ACGT"[r.Intn(4)]
+	}
+
+	ids := []string{"g1", "g2", "g3"}
+	files := make([]string, 0, len(ids))
+	for i, id := range ids {
+		s := []byte(string(seq))
+		for j := 0; j < i*5; j++ {
+			p := 100 + j*450
+			s[p] = map[byte]byte{'A': 'C', 'C': 'G', 'G': 'T', 'T': 'A'}[s[p]]
+		}
+		file := filepath.Join(dir, id+".fa")
+		if err := os.WriteFile(file, []byte(">"+id+"_chr\n"+string(s)+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+
+	return buildTestIndex(t, dir, files, 15000000), seq
+}
+
+// buildTestChunkedGenomesIndex builds an index of 2 genomes in dir: g1 with two contigs of 5000 bp,
+// which is split into two chunks with a maximum genome size of 6000 bp, and g2 with only the
+// first contig of g1. The contigs of g1 are returned.
+func buildTestChunkedGenomesIndex(t *testing.T, dir string) (string, [][]byte) {
+	r := rand.New(rand.NewSource(2))
+	contigs := make([][]byte, 2)
+	for i := range contigs {
+		contigs[i] = make([]byte, 5000)
+		for j := range contigs[i] {
+			contigs[i][j] = "ACGT"[r.Intn(4)]
+		}
+	}
+
+	files := []string{filepath.Join(dir, "g1.fa"), filepath.Join(dir, "g2.fa")}
+	data := []string{
+		">g1_c1\n" + string(contigs[0]) + "\n>g1_c2\n" + string(contigs[1]) + "\n",
+		">g2_c1\n" + string(contigs[0]) + "\n",
+	}
+	for i, file := range files {
+		if err := os.WriteFile(file, []byte(data[i]), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return buildTestIndex(t, dir, files, 6000), contigs
+}
+
+// buildTestIndex builds an index of the sequence files in dir, with small parameters for tests.
+func buildTestIndex(t *testing.T, dir string, files []string, maxGenomeSize int) string {
+	dbDir := filepath.Join(dir, "idx")
+	err := BuildIndex(dbDir, files, &IndexBuildingOptions{
+		NumCPUs:                1,
+		MaxOpenFiles:           512,
+		MergeThreads:           1,
+		MinSeqLen:              -1,
+		MaxGenomeSize:          maxGenomeSize,
+		K:                      31,
+		Masks:                  1000,
+		RandSeed:               1,
+		DesertMaxLen:           200,
+		DesertExpectedSeedDist: 50,
+		DesertSeedPosRange:     25,
+		Chunks:                 2,
+		Partitions:             4,
+		GenomeBatchSize:        5000,
+		ContigInterval:         1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dbDir
+}
+
+func TestExcludedGenomes(t *testing.T) {
+	// g1 is the query genome, g2 and g3 are its variants with a few mutations.
+	dbDir, seq := buildTestGenomesIndex(t, t.TempDir())
+
+	src, err := newGenomeSourceIndex(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self, err := src.GenomeChunks("g1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = src.GenomeChunks("g4"); err == nil {
+		t.Errorf("an error expected for a genome not in the index")
+	}
+	src.Close()
+
+	search := func(topN int, excluded map[uint64]interface{}) []string {
+		opt := DefaultIndexSearchingOptions
+		opt.NumCPUs = 1
+		opt.TopN = topN
+		opt.ExcludedGenomes = excluded
+		idx, err := NewIndexSearcher(dbDir, &opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer idx.Close()
+		sco := DefaultSeqComparatorOptions
+		idx.SetSeqCompareOptions(&sco)
+
+		rs, err := idx.Search(seq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rs == nil {
+			return nil
+		}
+		hits := make([]string, 0, len(*rs))
+		for _, r := range *rs {
+			hits = append(hits, string(r.ID))
+		}
+		idx.RecycleSearchResults(rs)
+		return hits
+	}
+
+	if hits := search(1, nil); len(hits) != 1 || hits[0] != "g1" {
+		t.Errorf("the query genome itself expected: %v", hits)
+	}
+
+	// the query genome is removed before keeping the top N genomes
+	for _, topN := range []int{1, 2} {
+		hits := search(topN, self)
+		if len(hits) != topN {
+			t.Errorf("%d hits expected with -n %d: %v", topN, topN, hits)
+		}
+		for _, h := range hits {
+			if h == "g1" {
+				t.Errorf("the query genome is not excluded with -n %d: %v", topN, hits)
+			}
+		}
+	}
+}
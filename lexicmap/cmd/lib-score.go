@@ -0,0 +1,152 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/shenwei356/wfa"
+)
+
+// ScoringOptions is the scoring scheme for computing raw scores of alignments,
+// which are converted to bit scores and E-values with Karlin-Altschul parameters.
+type ScoringOptions struct {
+	Match    int // reward of a match, positive
+	Mismatch int // penalty of a mismatch, positive
+	GapOpen  int // cost of opening a gap, a gap of length L costs GapOpen + GapExt * L
+	GapExt   int // cost of extending a gap
+
+	Lambda float64 // Karlin-Altschul parameter λ
+	K      float64 // Karlin-Altschul parameter K
+}
+
+// DefaultScoringOptions is the same as the default of blastn.
+var DefaultScoringOptions = ScoringOptions{
+	Match:    2,
+	Mismatch: 3,
+	GapOpen:  5,
+	GapExt:   2,
+	Lambda:   0.625,
+	K:        0.41,
+}
+
+// karlinAltschulParams are gapped Karlin-Altschul parameters for some scoring schemes
+// (match, mismatch, gap open, gap extension), with uniform base frequencies.
+// Values are from NCBI BLAST.
+var karlinAltschulParams = map[[4]int][2]float64{
+	{2, 3, 5, 2}: {0.625, 0.41}, // blastn
+	{1, 3, 5, 2}: {1.37, 0.711}, // blastn-short
+}
+
+// KarlinAltschulParams returns the Karlin-Altschul parameters (λ, K) of a supported scoring scheme.
+func KarlinAltschulParams(match, mismatch, gapOpen, gapExt int) (float64, float64, error) {
+	v, ok := karlinAltschulParams[[4]int{match, mismatch, gapOpen, gapExt}]
+	if !ok {
+		return 0, 0, fmt.Errorf("Karlin-Altschul parameters are unknown for the scoring scheme (match: %d, mismatch: %d, gap open: %d, gap extension: %d), please specify them",
+			match, mismatch, gapOpen, gapExt)
+	}
+	return v[0], v[1], nil
+}
+
+// CheckScoringOptions checks the scoring options.
+func CheckScoringOptions(opt *ScoringOptions) error {
+	if opt.Match <= 0 {
+		return fmt.Errorf("invalid match score: %d, should be > 0", opt.Match)
+	}
+	if opt.Mismatch <= 0 {
+		return fmt.Errorf("invalid mismatch penalty: %d, should be > 0", opt.Mismatch)
+	}
+	if opt.GapOpen < 0 {
+		return fmt.Errorf("invalid gap open penalty: %d, should be >= 0", opt.GapOpen)
+	}
+	if opt.GapExt <= 0 {
+		return fmt.Errorf("invalid gap extension penalty: %d, should be > 0", opt.GapExt)
+	}
+	if opt.Lambda <= 0 {
+		return fmt.Errorf("invalid Karlin-Altschul parameter lambda: %f, should be > 0", opt.Lambda)
+	}
+	if opt.K <= 0 {
+		return fmt.Errorf("invalid Karlin-Altschul parameter K: %f, should be > 0", opt.K)
+	}
+	return nil
+}
+
+// RawScore computes the raw score from CIGAR records of WFA.
+func (opt *ScoringOptions) RawScore(ops []*wfa.CIGARRecord) int {
+	var score int
+	for _, op := range ops {
+		switch op.Op {
+		case 'M':
+			score += opt.Match * int(op.N)
+		case 'X':
+			score -= opt.Mismatch * int(op.N)
+		case 'I', 'D':
+			score -= opt.GapOpen + opt.GapExt*int(op.N)
+		}
+	}
+	return score
+}
+
+// RawScoreUngapped computes the raw score from the numbers of matches and mismatches,
+// it's used when there's no CIGAR, e.g., in the pseudo-alignment mode.
+func (opt *ScoringOptions) RawScoreUngapped(matches, mismatches int) int {
+	return opt.Match*matches - opt.Mismatch*mismatches
+}
+
+// BitScore converts a raw score to a bit score.
+func (opt *ScoringOptions) BitScore(score int) float64 {
+	return (opt.Lambda*float64(score) - math.Log(opt.K)) / math.Ln2
+}
+
+// Evalue computes the E-value of a bit score, with the search space
+// of the query length and the database size.
+// Note that there's no length adjustment like BLAST.
+func (opt *ScoringOptions) Evalue(bitScore float64, qlen int, dbSize int64) float64 {
+	return float64(qlen) * float64(dbSize) * math.Exp2(-bitScore)
+}
+
+// scoreChain computes the raw score, bit score, and E-value of an alignment.
+// ops is the CIGAR of the alignment, and it's nil in the pseudo-alignment mode.
+func (idx *Index) scoreChain(c *Chain2Result, ops []*wfa.CIGARRecord, qlen int) {
+	sco := &idx.opt.Scoring
+	if ops != nil {
+		c.Score = sco.RawScore(ops)
+	} else {
+		c.Score = sco.RawScoreUngapped(c.MatchedBases, c.AlignedLength-c.MatchedBases)
+	}
+	c.BitScore = sco.BitScore(c.Score)
+	c.Evalue = sco.Evalue(c.BitScore, qlen, idx.getTotalBases())
+}
+
+// sortChainsByBitScore sorts HSPs in descending order of bit scores, with nil ones at the end.
+func sortChainsByBitScore(chains []*Chain2Result) {
+	sort.SliceStable(chains, func(i, j int) bool {
+		if chains[i] == nil {
+			return false
+		}
+		if chains[j] == nil {
+			return true
+		}
+		return chains[i].BitScore > chains[j].BitScore
+	})
+}
@@ -0,0 +1,80 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"math"
+	"testing"
+
+	"github.com/shenwei356/wfa"
+)
+
+func TestScoring(t *testing.T) {
+	sco := DefaultScoringOptions
+
+	// 100M 2X 3I 50M 1D 10M
+	ops := []*wfa.CIGARRecord{
+		{N: 100, Op: 'M'},
+		{N: 2, Op: 'X'},
+		{N: 3, Op: 'I'},
+		{N: 50, Op: 'M'},
+		{N: 1, Op: 'D'},
+		{N: 10, Op: 'M'},
+	}
+	score := sco.RawScore(ops)
+	expected := 160*2 - 2*3 - (5 + 2*3) - (5 + 2*1)
+	if score != expected {
+		t.Errorf("raw score: expected %d, returned %d", expected, score)
+	}
+
+	bits := sco.BitScore(score)
+	if math.Abs(bits-(0.625*float64(score)-math.Log(0.41))/math.Ln2) > 1e-9 {
+		t.Errorf("unexpected bit score: %f", bits)
+	}
+
+	// E-value increases with the search space
+	e1 := sco.Evalue(bits, 1000, 1e6)
+	e2 := sco.Evalue(bits, 1000, 1e9)
+	if math.Abs(e2/e1-1000) > 1e-6 {
+		t.Errorf("unexpected E-values: %g, %g", e1, e2)
+	}
+}
+
+func TestSortChainsByBitScore(t *testing.T) {
+	chains := []*Chain2Result{
+		{QBegin: 0, BitScore: 50},
+		nil,
+		{QBegin: 1, BitScore: 200},
+		{QBegin: 2, BitScore: 100},
+		{QBegin: 3, BitScore: 200},
+	}
+	sortChainsByBitScore(chains)
+
+	expected := []int{1, 3, 2, 0} // QBegin of HSPs, stable for ties
+	for i, q := range expected {
+		if chains[i] == nil || chains[i].QBegin != q {
+			t.Fatalf("unexpected order of HSPs at %d, expected QBegin: %d", i, q)
+		}
+	}
+	if chains[4] != nil {
+		t.Errorf("nil HSPs should be at the end")
+	}
+}
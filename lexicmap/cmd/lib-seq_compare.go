@@ -21,12 +21,14 @@
 package cmd
 
 import (
+	"fmt"
 	"math"
 	"sort"
 	"sync"
 
 	rtree "github.com/shenwei356/LexicMap/lexicmap/cmd/tree"
 	"github.com/shenwei356/lexichash/iterator"
+	"github.com/shenwei356/wfa"
 )
 
 // SeqComparatorOptions contains options for comparing two sequences.
@@ -42,6 +44,41 @@ type SeqComparatorOptions struct {
 	MinAlignedFraction float64 // minimum query aligned fraction in a HSP
 
 	MinIdentity float64
+
+	// WFA alignment
+	WFAPenalties      wfa.Penalties               // penalties of mismatch, gap opening, and gap extension
+	WFAAdaptive       bool                        // use the adaptive reduction heuristic
+	WFAAdaptiveOption wfa.AdaptiveReductionOption // parameters of the adaptive reduction heuristic
+}
+
+// newWFAAligner creates a WFA aligner with the penalties and the adaptive reduction heuristic.
+func (opt *SeqComparatorOptions) newWFAAligner(alignOption *wfa.Options) *wfa.Aligner {
+	algn := wfa.New(&opt.WFAPenalties, alignOption)
+	if opt.WFAAdaptive {
+		algn.AdaptiveReduction(&opt.WFAAdaptiveOption)
+	}
+	return algn
+}
+
+// CheckSeqComparatorOptions checks the options of WFA alignment.
+func CheckSeqComparatorOptions(opt *SeqComparatorOptions) error {
+	if opt.WFAPenalties.Mismatch == 0 {
+		return fmt.Errorf("invalid WFA mismatch penalty: %d, should be > 0", opt.WFAPenalties.Mismatch)
+	}
+	if opt.WFAPenalties.GapExt == 0 {
+		return fmt.Errorf("invalid WFA gap extension penalty: %d, should be > 0", opt.WFAPenalties.GapExt)
+	}
+	if opt.WFAAdaptive {
+		if opt.WFAAdaptiveOption.MinWFLen == 0 {
+			return fmt.Errorf("invalid minimum wavefront length of WFA adaptive reduction: %d, should be > 0",
+				opt.WFAAdaptiveOption.MinWFLen)
+		}
+		if opt.WFAAdaptiveOption.MaxDistDiff == 0 {
+			return fmt.Errorf("invalid maximum distance difference of WFA adaptive reduction: %d, should be > 0",
+				opt.WFAAdaptiveOption.MaxDistDiff)
+		}
+	}
+	return nil
 }
 
 // DefaultSeqComparatorOptions contains the default options for SeqComparatorOptions.
@@ -61,6 +98,10 @@ var DefaultSeqComparatorOptions = SeqComparatorOptions{
 	},
 
 	MinAlignedFraction: 0,
+
+	WFAPenalties:      *wfa.DefaultPenalties,
+	WFAAdaptive:       true,
+	WFAAdaptiveOption: *wfa.DefaultAdaptiveOption,
 }
 
 // SeqComparator is for fast and accurate similarity estimation of two sequences,
@@ -21,8 +21,11 @@
 package cmd
 
 import (
+	"strings"
 	"sync"
 	"testing"
+
+	"github.com/shenwei356/wfa"
 )
 
 func TestSeqCompare(t *testing.T) {
@@ -92,3 +95,60 @@ func TestSeqCompare(t *testing.T) {
 }
 
 //
+
+func TestCheckSeqComparatorOptions(t *testing.T) {
+	opt := DefaultSeqComparatorOptions
+	if err := CheckSeqComparatorOptions(&opt); err != nil {
+		t.Errorf("unexpected error of the default options: %s", err)
+	}
+
+	for msg, f := range map[string]func(o *SeqComparatorOptions){
+		"mismatch penalty":      func(o *SeqComparatorOptions) { o.WFAPenalties.Mismatch = 0 },
+		"gap extension penalty": func(o *SeqComparatorOptions) { o.WFAPenalties.GapExt = 0 },
+		"minimum wavefront":     func(o *SeqComparatorOptions) { o.WFAAdaptiveOption.MinWFLen = 0 },
+		"maximum distance":      func(o *SeqComparatorOptions) { o.WFAAdaptiveOption.MaxDistDiff = 0 },
+	} {
+		opt = DefaultSeqComparatorOptions
+		f(&opt)
+		if err := CheckSeqComparatorOptions(&opt); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("error with %q expected, returned: %v", msg, err)
+		}
+	}
+
+	// options of the adaptive reduction are not checked if it's off
+	opt = DefaultSeqComparatorOptions
+	opt.WFAAdaptive = false
+	opt.WFAAdaptiveOption.MaxDistDiff = 0
+	if err := CheckSeqComparatorOptions(&opt); err != nil {
+		t.Errorf("unexpected error with the adaptive reduction off: %s", err)
+	}
+
+	// a gap opening penalty of 0 is allowed
+	opt = DefaultSeqComparatorOptions
+	opt.WFAPenalties.GapOpen = 0
+	if err := CheckSeqComparatorOptions(&opt); err != nil {
+		t.Errorf("unexpected error with a gap opening penalty of 0: %s", err)
+	}
+}
+
+func TestWFAPenalties(t *testing.T) {
+	// a mismatch and a 2-bp deletion in the query
+	s := []byte("ACGTTGCAAGCTTGACCATGGATCCGTAGCTAGGCTAACGTTAGC")
+	q := []byte("ACGTTGCAAGCTTGACCATGCATCCGTAGCTAGGCTAACGTTAGC")
+	q = append(q[:30:30], q[32:]...)
+
+	for _, p := range []wfa.Penalties{{Mismatch: 4, GapOpen: 6, GapExt: 2}, {Mismatch: 3, GapOpen: 5, GapExt: 1}} {
+		opt := DefaultSeqComparatorOptions
+		opt.WFAPenalties = p
+		algn := opt.newWFAAligner(&wfa.Options{GlobalAlignment: true})
+		cigar, err := algn.Align(q, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := p.Mismatch + p.GapOpen + 2*p.GapExt
+		if cigar.Score != expected {
+			t.Errorf("unexpected alignment score with penalties %v: %d, expected: %d", p, cigar.Score, expected)
+		}
+		wfa.RecycleAlignmentResult(cigar)
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+// Variant is a variant of a sequence relative to a reference sequence, in VCF style.
+type Variant struct {
+	Pos int    // 1-based position in the reference sequence
+	Ref string // reference allele
+	Alt string // alternative allele
+}
+
+// LeftAlignGaps shifts gaps in a pairwise alignment to the leftmost equivalent positions,
+// i.e., indels are left-normalized. ref and alt are aligned sequences with gaps ('-')
+// of the same length, which are modified in place. A gap is only shifted over matched
+// bases, so no new mismatches are introduced.
+func LeftAlignGaps(ref, alt []byte) {
+	n := len(ref)
+	if len(alt) < n {
+		n = len(alt)
+	}
+
+	var g, o []byte // the sequence with the gap, and the other one
+	var a, b, j int
+	for i := 0; i < n; {
+		if ref[i] == '-' {
+			g, o = ref, alt
+		} else if alt[i] == '-' {
+			g, o = alt, ref
+		} else {
+			i++
+			continue
+		}
+
+		// the gap run: [a, b)
+		a, b = i, i+1
+		for b < n && g[b] == '-' {
+			b++
+		}
+		j = b
+
+		for a > 0 && g[a-1] != '-' && g[a-1] == o[a-1] && o[a-1] == o[b-1] {
+			g[b-1] = g[a-1]
+			g[a-1] = '-'
+			a--
+			b--
+		}
+
+		i = j
+	}
+}
+
+// CallVariants calls SNPs and indels from a pairwise alignment, where ref and alt are aligned
+// sequences with gaps ('-'), and start is the 1-based position of the first base of ref.
+// Gaps should be left-aligned with LeftAlignGaps in advance. Like VCF, an indel contains
+// the preceding base, and indels at the beginning of the alignment are skipped.
+// Adjacent indels, and an indel following a mismatch, are merged into a complex variant.
+func CallVariants(ref, alt []byte, start int, variants *[]Variant) {
+	n := len(ref)
+	if len(alt) < n {
+		n = len(alt)
+	}
+
+	pos := start // position of the next base in ref
+	var j, k int
+	var r, a []byte
+	var mismatchedAnchor bool
+	for i := 0; i < n; {
+		if ref[i] != '-' && alt[i] != '-' {
+			if ref[i] != alt[i] {
+				*variants = append(*variants, Variant{Pos: pos, Ref: string(ref[i]), Alt: string(alt[i])})
+			}
+			pos++
+			i++
+			continue
+		}
+
+		// the gap event: [i, j)
+		j = i + 1
+		for j < n && (ref[j] == '-' || alt[j] == '-') {
+			j++
+		}
+
+		if i == 0 { // no anchor base
+			for k = i; k < j; k++ {
+				if ref[k] != '-' {
+					pos++
+				}
+			}
+			i = j
+			continue
+		}
+
+		r = append(r[:0], ref[i-1])
+		a = append(a[:0], alt[i-1])
+		for k = i; k < j; k++ {
+			if ref[k] != '-' {
+				r = append(r, ref[k])
+				pos++
+			}
+			if alt[k] != '-' {
+				a = append(a, alt[k])
+			}
+		}
+
+		mismatchedAnchor = ref[i-1] != alt[i-1]
+		if mismatchedAnchor { // replace the SNP of the anchor base
+			*variants = (*variants)[:len(*variants)-1]
+		}
+		if string(r) != string(a) {
+			*variants = append(*variants, Variant{Pos: pos - len(r), Ref: string(r), Alt: string(a)})
+		}
+
+		i = j
+	}
+}
+
+// NormalizeAlleles normalizes variants at the same position to share a reference allele,
+// i.e., the longest one, and returns it. Reference alleles at the same position are prefixes
+// of the longest one, so alternative alleles are extended with the remaining bases in place.
+// E.g., a SNP A>G and a deletion ACT>A are normalized into ACT>GCT and ACT>A.
+func NormalizeAlleles(variants []Variant) string {
+	var ref string
+	for _, v := range variants {
+		if len(v.Ref) > len(ref) {
+			ref = v.Ref
+		}
+	}
+	for i, v := range variants {
+		if len(v.Ref) < len(ref) {
+			variants[i].Alt = v.Alt + ref[len(v.Ref):]
+			variants[i].Ref = ref
+		}
+	}
+	return ref
+}
+
+// reverseComplementAligned reverse complements an aligned sequence with gaps in place.
+func reverseComplementAligned(s []byte) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = complementBase(s[j]), complementBase(s[i])
+	}
+	if len(s)&1 == 1 {
+		s[len(s)>>1] = complementBase(s[len(s)>>1])
+	}
+}
+
+func complementBase(b byte) byte {
+	switch b {
+	case 'A':
+		return 'T'
+	case 'C':
+		return 'G'
+	case 'G':
+		return 'C'
+	case 'T':
+		return 'A'
+	case 'a':
+		return 't'
+	case 'c':
+		return 'g'
+	case 'g':
+		return 'c'
+	case 't':
+		return 'a'
+	}
+	return b
+}
@@ -0,0 +1,186 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"testing"
+)
+
+func TestCallVariants(t *testing.T) {
+	type testCase struct {
+		ref, alt string
+		aligned  [2]string // after left alignment
+		variants []Variant
+	}
+	cases := []testCase{
+		{ // a SNP
+			"ACGTACGT", "ACGAACGT",
+			[2]string{"ACGTACGT", "ACGAACGT"},
+			[]Variant{{4, "T", "A"}},
+		},
+		{ // a deletion in a homopolymer is shifted to the left
+			"ACTTTTGA", "ACTTT-GA",
+			[2]string{"ACTTTTGA", "AC-TTTGA"},
+			[]Variant{{2, "CT", "C"}},
+		},
+		{ // an insertion of a repeat unit is shifted to the left
+			"ACAG--T", "ACAGAGT",
+			[2]string{"AC--AGT", "ACAGAGT"},
+			[]Variant{{2, "C", "CAG"}},
+		},
+		{ // a deletion following a SNP is merged
+			"ACGTACGT", "ACGA--GT",
+			[2]string{"ACGTACGT", "ACGA--GT"},
+			[]Variant{{4, "TAC", "A"}},
+		},
+		{ // indels at the beginning are skipped
+			"--ACGTAC", "GGACGTAC",
+			[2]string{"--ACGTAC", "GGACGTAC"},
+			nil,
+		},
+	}
+
+	var variants []Variant
+	for _, c := range cases {
+		ref, alt := []byte(c.ref), []byte(c.alt)
+		LeftAlignGaps(ref, alt)
+		if string(ref) != c.aligned[0] || string(alt) != c.aligned[1] {
+			t.Errorf("unexpected left alignment of %s/%s: %s/%s, expected: %s/%s",
+				c.ref, c.alt, ref, alt, c.aligned[0], c.aligned[1])
+		}
+
+		variants = variants[:0]
+		CallVariants(ref, alt, 1, &variants)
+		if len(variants) != len(c.variants) {
+			t.Errorf("unexpected variants of %s/%s: %v, expected: %v", c.ref, c.alt, variants, c.variants)
+			continue
+		}
+		for i, v := range variants {
+			if v != c.variants[i] {
+				t.Errorf("unexpected variants of %s/%s: %v, expected: %v", c.ref, c.alt, variants, c.variants)
+				break
+			}
+		}
+	}
+}
+
+func TestWrappedVariants(t *testing.T) {
+	const length = 5000
+	// an alignment across the origin, and another one at the beginning of the sequence
+	wrapped := alignedRegion{genome: 0, start: 4990, end: 5100}
+	normal := alignedRegion{genome: 1, start: 1, end: 200}
+
+	// the same SNP called from the two alignments
+	var vs1, vs2 []Variant
+	CallVariants([]byte("ACGTACGTAC"), []byte("ACGTACGAAC"), 5001, &vs1)
+	CallVariants([]byte("ACGTACGTAC"), []byte("ACGTACGAAC"), 1, &vs2)
+	if len(vs1) != 1 || len(vs2) != 1 {
+		t.Fatalf("unexpected variants: %v, %v", vs1, vs2)
+	}
+	k1 := variantKey{pos: unwrapPosition(vs1[0].Pos, length), ref: vs1[0].Ref, alt: vs1[0].Alt}
+	k2 := variantKey{pos: unwrapPosition(vs2[0].Pos, length), ref: vs2[0].Ref, alt: vs2[0].Alt}
+	if k1 != k2 || k1.pos != 8 {
+		t.Errorf("variants in wrapped and unwrapped alignments should be the same: %v, %v", k1, k2)
+	}
+
+	for _, c := range []struct {
+		r       alignedRegion
+		pos     int
+		covered bool
+	}{
+		{wrapped, 4995, true},
+		{wrapped, 8, true},
+		{wrapped, 100, true},
+		{wrapped, 101, false},
+		{wrapped, 4989, false},
+		{normal, 8, true},
+		{normal, 4995, false},
+	} {
+		cov := newSiteCoverage([]alignedRegion{c.r}, length, 2)
+		if cov.At(c.pos) == 1 != c.covered || cov.Covered(c.r.genome) != c.covered {
+			t.Errorf("region %d-%d, position %d: expected covered=%v", c.r.start, c.r.end, c.pos, c.covered)
+		}
+	}
+
+	if unwrapPosition(10, 0) != 10 || unwrapPosition(5000, length) != 5000 || unwrapPosition(5001, length) != 1 {
+		t.Errorf("unexpected unwrapped positions")
+	}
+}
+
+func TestSiteCoverage(t *testing.T) {
+	const length = 1000
+	regions := []alignedRegion{
+		{genome: 0, start: 101, end: 200},
+		{genome: 0, start: 151, end: 300}, // overlapping regions of the same genome
+		{genome: 1, start: 181, end: 250},
+		{genome: 2, start: 901, end: 1050}, // across the origin
+	}
+	cov := newSiteCoverage(regions, length, 3)
+
+	for _, c := range []struct {
+		pos     int
+		an      int
+		covered [3]bool
+	}{
+		{10, 1, [3]bool{false, false, true}},
+		{50, 1, [3]bool{false, false, true}},
+		{51, 0, [3]bool{false, false, false}},
+		{101, 1, [3]bool{true, false, false}},
+		{181, 2, [3]bool{true, true, false}},
+		{200, 2, [3]bool{true, true, false}},
+		{250, 2, [3]bool{true, true, false}},
+		{251, 1, [3]bool{true, false, false}},
+		{301, 0, [3]bool{false, false, false}},
+		{901, 1, [3]bool{false, false, true}},
+		{1000, 1, [3]bool{false, false, true}},
+	} {
+		if an := cov.At(c.pos); an != c.an {
+			t.Errorf("position %d: unexpected number of genomes: %d, expected: %d", c.pos, an, c.an)
+		}
+		for g, covered := range c.covered {
+			if cov.Covered(g) != covered {
+				t.Errorf("position %d, genome %d: expected covered=%v", c.pos, g, covered)
+			}
+		}
+	}
+}
+
+func TestNormalizeAlleles(t *testing.T) {
+	// a SNP, a deletion, and an insertion at the same position
+	vs := []Variant{{10, "A", "G"}, {10, "ACT", "A"}, {10, "A", "AGG"}}
+	ref := NormalizeAlleles(vs)
+	if ref != "ACT" {
+		t.Errorf("unexpected reference allele: %s, expected: ACT", ref)
+	}
+	expected := []Variant{{10, "ACT", "GCT"}, {10, "ACT", "A"}, {10, "ACT", "AGGCT"}}
+	for i, v := range vs {
+		if v != expected[i] {
+			t.Errorf("unexpected normalized alleles: %v, expected: %v", vs, expected)
+			break
+		}
+	}
+
+	// nothing changes for alleles with the same reference allele
+	vs = []Variant{{10, "A", "C"}, {10, "A", "T"}}
+	if ref = NormalizeAlleles(vs); ref != "A" || vs[0].Alt != "C" || vs[1].Alt != "T" {
+		t.Errorf("unexpected normalized alleles: %s, %v", ref, vs)
+	}
+}
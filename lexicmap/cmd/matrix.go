@@ -0,0 +1,334 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/LexicMap/lexicmap/cmd/util"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+var matrixCmd = &cobra.Command{
+	Use:   "matrix",
+	Short: "Create a genome-by-query matrix from search results",
+	Long: `Create a genome-by-query matrix from search results
+
+Input:
+   - Output of 'lexicmap search', with a header line.
+   - Only HSPs passing the filters (-i/--min-pident and -q/--min-qcov-hsp) are used,
+     and a query is present in a genome if the genome query coverage >= -Q/--min-qcov-gnm,
+     which is recomputed from these HSPs rather than the column qcovGnm of all HSPs.
+
+Values of cells (-v/--value):
+   presence,  1 for presence and 0 for absence.
+   pident,    The best percentage of identity of HSPs, 0 for absence.
+   copies,    Copy number, i.e., the number of HSPs, 0 for absence.
+
+Output:
+   - Rows are genomes and columns are queries in the order of appearance.
+     With -d/--index, all genomes in the index are outputted in the order of the index,
+     including those with no hits.
+   - With -s/--sparse, only non-zero cells are outputted in three columns: genome, query, value,
+     which is suitable for millions of genomes.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		opt := getOptions(cmd)
+		outputLog := opt.Verbose || opt.Log2File
+
+		dbDir := getFlagString(cmd, "index")
+		outFile := getFlagString(cmd, "out-file")
+		sparse := getFlagBool(cmd, "sparse")
+
+		valueType := getFlagString(cmd, "value")
+		switch valueType {
+		case "presence", "pident", "copies":
+		default:
+			checkError(fmt.Errorf("invalid value of flag -v/--value: %s. available values: presence, pident, copies", valueType))
+		}
+
+		bufferSizeS := getFlagString(cmd, "buffer-size")
+		if bufferSizeS == "" {
+			checkError(fmt.Errorf("value of buffer size. supported unit: K, M, G"))
+		}
+		bufferSize, err := ParseByteSize(bufferSizeS)
+		if err != nil {
+			checkError(fmt.Errorf("invalid value of buffer size. supported unit: K, M, G"))
+		}
+
+		minPident := getFlagNonNegativeFloat64(cmd, "min-pident")
+		if minPident > 100 {
+			checkError(fmt.Errorf("the value of flag -i/--min-pident (%f) should be in range of [0, 100]", minPident))
+		}
+		minQcov := getFlagNonNegativeFloat64(cmd, "min-qcov-hsp")
+		if minQcov > 100 {
+			checkError(fmt.Errorf("the value of flag -q/--min-qcov-hsp (%f) should be in range of [0, 100]", minQcov))
+		}
+		minQcovGnm := getFlagNonNegativeFloat64(cmd, "min-qcov-gnm")
+		if minQcovGnm > 100 {
+			checkError(fmt.Errorf("the value of flag -Q/--min-qcov-gnm (%f) should be in range of [0, 100]", minQcovGnm))
+		}
+
+		// ---------------------------------------------------------------
+		// genomes
+
+		var genomes []string
+		genome2idx := make(map[string]int, 1024)
+		if dbDir != "" {
+			genomes, err = indexGenomeIDs(dbDir)
+			checkError(err)
+			for i, g := range genomes {
+				genome2idx[g] = i
+			}
+			if outputLog {
+				log.Infof("%d genomes in the index: %s", len(genomes), dbDir)
+			}
+		}
+
+		// ---------------------------------------------------------------
+		// search results
+
+		var queries []string
+		query2idx := make(map[string]int, 128)
+		cells := make([][]matrixCell, len(genomes)) // cells of each genome, sorted by queries
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		buf := make([]byte, bufferSize)
+		rp := newSearchResultParser(colQuery, colQlen, colSgenome, colQcovHSP, colPident, colQstart, colQend)
+		var queryLens []int
+		var g, q, qlen, qstart, qend int
+		var ok bool
+		var pident float64
+		var nHSPs int
+
+		for _, file := range files {
+			fh, err := xopen.Ropen(file)
+			checkError(err)
+
+			rp.Reset(file)
+			scanner := bufio.NewScanner(fh)
+			scanner.Buffer(buf, int(bufferSize))
+			for scanner.Scan() {
+				line := strings.TrimRight(scanner.Text(), "\r\n")
+				if line == "" {
+					continue
+				}
+				ok, err = rp.Parse(line)
+				checkError(err)
+				if !ok {
+					continue
+				}
+
+				pident, err = strconv.ParseFloat(rp.Field(colPident), 64)
+				if err != nil {
+					checkError(fmt.Errorf("invalid pident: %s", rp.Field(colPident)))
+				}
+				if pident < minPident {
+					continue
+				}
+				if minQcov > 0 {
+					if v, _ := strconv.ParseFloat(rp.Field(colQcovHSP), 64); v < minQcov {
+						continue
+					}
+				}
+				qstart, err = strconv.Atoi(rp.Field(colQstart))
+				if err != nil {
+					checkError(fmt.Errorf("invalid qstart: %s", rp.Field(colQstart)))
+				}
+				qend, err = strconv.Atoi(rp.Field(colQend))
+				if err != nil {
+					checkError(fmt.Errorf("invalid qend: %s", rp.Field(colQend)))
+				}
+
+				if q, ok = query2idx[rp.Field(colQuery)]; !ok {
+					qlen, err = strconv.Atoi(rp.Field(colQlen))
+					if err != nil {
+						checkError(fmt.Errorf("invalid qlen: %s", rp.Field(colQlen)))
+					}
+					q = len(queries)
+					query2idx[rp.Field(colQuery)] = q
+					queries = append(queries, rp.Field(colQuery))
+					queryLens = append(queryLens, qlen)
+				}
+				if g, ok = genome2idx[rp.Field(colSgenome)]; !ok {
+					if dbDir != "" {
+						log.Warningf("genome not found in the index: %s", rp.Field(colSgenome))
+					}
+					g = len(genomes)
+					genome2idx[rp.Field(colSgenome)] = g
+					genomes = append(genomes, rp.Field(colSgenome))
+					cells = append(cells, nil)
+				}
+
+				cells[g] = addMatrixHSP(cells[g], q, qstart, qend, pident)
+				nHSPs++
+			}
+			checkError(scanner.Err())
+			checkError(fh.Close())
+		}
+
+		if outputLog {
+			log.Infof("%d HSPs of %d queries in %d genomes", nHSPs, len(queries), len(genomes))
+		}
+
+		// ---------------------------------------------------------------
+		// output
+
+		outfh, gw, w, err := outStream(outFile, strings.HasSuffix(outFile, ".gz"), opt.CompressionLevel)
+		checkError(err)
+		defer func() {
+			outfh.Flush()
+			if gw != nil {
+				gw.Close()
+			}
+			w.Close()
+		}()
+
+		value := func(c *matrixCell) string {
+			switch valueType {
+			case "pident":
+				return strconv.FormatFloat(c.pident, 'f', 3, 64)
+			case "copies":
+				return strconv.Itoa(c.copies)
+			default:
+				return "1"
+			}
+		}
+
+		if sparse {
+			outfh.WriteString("genome\tquery\t" + valueType + "\n")
+			for g, cs := range cells {
+				for i := range cs {
+					if cs[i].qcovGnm(queryLens[cs[i].query]) < minQcovGnm {
+						continue
+					}
+					fmt.Fprintf(outfh, "%s\t%s\t%s\n", genomes[g], queries[cs[i].query], value(&cs[i]))
+				}
+			}
+			return
+		}
+
+		outfh.WriteString("genome")
+		for _, q := range queries {
+			outfh.WriteByte('\t')
+			outfh.WriteString(q)
+		}
+		outfh.WriteByte('\n')
+
+		row := make([]string, len(queries))
+		for g, cs := range cells {
+			for i := range row {
+				row[i] = "0"
+			}
+			for i := range cs {
+				if cs[i].qcovGnm(queryLens[cs[i].query]) < minQcovGnm {
+					continue
+				}
+				row[cs[i].query] = value(&cs[i])
+			}
+			outfh.WriteString(genomes[g])
+			for _, v := range row {
+				outfh.WriteByte('\t')
+				outfh.WriteString(v)
+			}
+			outfh.WriteByte('\n')
+		}
+	},
+}
+
+// matrixCell is a cell of a genome-by-query matrix.
+type matrixCell struct {
+	query   int
+	regions [][2]int // query regions of HSPs, 0-based closed intervals
+	pident  float64  // the best pident
+	copies  int      // the number of HSPs
+}
+
+// addMatrixHSP adds an HSP (1-based qstart and qend) of a query to cells of a genome,
+// which are sorted by queries.
+func addMatrixHSP(cs []matrixCell, q, qstart, qend int, pident float64) []matrixCell {
+	// HSPs of a query in a genome are consecutive, so the cell is often the last one.
+	var i int
+	for i = len(cs) - 1; i >= 0; i-- {
+		if cs[i].query <= q {
+			break
+		}
+	}
+	if i < 0 || cs[i].query != q {
+		cs = append(cs, matrixCell{})
+		copy(cs[i+2:], cs[i+1:])
+		i++
+		cs[i] = matrixCell{query: q}
+	}
+	cs[i].regions = append(cs[i].regions, [2]int{qstart - 1, qend - 1})
+	cs[i].pident = max(cs[i].pident, pident)
+	cs[i].copies++
+	return cs
+}
+
+// qcovGnm returns the query coverage (percentage) of HSPs in the genome.
+func (c *matrixCell) qcovGnm(qlen int) float64 {
+	if qlen <= 0 {
+		return 0
+	}
+	util.MergeRegions(&c.regions)
+	var covered int
+	for _, r := range c.regions {
+		covered += r[1] - r[0] + 1
+	}
+	return float64(covered) / float64(qlen) * 100
+}
+
+func init() {
+	utilsCmd.AddCommand(matrixCmd)
+
+	matrixCmd.Flags().StringP("index", "d", "",
+		formatFlagUsage(`Index directory created by "lexicmap index", for including genomes with no hits.`))
+
+	matrixCmd.Flags().StringP("out-file", "o", "-",
+		formatFlagUsage(`Out file, supports a ".gz" suffix ("-" for stdout).`))
+
+	matrixCmd.Flags().StringP("value", "v", "presence",
+		formatFlagUsage(`Value of cells: presence, pident, or copies.`))
+
+	matrixCmd.Flags().BoolP("sparse", "s", false,
+		formatFlagUsage(`Output non-zero cells in a sparse format with three columns: genome, query, value.`))
+
+	matrixCmd.Flags().StringP("buffer-size", "b", "20M",
+		formatFlagUsage(`Size of buffer, supported unit: K, M, G. You need increase the value when "bufio.Scanner: token too long" error reported`))
+
+	matrixCmd.Flags().Float64P("min-pident", "i", 0,
+		formatFlagUsage(`Minimum percentage of identity of HSPs.`))
+
+	matrixCmd.Flags().Float64P("min-qcov-hsp", "q", 0,
+		formatFlagUsage(`Minimum query coverage (percentage) of HSPs.`))
+
+	matrixCmd.Flags().Float64P("min-qcov-gnm", "Q", 0,
+		formatFlagUsage(`Minimum query coverage (percentage) per genome.`))
+
+	matrixCmd.SetUsageTemplate(usageTemplate(""))
+}
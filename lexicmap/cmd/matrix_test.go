@@ -0,0 +1,69 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMatrixCells(t *testing.T) {
+	const qlen = 1000
+	var cs []matrixCell
+
+	// HSPs of two queries in a genome, query 1 appears after query 2 in another report.
+	cs = addMatrixHSP(cs, 0, 1, 300, 95)
+	cs = addMatrixHSP(cs, 0, 201, 500, 99) // overlapped
+	cs = addMatrixHSP(cs, 0, 501, 600, 90) // adjacent
+	cs = addMatrixHSP(cs, 2, 1, 100, 98)
+	cs = addMatrixHSP(cs, 1, 901, 1000, 97)
+
+	if len(cs) != 3 {
+		t.Fatalf("3 cells expected, %d returned", len(cs))
+	}
+	for i, c := range []struct {
+		query   int
+		copies  int
+		pident  float64
+		qcovGnm float64
+	}{
+		{0, 3, 99, 60},
+		{1, 1, 97, 10},
+		{2, 1, 98, 10},
+	} {
+		if cs[i].query != c.query || cs[i].copies != c.copies || cs[i].pident != c.pident {
+			t.Errorf("cell %d: unexpected query %d, copies %d, or pident %f", i, cs[i].query, cs[i].copies, cs[i].pident)
+		}
+		if v := cs[i].qcovGnm(qlen); math.Abs(v-c.qcovGnm) > 1e-9 {
+			t.Errorf("cell %d: expected qcovGnm %f, returned %f", i, c.qcovGnm, v)
+		}
+	}
+
+	// qcovGnm is computed from the added HSPs only, i.e., those passing the filters,
+	// rather than the column qcovGnm of all HSPs.
+	cs = addMatrixHSP(nil, 0, 1, 300, 95)
+	if v := cs[0].qcovGnm(qlen); math.Abs(v-30) > 1e-9 {
+		t.Errorf("expected qcovGnm 30, returned %f", v)
+	}
+	if v := cs[0].qcovGnm(0); v != 0 {
+		t.Errorf("expected qcovGnm 0 for an invalid qlen, returned %f", v)
+	}
+}
@@ -0,0 +1,411 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/LexicMap/lexicmap/cmd/genome"
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Estimate abundances of genomes from search results of reads",
+	Long: `Estimate abundances of genomes from search results of reads
+
+Reads mapped to multiple genomes are not duplicated like "lexicmap utils bin" does. Instead,
+they are reassigned with an expectation-maximization (EM) algorithm:
+
+  1. For each read, only the best HSP (with the highest bit score) in each genome is used.
+  2. The likelihood of a read from genome g is w/L_g, where L_g is the genome size, and w is
+     the weight of the alignment: (e/(1-e))^m, where m is the number of mismatched bases
+     ($alenHSP * (1 - $pident/100)), and e is the per-base error rate (-e/--error-rate).
+     So alignments with higher identities are preferred.
+  3. In each iteration, each multi-mapping read is split into genomes in proportion to
+     the read fraction of each genome times the likelihood, and read fractions are updated with
+     the reassigned reads. Unique reads are always assigned to their genomes.
+
+Input:
+   - Output of 'lexicmap search', with a header line.
+   - Only hits passing the filters (-i/--min-pident and -q/--min-qcov-hsp) are used.
+
+Output (sorted by abundance in descending order):
+   1. genome,       Genome ID.
+   2. genome_size,  Genome size.
+   3. reads,        Estimated number of reads from the genome.
+   4. unique_reads, Number of reads only mapped to the genome.
+   5. abundance,    Relative abundance (percentage), i.e., estimated reads normalized by genome sizes.
+   6. coverage,     Estimated sequencing depth: $(aligned bases of assigned reads)/$genome_size.
+
+Taxonomy:
+   With a taxonomy file (-T/--taxonomy), abundances are also summed up at each level of lineages
+   and written to another file (--tax-out-file). The taxonomy file has two columns:
+   genome ID and lineage separated by semicolons, e.g.,
+        GCF_000017205.1	d__Bacteria;p__Pseudomonadota;c__Gammaproteobacteria;...
+   Columns of the output: level, taxon, lineage, reads, abundance.
+   Genomes absent in the file are counted as "unclassified".
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		opt := getOptions(cmd)
+		outputLog := opt.Verbose || opt.Log2File
+
+		dbDir := getFlagString(cmd, "index")
+		if dbDir == "" {
+			checkError(fmt.Errorf("flag -d/--index needed"))
+		}
+
+		outFile := getFlagString(cmd, "out-file")
+
+		bufferSizeS := getFlagString(cmd, "buffer-size")
+		if bufferSizeS == "" {
+			checkError(fmt.Errorf("value of buffer size. supported unit: K, M, G"))
+		}
+		bufferSize, err := ParseByteSize(bufferSizeS)
+		if err != nil {
+			checkError(fmt.Errorf("invalid value of buffer size. supported unit: K, M, G"))
+		}
+
+		minPident := getFlagNonNegativeFloat64(cmd, "min-pident")
+		if minPident > 100 {
+			checkError(fmt.Errorf("the value of flag -i/--min-pident (%f) should be in range of [0, 100]", minPident))
+		}
+		minQcov := getFlagNonNegativeFloat64(cmd, "min-qcov-hsp")
+		if minQcov > 100 {
+			checkError(fmt.Errorf("the value of flag -q/--min-qcov-hsp (%f) should be in range of [0, 100]", minQcov))
+		}
+
+		popt := &ProfileOptions{
+			ErrorRate:     getFlagNonNegativeFloat64(cmd, "error-rate"),
+			MaxIterations: getFlagPositiveInt(cmd, "max-iter"),
+			Tolerance:     getFlagNonNegativeFloat64(cmd, "tolerance"),
+		}
+		checkError(CheckProfileOptions(popt))
+
+		taxFile := getFlagString(cmd, "taxonomy")
+		taxOutFile := getFlagString(cmd, "tax-out-file")
+		if taxFile != "" && taxOutFile == "" {
+			checkError(fmt.Errorf("flag --tax-out-file needed when -T/--taxonomy is given"))
+		}
+
+		// ---------------------------------------------------------------
+		// genome sizes
+
+		if outputLog {
+			log.Infof("reading genome sizes from the index: %s", dbDir)
+		}
+		name2size, err := readGenomeSizes(dbDir)
+		checkError(err)
+		names := make([]string, 0, len(name2size))
+		for name := range name2size {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		name2idx := make(map[string]int, len(names))
+		sizes := make([]int, len(names))
+		for i, name := range names {
+			name2idx[name] = i
+			sizes[i] = name2size[name]
+		}
+		if outputLog {
+			log.Infof("  %d genomes", len(names))
+		}
+
+		profiler := NewProfiler(sizes, popt)
+
+		// ---------------------------------------------------------------
+		// search results
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		buf := make([]byte, bufferSize)
+		rp := newSearchResultParser(colQuery, colSgenome, colQcovHSP, colAlenHSP, colPident, colBitscore)
+
+		var preQuery string
+		hits := make([]ReadHit, 0, 128)
+		genome2hit := make(map[int]int, 128) // genome index -> index in hits
+		bitScores := make([]float64, 0, 128)
+
+		var query, sgenome string
+		var pident, qcov, bitscore float64
+		var alen, g, i int
+		var ok bool
+		var nReads int
+
+		addRead := func() {
+			if len(hits) > 0 {
+				profiler.AddRead(hits)
+				nReads++
+			}
+			hits = hits[:0]
+			bitScores = bitScores[:0]
+			clear(genome2hit)
+		}
+
+		for _, file := range files {
+			fh, err := xopen.Ropen(file)
+			checkError(err)
+
+			rp.Reset(file)
+			scanner := bufio.NewScanner(fh)
+			scanner.Buffer(buf, int(bufferSize))
+			for scanner.Scan() {
+				line := strings.TrimRight(scanner.Text(), "\r\n")
+				if line == "" {
+					continue
+				}
+				ok, err = rp.Parse(line)
+				checkError(err)
+				if !ok {
+					continue
+				}
+
+				query = rp.Field(colQuery)
+				if query != preQuery {
+					addRead()
+					preQuery = query
+				}
+
+				pident, _ = strconv.ParseFloat(rp.Field(colPident), 64)
+				qcov, _ = strconv.ParseFloat(rp.Field(colQcovHSP), 64)
+				if pident < minPident || qcov < minQcov {
+					continue
+				}
+				alen, _ = strconv.Atoi(rp.Field(colAlenHSP))
+				bitscore, _ = strconv.ParseFloat(rp.Field(colBitscore), 64)
+
+				sgenome = rp.Field(colSgenome)
+				if g, ok = name2idx[sgenome]; !ok {
+					checkError(fmt.Errorf("genome %s not found in the index: %s", sgenome, dbDir))
+				}
+
+				if i, ok = genome2hit[g]; ok { // only keep the best HSP in a genome
+					if bitscore > bitScores[i] {
+						hits[i] = ReadHit{Genome: g, PIdent: pident, AlignedLength: alen}
+						bitScores[i] = bitscore
+					}
+					continue
+				}
+				genome2hit[g] = len(hits)
+				hits = append(hits, ReadHit{Genome: g, PIdent: pident, AlignedLength: alen})
+				bitScores = append(bitScores, bitscore)
+			}
+			checkError(scanner.Err())
+			checkError(fh.Close())
+		}
+		addRead()
+
+		if outputLog {
+			log.Infof("%d reads with hits loaded", nReads)
+		}
+
+		// ---------------------------------------------------------------
+		// EM
+
+		res := profiler.Estimate()
+		if outputLog {
+			if res.Converged {
+				log.Infof("EM converged after %d iterations", res.Iterations)
+			} else {
+				log.Warningf("EM not converged after %d iterations", res.Iterations)
+			}
+		}
+
+		// ---------------------------------------------------------------
+		// output
+
+		outfh, gw, w, err := outStream(outFile, strings.HasSuffix(outFile, ".gz"), opt.CompressionLevel)
+		checkError(err)
+		defer func() {
+			outfh.Flush()
+			if gw != nil {
+				gw.Close()
+			}
+			w.Close()
+		}()
+
+		order := make([]int, 0, len(names))
+		for g = range names {
+			if res.Reads[g] >= 0.005 { // ignore genomes with almost no reads
+				order = append(order, g)
+			}
+		}
+		sort.Slice(order, func(i, j int) bool {
+			return res.Abundance[order[i]] > res.Abundance[order[j]]
+		})
+
+		outfh.WriteString("genome\tgenome_size\treads\tunique_reads\tabundance\tcoverage\n")
+		for _, g = range order {
+			fmt.Fprintf(outfh, "%s\t%d\t%.2f\t%d\t%.6f\t%.4f\n", names[g], sizes[g],
+				res.Reads[g], res.UniqueReads[g], res.Abundance[g], res.Coverage[g])
+		}
+
+		if taxFile == "" {
+			return
+		}
+
+		// ---------------------------------------------------------------
+		// taxonomy
+
+		lineages, err := readKVs(taxFile, false)
+		checkError(err)
+		if outputLog {
+			log.Infof("%d lineages loaded from %s", len(lineages), taxFile)
+		}
+
+		taxa := make(map[string]*profileTaxon, 1024)
+		var t *profileTaxon
+		for _, g = range order {
+			lineage, ok := lineages[names[g]]
+			if !ok || lineage == "" {
+				lineage = "unclassified"
+			}
+			ranks := strings.Split(lineage, ";")
+			for i = range ranks {
+				key := strings.Join(ranks[:i+1], ";")
+				if t, ok = taxa[key]; !ok {
+					t = &profileTaxon{Level: i + 1, Taxon: strings.TrimSpace(ranks[i]), Lineage: key}
+					taxa[key] = t
+				}
+				t.Reads += res.Reads[g]
+				t.Abundance += res.Abundance[g]
+			}
+		}
+		taxList := make([]*profileTaxon, 0, len(taxa))
+		for _, t = range taxa {
+			taxList = append(taxList, t)
+		}
+		sort.Slice(taxList, func(i, j int) bool {
+			if taxList[i].Level == taxList[j].Level {
+				return taxList[i].Abundance > taxList[j].Abundance
+			}
+			return taxList[i].Level < taxList[j].Level
+		})
+
+		outfh2, gw2, w2, err := outStream(taxOutFile, strings.HasSuffix(taxOutFile, ".gz"), opt.CompressionLevel)
+		checkError(err)
+		defer func() {
+			outfh2.Flush()
+			if gw2 != nil {
+				gw2.Close()
+			}
+			w2.Close()
+		}()
+
+		outfh2.WriteString("level\ttaxon\tlineage\treads\tabundance\n")
+		for _, t = range taxList {
+			fmt.Fprintf(outfh2, "%d\t%s\t%s\t%.2f\t%.6f\n", t.Level, t.Taxon, t.Lineage, t.Reads, t.Abundance)
+		}
+	},
+}
+
+// profileTaxon is a taxon at a level of lineages, with abundances summed up from genomes.
+type profileTaxon struct {
+	Level     int
+	Taxon     string
+	Lineage   string
+	Reads     float64
+	Abundance float64
+}
+
+// readGenomeSizes reads sizes of all genomes in an index,
+// sizes of genome chunks from the same genome are summed up.
+func readGenomeSizes(dbDir string) (map[string]int, error) {
+	name2idx, err := readGenomeMapName2Idx(filepath.Join(dbDir, FileGenomeIndex))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read genome index mapping file: %s", err)
+	}
+
+	readers := make(map[int]*genome.Reader, 8)
+	defer func() {
+		for _, rdr := range readers {
+			rdr.Close()
+		}
+	}()
+
+	sizes := make(map[string]int, len(name2idx))
+	for name, batchIDAndRefIDs := range name2idx {
+		for _, batchIDAndRefID := range *batchIDAndRefIDs {
+			genomeBatch := int(batchIDAndRefID >> BITS_GENOME_IDX)
+			genomeIdx := int(batchIDAndRefID & MASK_GENOME_IDX)
+
+			rdr, ok := readers[genomeBatch]
+			if !ok {
+				rdr, err = genome.NewReader(filepath.Join(dbDir, DirGenomes, batchDir(genomeBatch), FileGenomes))
+				if err != nil {
+					return nil, fmt.Errorf("failed to read genome data file: %s", err)
+				}
+				readers[genomeBatch] = rdr
+			}
+
+			g, err := rdr.GenomeInfo(genomeIdx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read genome information: %s", err)
+			}
+			sizes[name] += g.GenomeSize
+			genome.RecycleGenome(g)
+		}
+	}
+	return sizes, nil
+}
+
+func init() {
+	utilsCmd.AddCommand(profileCmd)
+
+	profileCmd.Flags().StringP("index", "d", "",
+		formatFlagUsage(`Index directory created by "lexicmap index", for getting genome sizes.`))
+
+	profileCmd.Flags().StringP("out-file", "o", "-",
+		formatFlagUsage(`Out file, supports a ".gz" suffix ("-" for stdout).`))
+
+	profileCmd.Flags().StringP("buffer-size", "b", "20M",
+		formatFlagUsage(`Size of buffer, supported unit: K, M, G. You need increase the value when "bufio.Scanner: token too long" error reported`))
+
+	profileCmd.Flags().Float64P("min-pident", "i", 0,
+		formatFlagUsage(`Minimum percentage of identity of HSPs.`))
+
+	profileCmd.Flags().Float64P("min-qcov-hsp", "q", 0,
+		formatFlagUsage(`Minimum query coverage (percentage) of HSPs.`))
+
+	profileCmd.Flags().Float64P("error-rate", "e", DefaultProfileOptions.ErrorRate,
+		formatFlagUsage(`Per-base error rate for weighting alignments of multi-mapping reads by identity. Smaller values prefer genomes with higher identities more strongly.`))
+
+	profileCmd.Flags().IntP("max-iter", "", DefaultProfileOptions.MaxIterations,
+		formatFlagUsage(`Maximum number of EM iterations.`))
+
+	profileCmd.Flags().Float64P("tolerance", "", DefaultProfileOptions.Tolerance,
+		formatFlagUsage(`EM stops when changes of read fractions of all genomes are below this value.`))
+
+	profileCmd.Flags().StringP("taxonomy", "T", "",
+		formatFlagUsage(`Two-column tabular file mapping genome IDs to lineages separated by semicolons, for summing up abundances at each level of lineages.`))
+
+	profileCmd.Flags().StringP("tax-out-file", "", "",
+		formatFlagUsage(`Out file of abundances of taxa, needed with -T/--taxonomy. Supports a ".gz" suffix ("-" for stdout).`))
+
+	profileCmd.SetUsageTemplate(usageTemplate(""))
+}
@@ -27,12 +27,15 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/shenwei356/LexicMap/lexicmap/cmd/util"
 	"github.com/shenwei356/bio/seq"
 	"github.com/shenwei356/bio/seqio/fastx"
+	"github.com/shenwei356/wfa"
 	"github.com/spf13/cobra"
 )
 
@@ -66,7 +69,7 @@ Alignment result relationship:
   > highest alignment scores in a given search. https://www.ncbi.nlm.nih.gov/books/NBK62051/
 
 Output format:
-  Tab-delimited format with 17+ columns, with 1-based positions.
+  Tab-delimited format with 20+ columns, with 1-based positions.
 
     1.  query,    Query sequence ID.
     2.  qlen,     Query sequence length.
@@ -89,10 +92,169 @@ Output format:
     19. qseq,     Aligned part of query sequence.                     (optional with -a/--all)
     20. sseq,     Aligned part of subject sequence.                   (optional with -a/--all)
     21. align,    Alignment text ("|" and " ") between qseq and sseq. (optional with -a/--all)
+  Columns appended at the end, i.e., after the optional columns of -a/--all and --long-read:
+        evalue,   Expect value.
+        bitscore, Bit score.
+        mapq,     Mapping-quality style uniqueness score (0-60) of the genome hit, see below.
+
+Mapping quality (mapq):
+  1. It measures how confidently a query is assigned to a genome rather than others, computed from
+     the score gap between the best and the second best genomes, similar to minimap2:
+        mapq = 40 * (1 - s2/s1) * ln(s1), capped to [0, 60]
+     Here, the score of a genome hit is the seed chaining score times pident of its best HSP.
+  2. Only the best genome might have a mapq > 0, the others are 0. A mapq of 0 for the best
+     genome means that other genomes match equally well, e.g., multi-copy genes or identical strains.
+
+Long reads (--long-read):
+  1. For reads mapped as non-collinear segments, e.g., across a plasmid integration site or a misassembly,
+     HSPs in each subject genome are grouped into:
+       primary,        HSPs in the best collinear chain (same sequence and strand, in the same order).
+       supplementary,  HSPs in other collinear chains, covering other regions of the query.
+       secondary,      alternative HSPs of query regions already covered by the above segments.
+  2. Four extra columns are appended (after the optional columns of -a/--all):
+       segment,        Segment type: primary, supplementary, or secondary.
+       bkpQpos,        Query position of the breakpoint, i.e., the start of the segment, if it follows
+                       a different segment in the query.
+       bkpSubject,     Subject positions of the breakpoint: $sseqid1:$end1>$sseqid2:$start2, where
+                       the former is the end of the previous segment, and the latter is the start of this one.
+       bkpStrand,      Strands of the two segments, e.g., "+>-" is a strand switch.
+     Breakpoints are only annotated to the first HSP of a segment, others are "-".
+  3. Breakpoints are detected within each subject genome.
+
+Paired-end reads (--paired):
+  1. Two files of read 1 and read 2 are needed, and reads are paired by their order in the files.
+  2. Both mates are searched, and only genomes where both mates are aligned to the same sequence in
+     the forward-reverse orientation (the forward mate does not start after the reverse one, so
+     dovetailed pairs are excluded), with an insert size in the range of [--paired-min-insert,
+     --paired-max-insert], are kept.
+  3. For each genome, only the best pair of HSPs (with the highest sum of bit scores) is reported,
+     as two lines for read 1 and read 2. Genomes are sorted by the pair score, i.e., the first one
+     is the pair-level best hit. The column hits is the number of genomes with proper pairs.
+  4. If the two mates have the same ID, "/1" and "/2" are appended to distinguish them.
+
+Keeping the order and binning reads (--keep-order and --bin-dir):
+  1. By default, results are outputted as soon as queries are searched, i.e., not in the input order.
+     With --keep-order, results are in the same order as the input queries, so the output can be
+     merge-joined with reads in "lexicmap utils bin" without loading the whole report.
+     Results of queries finished earlier are buffered, at most max(1024, 16 * --max-query-conc)
+     queries, and reading new queries pauses when the buffer is full, e.g., a query takes much
+     longer time than others. So the memory is bounded, at the cost of some speed.
+  2. With --bin-dir, reads are directly binned into files of genomes they are mapped to during searching,
+     with the same layout as the output of "lexicmap utils bin". Paired-end reads (--paired) are binned
+     as pairs, with mates written into _R1 and _R2 files.
+     HSPs are filtered with -i/--align-min-match-pident, -q/--min-qcov-per-hsp, and -Q/--min-qcov-per-genome,
+     the same as -i/--min-pident, -q/--min-qcov-hsp, and -Q/--min-qcov-genome of "lexicmap utils bin"
+     with the strategy "all".
+
+Alignments across the origin of circular sequences:
+  1. Sequences marked as circular in "lexicmap index" (--circular-keyword and --circular-seqs) are
+     considered circular, and alignments close to either end of these sequences are extended across
+     the origin, which are reported as single HSPs instead of two partial ones.
+  2. Coordinates of these HSPs are wrapped: send > slen, i.e., positions > slen are on the next copy of the
+     sequence, and the real position is $position - $slen. E.g., sstart=4990, send=5100, slen=5000 means
+     the alignment spans 4990-5000 and 1-100.
+  3. It only works in the default accurate alignment mode, and indexes built with v0.4.1 or later versions.
+
+Low-complexity masking of queries (--query-dust):
+  1. Low-complexity regions (e.g., poly-A tails, short tandem repeats) of queries are found with
+     the symmetric DUST algorithm, with a sliding window of --query-dust-window bp. A window is masked
+     if its triplet score is larger than --query-dust-threshold.
+  2. K-mers overlapping these regions are not used as seeds, which reduces spurious seed matches
+     and speeds up searching, while the regions are still aligned in extension.
+  3. The numbers of masked bases of queries are reported in the log (verbose mode).
+
+Hub seeds (--max-seed-occ):
+  1. Some k-mers (e.g., from rRNA genes and transposases) are shared by a huge number of genomes, and
+     their matches dominate the searching time and memory. With --max-seed-occ N, matched k-mers with
+     more than N occurrences (positions in all genomes) are skipped, i.e., they are not used as seeds.
+  2. The number of occurrences of each k-mer is stored ahead of its positions in the seed data,
+     so positions of hub k-mers are skipped without being read.
+  3. Queries only matching hub seeds might have no results, so it should be used with caution, e.g.,
+     set N to several times the number of genomes in the index.
+  4. The number of skipped seeds is reported in the log.
+
+WFA alignment:
+  1. Alignments are performed with the Wavefront alignment algorithm (WFA) with gap-affine penalties:
+     --wfa-mismatch, --wfa-gap-open, and --wfa-gap-ext. A match costs 0.
+  2. These penalties determine the shape of alignments, and hence pident and gaps:
+     - A smaller gap opening/extension penalty (relative to the mismatch penalty) prefers gaps
+       over mismatches, leading to more gaps, longer alignments, and possibly lower pident.
+     - A bigger gap opening penalty produces fewer but longer gaps.
+     - Only the relative values matter, e.g., (2, 3, 1) gives the same alignments as (4, 6, 2),
+       if the adaptive reduction is off. --wfa-adaptive-max-dist-diff is an absolute distance
+       in the same unit as the penalties, so it should be scaled along with them.
+     - For near-identical sequences (e.g., reads), the defaults work well.
+       For divergent homologs (70-80% identity), a smaller mismatch penalty (e.g., 3, 6, 2)
+       and --wfa-no-adaptive help to find better alignments, at the cost of speed.
+  3. The adaptive reduction heuristic drops wavefront diagonals lagging behind the best one by
+     over --wfa-adaptive-max-dist-diff, which speeds up alignment but might miss the optimal one
+     in divergent regions.
+
+Alignment score, bit score, and E-value:
+  1. The raw score is computed from the alignment with the scoring scheme: --score-match,
+     --score-mismatch, --score-gap-open, and --score-gap-ext. A gap of length L costs
+     $gap_open + $gap_ext * L. In the pseudo-alignment mode (--pseudo-align), gaps are ignored.
+  2. The raw score S is converted to bit score and E-value with Karlin-Altschul parameters λ and K:
+        bitscore = (λS - ln K) / ln 2
+        evalue   = $qlen * $total_bases * 2^(-bitscore)
+     Here, the search space is the query length times the total bases of all genomes in the index,
+     without length adjustment. So E-values are slightly larger than those of BLAST.
+  3. λ and K of some scoring schemes (match, mismatch, gap open, gap extension) are built in:
+        2, 3, 5, 2 (default, the same as blastn)
+        1, 3, 5, 2 (the same as blastn-short)
+     For other scoring schemes, please specify them with --score-lambda and --score-k.
+
+User-defined output format (--outfmt):
+  Similar to BLAST tabular format (-outfmt 6), e.g., "6 qseqid sseqid pident length".
+  Header line is not outputted, and there's no limit to the order of fields.
+
+    BLAST-style fields:
+      qseqid, sseqid, pident, length, mismatch, gapopen, nident, gaps, qstart, qend,
+      sstart, send, sstrand, evalue, bitscore, qlen, slen, qcovhsp, qcovs (query coverage per genome),
+      qseq, sseq.
+    LexicMap-specific fields (the same as the default output):
+      query, hits, sgenome, qcovGnm, hsp, qcovHSP, alenHSP, sstr, mapq, cigar, align.
+      segment, bkpQpos, bkpSubject, bkpStrand (switching on --long-read).
+
+  Note that sstart > send for the minus strand, which is different from the default output.
+  Without CIGAR (-A/--pseudo-align), nident is estimated from pident and length, mismatch is
+  estimated as length - nident - gaps, and gapopen is outputted as NA.
+
+Genome hits only (--genome-hits-only):
+  1. For screening which genomes a query matches, searching stops after seed chaining,
+     with no alignment performed, which is several times faster than the default mode.
+  2. One line is outputted for each query-genome hit, in 8 columns:
+       query,      Query sequence ID.
+       qlen,       Query sequence length.
+       hits,       Number of subject genomes.
+       sgenome,    Subject genome ID.
+       qcovGnm,    Estimated query coverage (percentage) per genome: $(bases spanned by seed chains)/$qlen.
+       seeds,      Number of chained seeds.
+       seedCov,    Percentage of query bases covered by chained seeds.
+       score,      Seed chaining score.
+     Genomes are sorted by the chaining score. -Q/--min-qcov-per-genome is applied to the estimated qcovGnm.
+  3. It is incompatible with -a/--all, --outfmt, --paired, and --long-read.
+
+Searching with a genome in the index (--query-genome):
+  1. All sequences of the genome are read from the index and searched as queries,
+     so no input file is needed. Sequences of chunked big genomes are all included.
+  2. Hits in the genome itself can be excluded with --exclude-self.
+  3. With --query-genome-summary, alignments of all query sequences are summarized for each subject genome:
+       query,         Query genome ID.
+       qsize,         Query genome size.
+       qseqs,         Number of query sequences.
+       sgenome,       Subject genome ID.
+       qseqsAligned,  Number of query sequences aligned to the subject genome.
+       qcovGnm,       Query genome coverage (percentage): $(aligned bases of all sequences)/$qsize.
+       pident,        Percentage of identity of all HSPs, weighted by aligned lengths.
+  4. Aligning long sequences like whole chromosomes is slow, --genome-hits-only is much faster
+     for finding close relatives.
+  5. It is incompatible with --paired and --bin-dir.
 
 Result ordering:
   1. Within each subject genome, alignments (HSP) are sorted by qcovHSP*pident.
   2. Results of multiple subject genomes are sorted by qcovHSP*pident of the best alignment.
+  Or bit score is used instead of qcovHSP*pident with --sort-by-bitscore.
 
 `,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -128,11 +290,31 @@ Result ordering:
 			checkError(fmt.Errorf("flag -d/--index needed"))
 		}
 		outFile := getFlagString(cmd, "out-file")
+		keepOrder := getFlagBool(cmd, "keep-order")
+		binDir := getFlagString(cmd, "bin-dir")
+		if binDir != "" {
+			if _, err = os.Stat(binDir); !os.IsNotExist(err) {
+				checkError(fmt.Errorf("the directory of binned reads (--bin-dir) should not exist: %s", binDir))
+			}
+		}
 		minPrefix := getFlagPositiveInt(cmd, "seed-min-prefix")
 		if minPrefix > 32 || minPrefix < 5 {
 			checkError(fmt.Errorf("the value of flag -p/--seed-min-prefix (%d) should be in the range of [5, 32]", minPrefix))
 		}
 		moreColumns := getFlagBool(cmd, "all")
+		longRead := getFlagBool(cmd, "long-read")
+
+		var outFmt *OutFmt
+		if outFmtS := getFlagString(cmd, "outfmt"); outFmtS != "" {
+			outFmt, err = ParseOutFmt(outFmtS)
+			checkError(err)
+			if outFmt.NeedAll { // some fields are computed from the CIGAR or need aligned sequences
+				moreColumns = true
+			}
+			if outFmt.NeedLongRead {
+				longRead = true
+			}
+		}
 
 		// maxMismatch := getFlagInt(cmd, "seed-max-mismatch")
 		minSinglePrefix := getFlagPositiveInt(cmd, "seed-min-single-prefix")
@@ -151,6 +333,16 @@ Result ordering:
 		// 	checkError(fmt.Errorf("the value of flag -m/--seed-min-matches (%d) should be >= that of -P/--seed-min-single-prefix (%d)", minMatches, minSinglePrefix))
 		// }
 
+		queryDust := getFlagBool(cmd, "query-dust")
+		queryDustWindow := getFlagPositiveInt(cmd, "query-dust-window")
+		if queryDustWindow < 4 {
+			checkError(fmt.Errorf("the value of flag --query-dust-window (%d) should be >= 4", queryDustWindow))
+		}
+		queryDustThreshold := getFlagNonNegativeFloat64(cmd, "query-dust-threshold")
+		if queryDustThreshold == 0 {
+			checkError(fmt.Errorf("the value of flag --query-dust-threshold should be > 0"))
+		}
+
 		maxGap := getFlagPositiveInt(cmd, "seed-max-gap")
 		maxDist := getFlagPositiveInt(cmd, "seed-max-dist")
 		extLen := getFlagNonNegativeInt(cmd, "align-ext-len")
@@ -158,9 +350,18 @@ Result ordering:
 		// 	checkError(fmt.Errorf("the value of flag --align-ext-len should be >= 1000"))
 		// }
 		topn := getFlagNonNegativeInt(cmd, "top-n-genomes")
+		maxSeedOcc := getFlagNonNegativeInt(cmd, "max-seed-occ")
 		inMemorySearch := getFlagBool(cmd, "load-whole-seeds")
 
 		onlyPseudoAlign := getFlagBool(cmd, "pseudo-align")
+		genomeHitsOnly := getFlagBool(cmd, "genome-hits-only")
+
+		queryGenome := getFlagString(cmd, "query-genome")
+		excludeSelf := getFlagBool(cmd, "exclude-self")
+		queryGenomeSummaryFile := getFlagString(cmd, "query-genome-summary")
+		if queryGenome == "" && (excludeSelf || queryGenomeSummaryFile != "") {
+			checkError(fmt.Errorf("flags --exclude-self and --query-genome-summary need --query-genome"))
+		}
 
 		minAlignLen := getFlagPositiveInt(cmd, "align-min-match-len")
 		if minAlignLen < minSinglePrefix {
@@ -193,8 +394,67 @@ Result ordering:
 			checkError(fmt.Errorf("the value of flag -q/--min-qcov-per-hsp (%f) should be in range of [0, 100]", minIdent))
 		}
 
+		wfaPenalties := wfa.Penalties{
+			Mismatch: uint32(getFlagPositiveInt(cmd, "wfa-mismatch")),
+			GapOpen:  uint32(getFlagNonNegativeInt(cmd, "wfa-gap-open")),
+			GapExt:   uint32(getFlagPositiveInt(cmd, "wfa-gap-ext")),
+		}
+		wfaAdaptive := !getFlagBool(cmd, "wfa-no-adaptive")
+		wfaAdaptiveOption := wfa.AdaptiveReductionOption{
+			MinWFLen:    uint32(getFlagPositiveInt(cmd, "wfa-adaptive-min-wf-len")),
+			MaxDistDiff: uint32(getFlagPositiveInt(cmd, "wfa-adaptive-max-dist-diff")),
+			CutoffStep:  wfa.DefaultAdaptiveOption.CutoffStep,
+		}
+
+		maxEvalue := getFlagNonNegativeFloat64(cmd, "max-evalue")
+		sortByBitScore := getFlagBool(cmd, "sort-by-bitscore")
+
+		scoring := ScoringOptions{
+			Match:    getFlagPositiveInt(cmd, "score-match"),
+			Mismatch: getFlagPositiveInt(cmd, "score-mismatch"),
+			GapOpen:  getFlagNonNegativeInt(cmd, "score-gap-open"),
+			GapExt:   getFlagPositiveInt(cmd, "score-gap-ext"),
+			Lambda:   getFlagNonNegativeFloat64(cmd, "score-lambda"),
+			K:        getFlagNonNegativeFloat64(cmd, "score-k"),
+		}
+		if scoring.Lambda == 0 || scoring.K == 0 {
+			lambda, k, err := KarlinAltschulParams(scoring.Match, scoring.Mismatch, scoring.GapOpen, scoring.GapExt)
+			if err != nil {
+				checkError(fmt.Errorf("%s with --score-lambda and --score-k", err))
+			}
+			if scoring.Lambda == 0 {
+				scoring.Lambda = lambda
+			}
+			if scoring.K == 0 {
+				scoring.K = k
+			}
+		}
+		checkError(CheckScoringOptions(&scoring))
+
 		maxOpenFiles := getFlagPositiveInt(cmd, "max-open-files")
 
+		paired := getFlagBool(cmd, "paired")
+		pairingOpt := &PairingOptions{
+			MinInsertSize: getFlagPositiveInt(cmd, "paired-min-insert"),
+			MaxInsertSize: getFlagPositiveInt(cmd, "paired-max-insert"),
+		}
+		checkError(CheckPairingOptions(pairingOpt))
+
+		longReadOpt := DefaultLongReadOptions
+		longReadOpt.MaxGap = getFlagNonNegativeInt(cmd, "long-read-max-gap")
+		checkError(CheckLongReadOptions(&longReadOpt))
+		if paired && longRead {
+			checkError(fmt.Errorf("flags --paired and --long-read are incompatible"))
+		}
+		if genomeHitsOnly {
+			if moreColumns || outFmt != nil {
+				checkError(fmt.Errorf("flag --genome-hits-only is incompatible with -a/--all and --outfmt"))
+			}
+			if paired || longRead || binDir != "" {
+				checkError(fmt.Errorf("flag --genome-hits-only is incompatible with --paired, --long-read, and --bin-dir"))
+			}
+		}
+
 		// ---------------------------------------------------------------
 
 		if outputLog {
@@ -210,17 +470,39 @@ Result ordering:
 			log.Info("checking input files ...")
 		}
 
-		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+		var files []string // no input files for --query-genome
+		if queryGenome != "" {
+			if len(args) > 0 || getFlagString(cmd, "infile-list") != "" {
+				checkError(fmt.Errorf("no input files are needed for --query-genome"))
+			}
+			if paired || binDir != "" {
+				checkError(fmt.Errorf("flag --query-genome is incompatible with --paired and --bin-dir"))
+			}
+			if outputLog {
+				log.Infof("  searching with the genome in the index: %s", queryGenome)
+			}
+		} else {
+			files = getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
 
-		if outputLog {
-			if len(files) == 1 {
-				if isStdin(files[0]) {
-					log.Info("  no files given, reading from stdin")
+			if outputLog {
+				if len(files) == 1 {
+					if isStdin(files[0]) {
+						log.Info("  no files given, reading from stdin")
+					} else {
+						log.Infof("  %d input file given: %s", len(files), files[0])
+					}
 				} else {
-					log.Infof("  %d input file given: %s", len(files), files[0])
+					log.Infof("  %d input file(s) given", len(files))
 				}
-			} else {
-				log.Infof("  %d input file(s) given", len(files))
+			}
+		}
+
+		if paired {
+			if len(files) != 2 || isStdin(files[0]) || isStdin(files[1]) {
+				checkError(fmt.Errorf("two files of paired-end reads are needed for --paired"))
+			}
+			if outputLog {
+				log.Infof("  paired-end mode, read 1: %s, read 2: %s", files[0], files[1])
 			}
 		}
 
@@ -236,6 +518,33 @@ Result ordering:
 			maxQueryConcurrency = runtime.NumCPU()
 		}
 
+		// the query genome in the index
+		var qGenome *indexGenome
+		var selfChunks map[uint64]interface{}
+		var qgSummary *QueryGenomeSummary
+		if queryGenome != "" {
+			srcIndex, err := newGenomeSourceIndex(dbDir)
+			checkError(err)
+			qGenome, err = srcIndex.Genome(queryGenome)
+			checkError(err)
+			if excludeSelf {
+				selfChunks, err = srcIndex.GenomeChunks(queryGenome)
+				checkError(err)
+			}
+			checkError(srcIndex.Close())
+
+			if queryGenomeSummaryFile != "" {
+				var qSize int
+				for _, s := range qGenome.Seqs {
+					qSize += len(s)
+				}
+				qgSummary = NewQueryGenomeSummary(queryGenome, qSize, len(qGenome.Seqs))
+			}
+			if outputLog {
+				log.Infof("%d sequences of the query genome read from the index", len(qGenome.Seqs))
+			}
+		}
+
 		// ---------------------------------------------------------------
 		// loading index
 
@@ -254,8 +563,15 @@ Result ordering:
 			// MaxMismatch:     maxMismatch,
 			MinSinglePrefix: uint8(minSinglePrefix),
 			// MinMatchedBases: uint8(minMatches),
-			TopN:           topn,
-			InMemorySearch: inMemorySearch,
+			TopN:            topn,
+			ExcludedGenomes: selfChunks,
+			InMemorySearch:  inMemorySearch,
+
+			MaxSeedOccurrences: maxSeedOcc,
+
+			QueryDust:          queryDust,
+			QueryDustWindow:    queryDustWindow,
+			QueryDustThreshold: queryDustThreshold,
 
 			MaxGap:      float64(maxGap),
 			MaxDistance: float64(maxDist),
@@ -265,6 +581,11 @@ Result ordering:
 			MinQueryAlignedFractionInAGenome: minQcovGenome,
 
 			MoreAccurateAlignment: !onlyPseudoAlign,
+			GenomeHitsOnly:        genomeHitsOnly,
+
+			Scoring:        scoring,
+			MaxEvalue:      maxEvalue,
+			SortByBitScore: sortByBitScore,
 
 			OutputSeq: moreColumns,
 		}
@@ -304,20 +625,161 @@ Result ordering:
 		}()
 
 		var total, matched uint64
+		var maskedQueries, maskedBases uint64
 		var speed float64 // k reads/second
 
 		// fmt.Fprintf(outfh, "query\tqlen\tqstart\tqend\thits\tsgenome\tsseqid\tqcovGnm\thsp\tqcovHSP\talenHSP\talenSeg\tpident\tslen\tsstart\tsend\tsstr\tseeds\n")
-		fmt.Fprintf(outfh, "query\tqlen\thits\tsgenome\tsseqid\tqcovGnm\thsp\tqcovHSP\talenHSP\tpident\tgaps\tqstart\tqend\tsstart\tsend\tsstr\tslen")
-		if moreColumns {
-			fmt.Fprintf(outfh, "\tcigar\tqseq\tsseq\talign")
+		if genomeHitsOnly {
+			fmt.Fprintln(outfh, "query\tqlen\thits\tsgenome\tqcovGnm\tseeds\tseedCov\tscore")
+		} else if outFmt == nil { // there's no header line in the BLAST tabular format
+			fmt.Fprintln(outfh, searchHeader(moreColumns, longRead))
+		}
+
+		items := make([]string, nColsTotal) // for the user-defined output format
+
+		// printHSP outputs an HSP (c) of a query in a subject genome (r)
+		printHSP := func(queryID []byte, qseq []byte, targets int,
+			r *SearchResult, sd *SimilarityDetail, c *Chain2Result, j int) {
+			var strand byte
+			if sd.RC {
+				strand = '-'
+			} else {
+				strand = '+'
+			}
+
+			if outFmt != nil {
+				items[colQuery] = string(queryID)
+				items[colQlen] = strconv.Itoa(len(qseq))
+				items[colHits] = strconv.Itoa(targets)
+				items[colSgenome] = string(r.ID)
+				items[colSseqid] = string(sd.SeqID)
+				items[colQcovGnm] = strconv.FormatFloat(r.AlignedFraction, 'f', 3, 64)
+				items[colHSP] = strconv.Itoa(j)
+				items[colQcovHSP] = strconv.FormatFloat(c.AlignedFraction, 'f', 3, 64)
+				items[colAlenHSP] = strconv.Itoa(c.AlignedLength)
+				items[colPident] = strconv.FormatFloat(c.PIdent, 'f', 3, 64)
+				items[colGaps] = strconv.Itoa(c.Gaps)
+				items[colQstart] = strconv.Itoa(c.QBegin + 1)
+				items[colQend] = strconv.Itoa(c.QEnd + 1)
+				items[colSstart] = strconv.Itoa(c.TBegin + 1)
+				items[colSend] = strconv.Itoa(c.TEnd + 1)
+				items[colSstr] = string(strand)
+				items[colSlen] = strconv.Itoa(sd.SeqLen)
+				items[colEvalue] = formatEvalue(c.Evalue)
+				items[colBitscore] = strconv.FormatFloat(c.BitScore, 'f', 1, 64)
+				items[colMapq] = strconv.Itoa(int(r.MAPQ))
+				if moreColumns {
+					items[colCigar] = string(c.CIGAR)
+					if onlyPseudoAlign {
+						items[colQseq] = string(qseq[c.QBegin : c.QEnd+1])
+					} else {
+						items[colQseq] = string(c.QSeq)
+					}
+					items[colSseq] = string(c.TSeq)
+					items[colAlign] = string(c.Alignment)
+				}
+				if longRead {
+					items[colSegment] = SegTypeNames[c.SegType]
+					if c.Breakpoint != nil {
+						items[colBkpQpos] = strconv.Itoa(c.Breakpoint.QPos + 1)
+						items[colBkpSubject] = formatBreakpointSubject(c.Breakpoint)
+						items[colBkpStrand] = formatBreakpointStrand(c.Breakpoint)
+					} else {
+						items[colBkpQpos], items[colBkpSubject], items[colBkpStrand] = "-", "-", "-"
+					}
+				}
+				outFmt.Write(outfh, items)
+				return
+			}
+
+			fmt.Fprintf(outfh, "%s\t%d\t%d\t%s\t%s\t%.3f\t%d\t%.3f\t%d\t%.3f\t%d\t%d\t%d\t%d\t%d\t%c\t%d",
+				queryID, len(qseq),
+				targets, r.ID, sd.SeqID, r.AlignedFraction,
+				j, c.AlignedFraction, c.AlignedLength, c.PIdent, c.Gaps,
+				c.QBegin+1, c.QEnd+1,
+				c.TBegin+1, c.TEnd+1,
+				strand, sd.SeqLen,
+			)
+			if moreColumns {
+				if onlyPseudoAlign {
+					fmt.Fprintf(outfh, "\t%s\t%s\t%s\t%s", c.CIGAR, qseq[c.QBegin:c.QEnd+1], c.TSeq, c.Alignment)
+				} else {
+					fmt.Fprintf(outfh, "\t%s\t%s\t%s\t%s", c.CIGAR, c.QSeq, c.TSeq, c.Alignment)
+				}
+			}
+			if longRead {
+				if c.Breakpoint != nil {
+					fmt.Fprintf(outfh, "\t%s\t%d\t%s\t%s", SegTypeNames[c.SegType], c.Breakpoint.QPos+1,
+						formatBreakpointSubject(c.Breakpoint), formatBreakpointStrand(c.Breakpoint))
+				} else {
+					fmt.Fprintf(outfh, "\t%s\t-\t-\t-", SegTypeNames[c.SegType])
+				}
+			}
+			fmt.Fprintf(outfh, "\t%s\t%.1f\t%d\n", formatEvalue(c.Evalue), c.BitScore, r.MAPQ)
+		}
+
+		var binner *ReadBinner
+		var assigner *BinAssigner
+		if binDir != "" {
+			binner = NewReadBinner(binDir, true, opt.CompressionLevel)
+			binner.Compression = CompressionSuffix(files[0])
+			// the same filters as "lexicmap utils bin"
+			assigner = &BinAssigner{
+				MinPident:     minIdent,
+				MinQcovHSP:    minQcovChain,
+				MinQcovGenome: minQcovGenome,
+				Strategy:      BinStrategyAll,
+				BothMates:     paired,
+			}
+		}
+
+		// binRead bins the read (or the read pair) into genomes it is mapped to.
+		binRead := func(q *Query) {
+			assigner.Reset()
+			// query objects will be reused, but the records will not
+			read := q.record
+			if q.mate != nil {
+				for _, p := range q.pairs {
+					assigner.AddHit(0, string(p.R1.ID), p.C1.PIdent, p.C1.AlignedFraction, p.R1.AlignedFraction, p.C1.BitScore)
+					assigner.AddHit(1, string(p.R2.ID), p.C2.PIdent, p.C2.AlignedFraction, p.R2.AlignedFraction, p.C2.BitScore)
+				}
+				read2 := q.mate.record
+				binner.AddPair(&read, &read2, assigner.Bins())
+				return
+			}
+			if q.result != nil {
+				for _, r := range *q.result {
+					for _, sd := range *r.SimilarityDetails {
+						for _, c := range *sd.Similarity.Chains {
+							if c == nil {
+								continue
+							}
+							assigner.AddHit(0, string(r.ID), c.PIdent, c.AlignedFraction, r.AlignedFraction, c.BitScore)
+						}
+					}
+				}
+			}
+			binner.Add(&read, assigner.Bins())
 		}
-		fmt.Fprintln(outfh)
 
 		printResult := func(q *Query) {
 			total++
-			if q.result == nil { // seqs shorter than K or queries without matches.
-				poolQuery.Put(q)
-				return
+
+			if binner != nil {
+				binRead(q)
+			}
+
+			if queryDust {
+				for _q := q; _q != nil; _q = _q.mate {
+					if _q.masked == 0 {
+						continue
+					}
+					maskedQueries++
+					maskedBases += uint64(_q.masked)
+					if verbose {
+						log.Infof("query %s: %d of %d bases masked as low-complexity regions", _q.seqID, _q.masked, len(_q.seq))
+					}
+				}
 			}
 
 			if verbose {
@@ -327,58 +789,68 @@ Result ordering:
 				}
 			}
 
+			if q.mate != nil { // paired-end reads
+				if len(q.pairs) > 0 {
+					matched++
+
+					// only the best pair in each genome, and both mates have the same hits
+					targets := len(q.pairs)
+					for _, p := range q.pairs {
+						printHSP(q.seqID, q.seq, targets, p.R1, p.SD1, p.C1, 1)
+						printHSP(q.mate.seqID, q.mate.seq, targets, p.R2, p.SD2, p.C2, 1)
+					}
+					outfh.Flush()
+				}
+
+				if q.result != nil {
+					idx.RecycleSearchResults(q.result)
+				}
+				if q.mate.result != nil {
+					idx.RecycleSearchResults(q.mate.result)
+				}
+				poolQuery.Put(q.mate)
+				poolQuery.Put(q)
+				return
+			}
+
+			if qgSummary != nil {
+				qgSummary.Add(len(q.seq), q.result)
+			}
+
+			if q.result == nil { // seqs shorter than K or queries without matches.
+				poolQuery.Put(q)
+				return
+			}
+
 			queryID := q.seqID
-			// var c int
-			// var v *index.SubstrPair
-			// var i int
-			// var subs *[]*index.SubstrPair
 			var sd *SimilarityDetail
-			var cr *SeqComparatorResult
 			var c *Chain2Result
 			var targets = len(*q.result)
 			matched++
 
-			var strand byte
+			if genomeHitsOnly {
+				for _, r := range *q.result { // each genome
+					fmt.Fprintf(outfh, "%s\t%d\t%d\t%s\t%.3f\t%d\t%.3f\t%.0f\n",
+						queryID, len(q.seq), targets, r.ID, r.AlignedFraction,
+						r.NumSeeds, r.SeedCoverage, r.Score)
+				}
+				idx.RecycleSearchResults(q.result)
+
+				poolQuery.Put(q)
+				outfh.Flush()
+				return
+			}
+
 			var j int
 			for _, r := range *q.result { // each genome
 				j = 1
 				for _, sd = range *r.SimilarityDetails { // each chain
-					cr = sd.Similarity
-
-					if sd.RC {
-						strand = '-'
-					} else {
-						strand = '+'
-					}
-
-					for _, c = range *cr.Chains { // each match
+					for _, c = range *sd.Similarity.Chains { // each match
 						if c == nil {
 							continue
 						}
 
-						if sd.RC {
-							strand = '-'
-						} else {
-							strand = '+'
-						}
-
-						fmt.Fprintf(outfh, "%s\t%d\t%d\t%s\t%s\t%.3f\t%d\t%.3f\t%d\t%.3f\t%d\t%d\t%d\t%d\t%d\t%c\t%d",
-							queryID, len(q.seq),
-							targets, r.ID, sd.SeqID, r.AlignedFraction,
-							j, c.AlignedFraction, c.AlignedLength, c.PIdent, c.Gaps,
-							c.QBegin+1, c.QEnd+1,
-							c.TBegin+1, c.TEnd+1,
-							strand, sd.SeqLen,
-						)
-						if moreColumns {
-							if onlyPseudoAlign {
-								fmt.Fprintf(outfh, "\t%s\t%s\t%s\t%s", c.CIGAR, q.seq[c.QBegin:c.QEnd+1], c.TSeq, c.Alignment)
-							} else {
-								fmt.Fprintf(outfh, "\t%s\t%s\t%s\t%s", c.CIGAR, c.QSeq, c.TSeq, c.Alignment)
-							}
-						}
-
-						fmt.Fprintln(outfh)
+						printHSP(queryID, q.seq, targets, r, sd, c, j)
 
 						j++
 					}
@@ -393,11 +865,47 @@ Result ordering:
 
 		// outputter
 		ch := make(chan *Query, maxQueryConcurrency)
+
+		// for --keep-order, the number of queries read but not outputted yet is limited,
+		// so the memory of buffered results does not grow when a query takes much longer time than others.
+		keepOrderBufferSize := max(keepOrderBufferMinSize, maxQueryConcurrency*keepOrderBufferFactor)
+		var orderTokens chan int
+		if keepOrder {
+			orderTokens = make(chan int, keepOrderBufferSize)
+		}
 		done := make(chan int)
 		go func() {
+			if !keepOrder {
+				for r := range ch {
+					printResult(r)
+				}
+				done <- 1
+				return
+			}
 
+			// queries are outputted in the order of input, those finished earlier are buffered.
+			// The size of buffer is limited by orderTokens.
+			var id uint64
+			buffer := make(map[uint64]*Query, keepOrderBufferSize)
+			var ok bool
 			for r := range ch {
+				if r.id != id {
+					buffer[r.id] = r
+					continue
+				}
 				printResult(r)
+				<-orderTokens
+				id++
+
+				for {
+					if r, ok = buffer[id]; !ok {
+						break
+					}
+					delete(buffer, id)
+					printResult(r)
+					<-orderTokens
+					id++
+				}
 			}
 
 			done <- 1
@@ -409,7 +917,7 @@ Result ordering:
 		var record *fastx.Record
 		K := idx.k
 
-		idx.SetSeqCompareOptions(&SeqComparatorOptions{
+		scOpt := &SeqComparatorOptions{
 			K:         uint8(K),
 			MinPrefix: 11, // can not be too small, or there will be a large number of anchors.
 
@@ -428,14 +936,113 @@ Result ordering:
 
 			MinAlignedFraction: minQcovChain,
 			MinIdentity:        minIdent,
-		})
 
-		for _, file := range files {
-			fastxReader, err := fastx.NewReader(nil, file, "")
+			WFAPenalties:      wfaPenalties,
+			WFAAdaptive:       wfaAdaptive,
+			WFAAdaptiveOption: wfaAdaptiveOption,
+		}
+		checkError(CheckSeqComparatorOptions(scOpt))
+		idx.SetSeqCompareOptions(scOpt)
+
+		// searchQuery searches a query, and records the number of masked bases
+		// if low-complexity masking is enabled.
+		searchQuery := func(q *Query) (*[]*SearchResult, error) {
+			if !queryDust {
+				return idx.Search(q.seq)
+			}
+			regions := idx.QueryLowComplexityRegions(q.seq)
+			q.masked = util.RegionsLength(*regions)
+			result, err := idx.SearchWithSkipRegions(q.seq, *regions)
+			idx.RecycleQueryLowComplexityRegions(regions)
+			return result, err
+		}
+
+		var queryID uint64 // for keeping the order of queries
+
+		// searchPairs searches paired-end reads from two files in lockstep.
+		searchPairs := func(file1, file2 string) {
+			fastxReader1, err := fastx.NewReader(nil, file1, "")
+			checkError(err)
+			fastxReader2, err := fastx.NewReader(nil, file2, "")
 			checkError(err)
 
+			var record1, record2 *fastx.Record
+			var err1, err2 error
+			for {
+				record1, err1 = fastxReader1.Read()
+				record2, err2 = fastxReader2.Read()
+				if err1 == io.EOF && err2 == io.EOF {
+					break
+				}
+				if err1 == io.EOF || err2 == io.EOF {
+					checkError(fmt.Errorf("unequal numbers of reads in %s and %s", file1, file2))
+				}
+				checkError(err1)
+				checkError(err2)
+
+				q1 := poolQuery.Get().(*Query)
+				q1.Reset()
+				q2 := poolQuery.Get().(*Query)
+				q2.Reset()
+				q1.mate = q2
+				q1.id = queryID
+				queryID++
+				if keepOrder {
+					orderTokens <- 1
+				}
+				if binner != nil {
+					q1.record = FormatRecord(record1, fastxReader1.IsFastq)
+					q2.record = FormatRecord(record2, fastxReader2.IsFastq)
+				}
+
+				q1.seqID = append(q1.seqID, record1.ID...)
+				q2.seqID = append(q2.seqID, record2.ID...)
+				if bytes.Equal(q1.seqID, q2.seqID) { // to distinguish the two mates in the output
+					q1.seqID = append(q1.seqID, "/1"...)
+					q2.seqID = append(q2.seqID, "/2"...)
+				}
+
+				if len(record1.Seq.Seq) < K || len(record2.Seq.Seq) < K {
+					ch <- q1
+					continue
+				}
+
+				q1.seq = append(q1.seq, bytes.ToUpper(record1.Seq.Seq)...)
+				q2.seq = append(q2.seq, bytes.ToUpper(record2.Seq.Seq)...)
+
+				tokens <- 1
+				wg.Add(1)
+
+				go func(q1 *Query) {
+					defer func() {
+						<-tokens
+						wg.Done()
+					}()
+
+					var err error
+					q2 := q1.mate
+					q1.result, err = searchQuery(q1)
+					checkError(err)
+					if q1.result != nil {
+						q2.result, err = searchQuery(q2)
+						checkError(err)
+					}
+					q1.pairs = PairMates(q1.result, q2.result, pairingOpt)
+
+					ch <- q1
+				}(q1)
+			}
+			fastxReader1.Close()
+			fastxReader2.Close()
+		}
+
+		// searchRecords searches sequences from a FASTA/Q reader or a genome in the index.
+		searchRecords := func(rdr interface {
+			Read() (*fastx.Record, error)
+		}, isFastq bool) {
+			var err error
 			for {
-				record, err = fastxReader.Read()
+				record, err = rdr.Read()
 				if err != nil {
 					if err == io.EOF {
 						break
@@ -446,6 +1053,14 @@ Result ordering:
 
 				query := poolQuery.Get().(*Query)
 				query.Reset()
+				query.id = queryID
+				queryID++
+				if keepOrder {
+					orderTokens <- 1
+				}
+				if binner != nil {
+					query.record = FormatRecord(record, isFastq)
+				}
 
 				if len(record.Seq.Seq) < K {
 					query.result = nil
@@ -466,20 +1081,49 @@ Result ordering:
 					}()
 
 					var err error
-					query.result, err = idx.Search(query.seq)
+					query.result, err = searchQuery(query)
 					if err != nil {
 						checkError(err)
 					}
 
+					if longRead && query.result != nil {
+						for _, r := range *query.result {
+							AnnotateSegments(r, &longReadOpt)
+						}
+					}
+
 					ch <- query
 				}(query)
 			}
-			fastxReader.Close()
+		}
+
+		if paired {
+			searchPairs(files[0], files[1])
+		} else if qGenome != nil {
+			searchRecords(&indexRecordReader{g: qGenome}, false)
+		} else {
+			for _, file := range files {
+				fastxReader, err := fastx.NewReader(nil, file, "")
+				checkError(err)
+				searchRecords(fastxReader, fastxReader.IsFastq)
+				fastxReader.Close()
+			}
 		}
 		wg.Wait()
 		close(ch)
 		<-done
 
+		if qgSummary != nil {
+			sfh, sgw, sw, err := outStream(queryGenomeSummaryFile, strings.HasSuffix(queryGenomeSummaryFile, ".gz"), opt.CompressionLevel)
+			checkError(err)
+			qgSummary.Write(sfh)
+			sfh.Flush()
+			if sgw != nil {
+				sgw.Close()
+			}
+			sw.Close()
+		}
+
 		if outputLog {
 			fmt.Fprintf(os.Stderr, "\n")
 
@@ -487,13 +1131,29 @@ Result ordering:
 			log.Infof("")
 			log.Infof("processed queries: %d, speed: %.3f queries per minute\n", total, speed)
 			log.Infof("%.4f%% (%d/%d) queries matched", float64(matched)/float64(total)*100, matched, total)
+			if queryDust {
+				log.Infof("low-complexity regions masked in %d queries, %d bases in total", maskedQueries, maskedBases)
+			}
+			if maxSeedOcc > 0 {
+				log.Infof("%d matched seeds with > %d occurrences dropped", idx.DroppedSeeds(), maxSeedOcc)
+			}
 			log.Infof("done searching")
 			if outFile != "-" {
 				log.Infof("search results saved to: %s", outFile)
 			}
+			if qgSummary != nil {
+				log.Infof("genome-level summary saved to: %s", queryGenomeSummaryFile)
+			}
 
 		}
 
+		if binner != nil {
+			binner.Close()
+			if outputLog {
+				log.Infof("%d of %d reads binned to genomes, saved to: %s", binner.Mapped, binner.Reads, binDir)
+			}
+		}
+
 		checkError(idx.Close())
 	},
 }
@@ -513,6 +1173,35 @@ func init() {
 	mapCmd.Flags().BoolP("all", "a", false,
 		formatFlagUsage(`Output more columns, e.g., matched sequences. Use this if you want to output blast-style format with "lexicmap utils 2blast".`))
 
+	mapCmd.Flags().StringP("outfmt", "", "",
+		formatFlagUsage(`Output user-selected columns like BLAST tabular format (-outfmt 6), without the header line, `+
+			`e.g., "6 qseqid sseqid pident length mismatch gapopen qstart qend sstart send". `+
+			`A single "6" outputs the standard BLAST fields. Available fields are listed in "lexicmap search -h". `+
+			`Fields mismatch, gapopen, nident, cigar, qseq, sseq, and align switch on -a/--all.`))
+
+	mapCmd.Flags().BoolP("keep-order", "", false,
+		formatFlagUsage(`Output results in the same order as the input queries, e.g., for binning reads with "lexicmap utils bin". `+
+			`Results of queries finished earlier are buffered, with a limited buffer size (see the usage above).`))
+
+	mapCmd.Flags().StringP("bin-dir", "", "",
+		formatFlagUsage(`Bin reads into files of genomes they are mapped to in this directory, `+
+			`the same as the output of "lexicmap utils bin".`))
+
+	mapCmd.Flags().BoolP("paired", "", false,
+		formatFlagUsage(`Paired-end mode. Two files of read 1 and read 2 are needed, and only genomes where both mates are aligned with proper orientation and insert size are reported.`))
+
+	mapCmd.Flags().IntP("paired-min-insert", "", DefaultPairingOptions.MinInsertSize,
+		formatFlagUsage(`Minimum insert size of paired-end reads.`))
+
+	mapCmd.Flags().IntP("paired-max-insert", "", DefaultPairingOptions.MaxInsertSize,
+		formatFlagUsage(`Maximum insert size of paired-end reads.`))
+
+	mapCmd.Flags().BoolP("long-read", "", false,
+		formatFlagUsage(`Long-read mode. HSPs in each genome are grouped into a primary collinear chain and supplementary segments, with breakpoints between segments reported in 4 extra columns.`))
+
+	mapCmd.Flags().IntP("long-read-max-gap", "", DefaultLongReadOptions.MaxGap,
+		formatFlagUsage(`Maximum distance between two collinear HSPs in a subject sequence in the long-read mode, longer distances are treated as breakpoints.`))
+
 	mapCmd.Flags().IntP("max-query-conc", "J", 12,
 		formatFlagUsage(`Maximum number of concurrent queries. Bigger values do not improve the batch searching speed and consume much memory.`))
 
@@ -530,11 +1219,21 @@ func init() {
 	// mapCmd.Flags().IntP("seed-max-mismatch", "m", -1,
 	// 	formatFlagUsage(`Maximum mismatch between non-prefix regions of shared substrings.`))
 
+	mapCmd.Flags().BoolP("query-dust", "", false,
+		formatFlagUsage(`Mask low-complexity regions of queries with DUST before seeding. Masked regions are not used as seeds but are still aligned in extension.`))
+	mapCmd.Flags().IntP("query-dust-window", "", 64,
+		formatFlagUsage(`Window size of DUST for --query-dust.`))
+	mapCmd.Flags().Float64P("query-dust-threshold", "", 20,
+		formatFlagUsage(`Score threshold of DUST for --query-dust. Smaller values mask more regions.`))
+
 	mapCmd.Flags().IntP("seed-max-gap", "", 200,
 		formatFlagUsage(`Max gap in seed chaining.`))
 	mapCmd.Flags().IntP("seed-max-dist", "", 1000,
 		formatFlagUsage(`Max distance between seeds in seed chaining. It should be <= contig interval length in database.`))
 
+	mapCmd.Flags().IntP("max-seed-occ", "", 0,
+		formatFlagUsage(`Skip matched seeds (k-mers) with more than this number of occurrences in all genomes, i.e., hub seeds from rRNA genes, transposases, etc. 0 for no limit.`))
+
 	mapCmd.Flags().IntP("top-n-genomes", "n", 0,
 		formatFlagUsage(`Keep top N genome matches for a query (0 for all) in chaining phase. Value 1 is not recommended as the best chaining result does not always bring the best alignment, so it better be >= 5.`))
 
@@ -545,6 +1244,18 @@ func init() {
 	mapCmd.Flags().BoolP("pseudo-align", "", false,
 		formatFlagUsage(`Only perform pseudo alignment, alignment metrics, including qcovGnm, qcovSHP and pident, will be less accurate.`))
 
+	mapCmd.Flags().StringP("query-genome", "", "",
+		formatFlagUsage(`Search with all sequences of a genome in the index, rather than input files. See details in the usage.`))
+
+	mapCmd.Flags().BoolP("exclude-self", "", false,
+		formatFlagUsage(`Exclude hits in the query genome itself, for --query-genome. It's applied before keeping the top N genomes.`))
+
+	mapCmd.Flags().StringP("query-genome-summary", "", "",
+		formatFlagUsage(`Out file of the genome-level summary of alignments of all sequences of the query genome, for --query-genome.`))
+
+	mapCmd.Flags().BoolP("genome-hits-only", "", false,
+		formatFlagUsage(`Only report genome hits after seed chaining, with no alignment performed. Seed coverage, chaining score, and estimated qcovGnm are outputted for each genome. See details in the usage.`))
+
 	mapCmd.Flags().IntP("align-ext-len", "", 1000,
 		formatFlagUsage(`Extend length of upstream and downstream of seed regions, for extracting query and target sequences for alignment. It should be <= contig interval length in database.`))
 
@@ -557,6 +1268,21 @@ func init() {
 	mapCmd.Flags().IntP("align-min-match-len", "l", 50,
 		formatFlagUsage(`Minimum aligned length in a HSP segment.`))
 
+	// WFA alignment
+
+	mapCmd.Flags().IntP("wfa-mismatch", "", int(wfa.DefaultPenalties.Mismatch),
+		formatFlagUsage(`Mismatch penalty of WFA alignment.`))
+	mapCmd.Flags().IntP("wfa-gap-open", "", int(wfa.DefaultPenalties.GapOpen),
+		formatFlagUsage(`Gap opening penalty of WFA alignment.`))
+	mapCmd.Flags().IntP("wfa-gap-ext", "", int(wfa.DefaultPenalties.GapExt),
+		formatFlagUsage(`Gap extension penalty of WFA alignment.`))
+	mapCmd.Flags().BoolP("wfa-no-adaptive", "", false,
+		formatFlagUsage(`Disable the adaptive reduction heuristic of WFA, which is slower but more accurate for divergent sequences.`))
+	mapCmd.Flags().IntP("wfa-adaptive-min-wf-len", "", int(wfa.DefaultAdaptiveOption.MinWFLen),
+		formatFlagUsage(`Minimum wavefront length to trigger the adaptive reduction of WFA.`))
+	mapCmd.Flags().IntP("wfa-adaptive-max-dist-diff", "", int(wfa.DefaultAdaptiveOption.MaxDistDiff),
+		formatFlagUsage(`Maximum distance difference between wavefront diagonals in the adaptive reduction of WFA. Bigger values are more tolerant of divergent regions.`))
+
 	// general filtering thresholds
 
 	mapCmd.Flags().Float64P("align-min-match-pident", "i", 70,
@@ -568,6 +1294,27 @@ func init() {
 	mapCmd.Flags().Float64P("min-qcov-per-genome", "Q", 0,
 		formatFlagUsage(`Minimum query coverage (percentage) per genome.`))
 
+	mapCmd.Flags().Float64P("max-evalue", "e", 0,
+		formatFlagUsage(`Maximum E-value of a HSP segment (0 for no filtering).`))
+
+	// alignment score
+
+	mapCmd.Flags().IntP("score-match", "", DefaultScoringOptions.Match,
+		formatFlagUsage(`Reward of a match, for computing alignment score, bit score, and E-value.`))
+	mapCmd.Flags().IntP("score-mismatch", "", DefaultScoringOptions.Mismatch,
+		formatFlagUsage(`Penalty of a mismatch (positive value), for computing alignment score, bit score, and E-value.`))
+	mapCmd.Flags().IntP("score-gap-open", "", DefaultScoringOptions.GapOpen,
+		formatFlagUsage(`Cost of opening a gap, for computing alignment score, bit score, and E-value.`))
+	mapCmd.Flags().IntP("score-gap-ext", "", DefaultScoringOptions.GapExt,
+		formatFlagUsage(`Cost of extending a gap, for computing alignment score, bit score, and E-value.`))
+	mapCmd.Flags().Float64P("score-lambda", "", 0,
+		formatFlagUsage(`Karlin-Altschul parameter λ for the scoring scheme (0 for using built-in values of supported scoring schemes).`))
+	mapCmd.Flags().Float64P("score-k", "", 0,
+		formatFlagUsage(`Karlin-Altschul parameter K for the scoring scheme (0 for using built-in values of supported scoring schemes).`))
+
+	mapCmd.Flags().BoolP("sort-by-bitscore", "", false,
+		formatFlagUsage(`Sort alignments by bit score, rather than qcovHSP*pident.`))
+
 	mapCmd.SetUsageTemplate(usageTemplate("-d <index path> [query.fasta.gz ...] [-o query.tsv.gz]"))
 
 }
@@ -577,21 +1324,57 @@ var Strands = [2]byte{'+', '-'}
 
 // Query is an object for each query sequence, it also contains the query result.
 type Query struct {
+	id     uint64 // for keeping the order of input
 	seqID  []byte
 	seq    []byte
+	record []byte // the formatted FASTA/Q record, for binning reads
 	result *[]*SearchResult
+	masked int // number of bases in low-complexity regions
+
+	// for paired-end reads, the query is read 1
+	mate  *Query      // read 2
+	pairs []*MatePair // best pairs of HSPs in genomes
 }
 
 // Reset reset the data for next round of using
 func (q *Query) Reset() {
+	q.id = 0
 	q.seqID = q.seqID[:0]
 	q.seq = q.seq[:0]
+	q.record = nil
 	q.result = nil
+	q.masked = 0
+	q.mate = nil
+	q.pairs = nil
 }
 
+// With --keep-order, at most max(keepOrderBufferMinSize, keepOrderBufferFactor * --max-query-conc)
+// queries are read but not outputted yet.
+const (
+	keepOrderBufferMinSize = 1024
+	keepOrderBufferFactor  = 16
+)
+
 var poolQuery = &sync.Pool{New: func() interface{} {
 	return &Query{
 		seqID: make([]byte, 0, 128),     // the id should be not too long
 		seq:   make([]byte, 0, 100<<10), // initialize with 100K
 	}
 }}
+
+// formatBreakpointSubject formats the subject positions of a breakpoint, with 1-based positions.
+func formatBreakpointSubject(b *Breakpoint) string {
+	return fmt.Sprintf("%s:%d>%s:%d", b.PreSeqID, b.PrePos+1, b.NextSeqID, b.NextPos+1)
+}
+
+// formatBreakpointStrand formats strands of the two segments of a breakpoint.
+func formatBreakpointStrand(b *Breakpoint) string {
+	s := []byte{'+', '>', '+'}
+	if b.PreRC {
+		s[0] = '-'
+	}
+	if b.NextRC {
+		s[2] = '-'
+	}
+	return string(s)
+}
@@ -21,6 +21,7 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
 	"path/filepath"
 	"regexp"
@@ -29,6 +30,7 @@ import (
 
 	"github.com/shenwei356/LexicMap/lexicmap/cmd/genome"
 	"github.com/shenwei356/bio/seq"
+	"github.com/shenwei356/xopen"
 	"github.com/spf13/cobra"
 )
 
@@ -41,7 +43,17 @@ Attention:
   1. The option -s/--seq-id is optional.
      1) If given, the positions are these in the original sequence.
      2) If not given, the positions are these in the concatenated sequence.
-  2. All degenerate bases in reference genomes were converted to the lexicographic first bases.
+  2. Multiple regions can be extracted with a BED-like file (-f/--region-file), with
+     tab-delimited columns:
+       genome, seqid, start, end, strand (optional), name (optional)
+     1) Positions are 0-based and half-open like BED by default, or 1-based and closed with --one-based.
+     2) An empty seqid or "." means positions in the concatenated sequence.
+     3) Strand is "+", "-", or ".". Name, if given, is used as the sequence ID in output.
+     4) Comment lines starting with "#", and header lines starting with "track" or "browser"
+        followed by a space or tab, are ignored.
+     Sequences are extracted and outputted one by one in the input order, and each genome data
+     file is opened only once.
+  3. All degenerate bases in reference genomes were converted to the lexicographic first bases.
      E.g., N was converted to A. Therefore, consecutive A's in output might be N's in the genomes.
 
 `,
@@ -56,9 +68,56 @@ Attention:
 			checkError(fmt.Errorf("flag -d/--index needed"))
 		}
 
+		lineWidth := getFlagNonNegativeInt(cmd, "line-width")
+		outFile := getFlagString(cmd, "out-file")
+
+		regionFile := getFlagString(cmd, "region-file")
+		if regionFile != "" {
+			regions, err := readSubseqRegions(regionFile, getFlagBool(cmd, "one-based"))
+			checkError(err)
+
+			outfh, gw, w, err := outStream(outFile, strings.HasSuffix(outFile, ".gz"), opt.CompressionLevel)
+			checkError(err)
+			defer func() {
+				outfh.Flush()
+				if gw != nil {
+					gw.Close()
+				}
+				w.Close()
+			}()
+
+			extractor, err := newSubseqExtractor(dbDir)
+			checkError(err)
+			defer func() {
+				checkError(extractor.Close())
+			}()
+
+			var sq []byte // reused for all regions
+			for _, r := range regions {
+				r.seq = sq[:0]
+				checkError(extractor.Extract(r))
+				sq = r.seq
+
+				s, err := seq.NewSeq(seq.DNAredundant, r.seq)
+				checkError(err)
+				if r.revcom {
+					s.RevComInplace()
+				}
+				if r.name != "" {
+					fmt.Fprintf(outfh, ">%s %s\n", r.name, r)
+				} else {
+					fmt.Fprintf(outfh, ">%s\n", r)
+				}
+				outfh.Write(s.FormatSeq(lineWidth))
+				outfh.WriteByte('\n')
+				r.seq = nil
+			}
+			return
+		}
+
 		refname := getFlagString(cmd, "ref-name")
 		if refname == "" {
-			checkError(fmt.Errorf("flag -n/--ref-name needed"))
+			checkError(fmt.Errorf("flag -n/--ref-name or -f/--region-file needed"))
 		}
 
 		seqid := getFlagString(cmd, "seq-id")
@@ -75,8 +134,6 @@ Attention:
 		}
 		revcom := getFlagBool(cmd, "revcom")
 
-		lineWidth := getFlagNonNegativeInt(cmd, "line-width")
-
 		if !reRegion.MatchString(region) {
 			checkError(fmt.Errorf(`invalid region: %s. type "lexicmap utils subseq -h" for more examples`, region))
 		}
@@ -95,8 +152,6 @@ Attention:
 			checkError(fmt.Errorf("begin position should be < end position"))
 		}
 
-		outFile := getFlagString(cmd, "out-file")
-
 		// ---------------------------------------------------------------
 
 		// genomes.map file for mapping index to genome id
@@ -202,5 +257,188 @@ func init() {
 	subseqCmd.Flags().IntP("line-width", "w", 60,
 		formatFlagUsage("Line width of sequence (0 for no wrap)."))
 
+	subseqCmd.Flags().StringP("region-file", "f", "",
+		formatFlagUsage(`A BED-like file of regions to extract, with columns of genome, seqid, start, end, strand (optional), and name (optional).`))
+
+	subseqCmd.Flags().BoolP("one-based", "", false,
+		formatFlagUsage(`Positions in the region file (-f/--region-file) are 1-based and closed, rather than 0-based and half-open like BED.`))
+
 	subseqCmd.SetUsageTemplate(usageTemplate(""))
 }
+
+// subseqRegion is a region to extract in a genome.
+type subseqRegion struct {
+	genome string
+	seqid  string // empty for positions in the concatenated sequence
+	start  int    // 0-based
+	end    int    // 0-based, included
+	revcom bool
+	name   string
+
+	seq []byte
+}
+
+// String returns the region in the format of seqid:start-end:strand, with 1-based positions.
+func (r *subseqRegion) String() string {
+	strand := "+"
+	if r.revcom {
+		strand = "-"
+	}
+	id := r.seqid
+	if id == "" {
+		id = r.genome
+	}
+	return fmt.Sprintf("%s:%d-%d:%s", id, r.start+1, r.end+1, strand)
+}
+
+// readSubseqRegions reads regions from a BED-like file.
+func readSubseqRegions(file string, oneBased bool) ([]*subseqRegion, error) {
+	fh, err := xopen.Ropen(file)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	regions := make([]*subseqRegion, 0, 1024)
+	items := make([]string, 6)
+	scanner := bufio.NewScanner(fh)
+	var line string
+	var n int
+	for scanner.Scan() {
+		n++
+		line = strings.TrimRight(scanner.Text(), "\r\n")
+		if line == "" || line[0] == '#' || isBEDHeaderLine(line) {
+			continue
+		}
+
+		items = items[:6]
+		stringSplitNByByte(line, '\t', 6, &items)
+		if len(items) < 4 {
+			return nil, fmt.Errorf("at least 4 columns needed in line %d: %s", n, line)
+		}
+
+		r := &subseqRegion{genome: items[0], seqid: items[1]}
+		if r.seqid == "." {
+			r.seqid = ""
+		}
+		if r.start, err = strconv.Atoi(items[2]); err != nil {
+			return nil, fmt.Errorf("invalid start position in line %d: %s", n, items[2])
+		}
+		if r.end, err = strconv.Atoi(items[3]); err != nil {
+			return nil, fmt.Errorf("invalid end position in line %d: %s", n, items[3])
+		}
+		if oneBased {
+			r.start--
+		}
+		r.end-- // 0-based and included
+		if r.start < 0 || r.end < r.start {
+			return nil, fmt.Errorf("invalid region in line %d: %s", n, line)
+		}
+		if len(items) > 4 {
+			switch items[4] {
+			case "-":
+				r.revcom = true
+			case "+", ".", "":
+			default:
+				return nil, fmt.Errorf("invalid strand in line %d: %s", n, items[4])
+			}
+		}
+		if len(items) > 5 {
+			r.name = items[5]
+		}
+
+		regions = append(regions, r)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return regions, nil
+}
+
+// isBEDHeaderLine tells if a line is a "track" or "browser" line of a BED file,
+// where the keyword is followed by a space or tab, so genomes like "track1" are not skipped.
+func isBEDHeaderLine(line string) bool {
+	for _, keyword := range []string{"track", "browser"} {
+		if strings.HasPrefix(line, keyword) &&
+			(len(line) == len(keyword) || line[len(keyword)] == ' ' || line[len(keyword)] == '\t') {
+			return true
+		}
+	}
+	return false
+}
+
+// subseqExtractor extracts sequences of regions one by one,
+// genome data files are opened once and kept open until Close is called.
+type subseqExtractor struct {
+	dbDir   string
+	m       map[string]*[]uint64 // genome id -> batch IDs and genome indexes
+	readers map[int]*genome.Reader
+}
+
+func newSubseqExtractor(dbDir string) (*subseqExtractor, error) {
+	// genomes.map file for mapping index to genome id
+	m, err := readGenomeMapName2Idx(filepath.Join(dbDir, FileGenomeIndex))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read genomes index mapping file: %s", err)
+	}
+	return &subseqExtractor{dbDir: dbDir, m: m, readers: make(map[int]*genome.Reader, 8)}, nil
+}
+
+// Extract extracts the sequence of a region, which is appended to r.seq.
+// For a big genome split into chunks, chunks are tried in order until the region is extracted.
+// The end position of the region is updated if it exceeds the sequence end.
+func (e *subseqExtractor) Extract(r *subseqRegion) error {
+	batchIDAndRefIDs, ok := e.m[r.genome]
+	if !ok {
+		return fmt.Errorf("reference name not found: %s", r.genome)
+	}
+
+	var tSeq *genome.Genome
+	var _end int
+	var err error
+	for _, batchIDAndRefID := range *batchIDAndRefIDs {
+		batch := int(batchIDAndRefID >> BITS_GENOME_IDX)
+		genomeIdx := int(batchIDAndRefID & MASK_GENOME_IDX)
+
+		rdr, ok := e.readers[batch]
+		if !ok {
+			fileGenome := filepath.Join(e.dbDir, DirGenomes, batchDir(batch), FileGenomes)
+			rdr, err = genome.NewReader(fileGenome)
+			if err != nil {
+				return fmt.Errorf("failed to read genome data file: %s", err)
+			}
+			e.readers[batch] = rdr
+		}
+
+		if r.seqid == "" {
+			tSeq, err = rdr.SubSeq(genomeIdx, r.start, r.end)
+		} else {
+			tSeq, _end, err = rdr.SubSeq2(genomeIdx, []byte(r.seqid), r.start, r.end)
+		}
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read subsequence of %s: %s", r, err)
+	}
+
+	if r.seqid == "" {
+		_end = r.start + len(tSeq.Seq) - 1
+	}
+	r.end = _end
+	r.seq = append(r.seq, tSeq.Seq...)
+	genome.RecycleGenome(tSeq)
+	return nil
+}
+
+// Close closes all genome data files.
+func (e *subseqExtractor) Close() error {
+	var err error
+	for _, rdr := range e.readers {
+		if _err := rdr.Close(); _err != nil && err == nil {
+			err = _err
+		}
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadSubseqRegions(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "regions.bed")
+	data := "# comment\n" +
+		"track name=hits\n" +
+		"browser\tposition chr1\n" +
+		"\n" +
+		"GCF_1\tNZ_1\t0\t100\n" +
+		"track1\t.\t10\t20\t-\tr2\n" +
+		"browserA\tseq\t5\t6\t+\n"
+	if err := os.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	regions, err := readSubseqRegions(file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []subseqRegion{
+		{genome: "GCF_1", seqid: "NZ_1", start: 0, end: 99},
+		{genome: "track1", seqid: "", start: 10, end: 19, revcom: true, name: "r2"},
+		{genome: "browserA", seqid: "seq", start: 5, end: 5},
+	}
+	if len(regions) != len(expected) {
+		t.Fatalf("%d regions expected, %d returned", len(expected), len(regions))
+	}
+	for i, r := range regions {
+		e := expected[i]
+		if r.genome != e.genome || r.seqid != e.seqid || r.start != e.start || r.end != e.end ||
+			r.revcom != e.revcom || r.name != e.name {
+			t.Errorf("region %d: expected %+v, returned %+v", i+1, e, *r)
+		}
+	}
+	if s := regions[1].String(); s != "track1:11-20:-" {
+		t.Errorf("unexpected region string: %s", s)
+	}
+
+	// 1-based positions
+	if err = os.WriteFile(file, []byte("GCF_1\tNZ_1\t1\t100\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	regions, err = readSubseqRegions(file, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if regions[0].start != 0 || regions[0].end != 99 {
+		t.Errorf("unexpected region of 1-based positions: %s", regions[0])
+	}
+
+	// invalid lines
+	for _, line := range []string{
+		"GCF_1\tNZ_1\t100\n",
+		"GCF_1\tNZ_1\ta\t100\n",
+		"GCF_1\tNZ_1\t100\t50\n",
+		"GCF_1\tNZ_1\t0\t50\tx\n",
+		"GCF_1\tNZ_1\t0\t1\n", // the start is 0 in 1-based positions
+	} {
+		if err = os.WriteFile(file, []byte(line), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = readSubseqRegions(file, true); err == nil {
+			t.Errorf("an error is expected for the line: %q", line)
+		}
+	}
+}
@@ -4,63 +4,65 @@ package cmd
 
 import (
 	"strconv"
+	"strings"
 )
 
 type SearchFields struct {
 	query, qseq, sgenome, sseqid, sseq, qcovGnm, hsp, qcovHSP, alenHSP, pident, sstr, cigar, align string
-	qlen, gaps, slen, qstart, qend, sstart, send, hits                                             int
+	qlen, gaps, slen, qstart, qend, sstart, send, hits, mapq                                       int
+	evalue, bitscore                                                                               float64
 }
 
-func SearchFromLine(line string, delimiter byte) SearchFields {
-	ncols := 21 // Length of header from other files
-	extra_blast_fields := 4
-	items := make([]string, ncols)
-	stringSplitNByByte(line, delimiter, ncols, &items)
-	qlen, _ := strconv.Atoi(items[1])
-	hits, _ := strconv.Atoi(items[2])
-	gaps, _ := strconv.Atoi(items[10])
-	qstart, _ := strconv.Atoi(items[11])
-	qend, _ := strconv.Atoi(items[12])
-	sstart, _ := strconv.Atoi(items[13])
-	send, _ := strconv.Atoi(items[14])
-	slen, _ := strconv.Atoi(items[16])
-
-	cigar := ""
-	qseq := ""
-	sseq := ""
-	align := ""
+// / Parse a line of the search result, with positions of columns (-1 for missing ones)
+// / detected from the header line (see parseSearchHeader).
+// / Fields of missing columns, e.g., evalue and bitscore in results of older versions, are left unset.
+func SearchFromLine(line string, delimiter byte, colIdx []int) SearchFields {
+	items := strings.Split(line, string(delimiter))
+	field := func(col int) string {
+		if colIdx[col] < 0 || colIdx[col] >= len(items) {
+			return ""
+		}
+		return items[colIdx[col]]
+	}
 
-	if len(items) > (ncols - extra_blast_fields) {
-		// TODO should work without extra blast fields.
-		cigar = items[17]
-		qseq = items[18]
-		sseq = items[19]
-		align = items[20]
+	qlen, _ := strconv.Atoi(field(colQlen))
+	hits, _ := strconv.Atoi(field(colHits))
+	gaps, _ := strconv.Atoi(field(colGaps))
+	qstart, _ := strconv.Atoi(field(colQstart))
+	qend, _ := strconv.Atoi(field(colQend))
+	sstart, _ := strconv.Atoi(field(colSstart))
+	send, _ := strconv.Atoi(field(colSend))
+	slen, _ := strconv.Atoi(field(colSlen))
 
-	}
+	evalue, _ := strconv.ParseFloat(field(colEvalue), 64)
+	bitscore, _ := strconv.ParseFloat(field(colBitscore), 64)
+	mapq, _ := strconv.Atoi(field(colMapq))
 
 	search_output := SearchFields{
-		query:   items[0],
-		qlen:    qlen,
-		hits:    hits,
-		sgenome: items[3],
-		sseqid:  items[4],
-		qcovGnm: items[5],
-		hsp:     items[6],
-		qcovHSP: items[7],
-		alenHSP: items[8],
-		pident:  items[9],
-		gaps:    gaps,
-		qstart:  qstart,
-		qend:    qend,
-		sstart:  sstart,
-		send:    send,
-		sstr:    items[15],
-		slen:    slen,
-		cigar:   cigar,
-		qseq:    qseq,
-		sseq:    sseq,
-		align:   align,
+		query:    field(colQuery),
+		qlen:     qlen,
+		hits:     hits,
+		sgenome:  field(colSgenome),
+		sseqid:   field(colSseqid),
+		qcovGnm:  field(colQcovGnm),
+		hsp:      field(colHSP),
+		qcovHSP:  field(colQcovHSP),
+		alenHSP:  field(colAlenHSP),
+		pident:   field(colPident),
+		gaps:     gaps,
+		qstart:   qstart,
+		qend:     qend,
+		sstart:   sstart,
+		send:     send,
+		sstr:     field(colSstr),
+		slen:     slen,
+		evalue:   evalue,
+		bitscore: bitscore,
+		mapq:     mapq,
+		cigar:    field(colCigar),
+		qseq:     field(colQseq),
+		sseq:     field(colSseq),
+		align:    field(colAlign),
 	}
 
 	return search_output
@@ -28,7 +28,10 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
 	gzip "github.com/klauspost/pgzip"
+	"github.com/ulikunitz/xz"
 )
 
 // BufferSize is size of buffer
@@ -129,3 +132,65 @@ func detectStdin() bool {
 	}
 	return (stat.Mode() & os.ModeCharDevice) == 0
 }
+
+// appendWriter is a buffered writer appending to a file, optionally compressed.
+type appendWriter struct {
+	*bufio.Writer
+	cw io.WriteCloser // the compression writer
+	fh *os.File
+}
+
+// Close flushes the data and closes the compression writer and the file.
+func (w *appendWriter) Close() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if w.cw != nil {
+		if err := w.cw.Close(); err != nil {
+			return err
+		}
+	}
+	return w.fh.Close()
+}
+
+// appendStream opens a file for appending, a new compressed stream is appended if the file
+// name ends with ".gz", ".xz", ".zst", or ".bz2". Unlike xopen.WopenFile, the compression
+// level is set for this file only, rather than the global xopen.Level.
+func appendStream(file string, level int) (*appendWriter, error) {
+	dir := filepath.Dir(file)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		os.MkdirAll(dir, 0755)
+	}
+	fh, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("fail to write %s: %s", file, err)
+	}
+
+	var cw io.WriteCloser
+	switch CompressionSuffix(file) {
+	case ".gz":
+		cw, err = gzip.NewWriterLevel(fh, level)
+	case ".xz":
+		cw, err = xz.NewWriter(fh)
+	case ".zst":
+		zlevel := zstd.SpeedDefault
+		if level > 0 {
+			zlevel = zstd.EncoderLevelFromZstd(level)
+		}
+		cw, err = zstd.NewWriter(fh, zstd.WithEncoderLevel(zlevel))
+	case ".bz2":
+		if level < bzip2.BestSpeed || level > bzip2.BestCompression {
+			level = bzip2.DefaultCompression
+		}
+		cw, err = bzip2.NewWriter(fh, &bzip2.WriterConfig{Level: level})
+	}
+	if err != nil {
+		fh.Close()
+		return nil, fmt.Errorf("fail to write %s: %s", file, err)
+	}
+
+	if cw != nil {
+		return &appendWriter{bufio.NewWriterSize(cw, BufferSize), cw, fh}, nil
+	}
+	return &appendWriter{bufio.NewWriterSize(fh, BufferSize), nil, fh}, nil
+}
@@ -0,0 +1,476 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// column indexes of the default output of "lexicmap search".
+const (
+	colQuery = iota
+	colQlen
+	colHits
+	colSgenome
+	colSseqid
+	colQcovGnm
+	colHSP
+	colQcovHSP
+	colAlenHSP
+	colPident
+	colGaps
+	colQstart
+	colQend
+	colSstart
+	colSend
+	colSstr
+	colSlen
+	colCigar // optional columns with -a/--all
+	colQseq
+	colSseq
+	colAlign
+	colSegment // optional columns with --long-read
+	colBkpQpos
+	colBkpSubject
+	colBkpStrand
+	colEvalue // columns appended at the end of each row
+	colBitscore
+	colMapq
+)
+
+// searchColumns are the column names of the default output of "lexicmap search".
+var searchColumns = []string{
+	"query", "qlen", "hits", "sgenome", "sseqid", "qcovGnm",
+	"hsp", "qcovHSP", "alenHSP", "pident", "gaps",
+	"qstart", "qend", "sstart", "send", "sstr", "slen",
+	"cigar", "qseq", "sseq", "align",
+	"segment", "bkpQpos", "bkpSubject", "bkpStrand",
+	"evalue", "bitscore", "mapq",
+}
+
+// numbers of columns without and with -a/--all, and all columns including those with --long-read
+// and those appended at the end.
+const (
+	nColsBasic = colCigar
+	nColsAll   = colAlign + 1
+	nColsTotal = colMapq + 1
+)
+
+// searchColumnsLongRead are the columns appended with --long-read.
+var searchColumnsLongRead = searchColumns[colSegment : colBkpStrand+1]
+
+// searchColumnsTail are the columns appended at the end of each row,
+// so the positions of other columns are the same as those of older versions.
+var searchColumnsTail = searchColumns[colEvalue:nColsTotal]
+
+// searchHeader returns the header line of the default output of "lexicmap search".
+func searchHeader(moreColumns bool, longRead bool) string {
+	cols := make([]string, 0, nColsTotal)
+	if moreColumns {
+		cols = append(cols, searchColumns[:nColsAll]...)
+	} else {
+		cols = append(cols, searchColumns[:nColsBasic]...)
+	}
+	if longRead {
+		cols = append(cols, searchColumnsLongRead...)
+	}
+	cols = append(cols, searchColumnsTail...)
+	return strings.Join(cols, "\t")
+}
+
+// searchColumnIndexes returns positions of all columns (-1 for missing ones) in a header line
+// of the default output of "lexicmap search".
+func searchColumnIndexes(header string) []int {
+	idx := make([]int, nColsTotal)
+	for i := range idx {
+		idx[i] = -1
+	}
+	pos := make(map[string]int, nColsTotal)
+	for i, name := range strings.Split(header, "\t") {
+		pos[name] = i
+	}
+	for i, name := range searchColumns {
+		if p, ok := pos[name]; ok {
+			idx[i] = p
+		}
+	}
+	return idx
+}
+
+// parseSearchHeader parses the first line of the output of "lexicmap search",
+// and returns positions of columns (-1 for missing ones), the number of columns,
+// and whether the line is a header line.
+// For output without the header line, basic columns are assumed to be in the default order.
+// An error is returned if any of the required columns is missing.
+func parseSearchHeader(line string, required ...int) (colIdx []int, nCols int, isHeader bool, err error) {
+	nCols = strings.Count(line, "\t") + 1
+	colIdx = searchColumnIndexes(line)
+	isHeader = colIdx[colQuery] == 0
+	if !isHeader {
+		for i := range colIdx {
+			if i < nColsBasic && i < nCols {
+				colIdx[i] = i
+			} else {
+				colIdx[i] = -1
+			}
+		}
+	}
+
+	missing := make([]string, 0, len(required))
+	var needAll bool
+	for _, i := range required {
+		if colIdx[i] < 0 {
+			missing = append(missing, searchColumns[i])
+			if i >= nColsBasic && i < nColsAll {
+				needAll = true
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	if !isHeader {
+		err = fmt.Errorf("required column(s) missing in the search result without a header line: %s",
+			strings.Join(missing, ", "))
+	} else if needAll {
+		err = fmt.Errorf(`required column(s) missing in the search result: %s. please run "lexicmap search" with -a/--all`,
+			strings.Join(missing, ", "))
+	} else {
+		err = fmt.Errorf("required column(s) missing in the search result: %s", strings.Join(missing, ", "))
+	}
+	return
+}
+
+// searchResultParser parses lines of the output of "lexicmap search",
+// with the positions of columns detected from the first line of each file.
+type searchResultParser struct {
+	required []int
+
+	file   string
+	first  bool
+	colIdx []int
+	nCols  int
+	fields []string
+}
+
+// newSearchResultParser creates a searchResultParser, which
+// checks if the required columns exist.
+func newSearchResultParser(required ...int) *searchResultParser {
+	return &searchResultParser{required: required, first: true}
+}
+
+// Reset should be called before parsing a new file.
+func (p *searchResultParser) Reset(file string) {
+	p.file = file
+	p.first = true
+}
+
+// Parse parses a line, and returns false for the header line.
+func (p *searchResultParser) Parse(line string) (bool, error) {
+	if p.first {
+		p.first = false
+
+		var isHeader bool
+		var err error
+		p.colIdx, p.nCols, isHeader, err = parseSearchHeader(line, p.required...)
+		if err != nil {
+			return false, fmt.Errorf("%s: %s", p.file, err)
+		}
+		if cap(p.fields) < p.nCols {
+			p.fields = make([]string, p.nCols)
+		}
+		if isHeader {
+			return false, nil
+		}
+	}
+
+	p.fields = p.fields[:p.nCols]
+	stringSplitNByByte(line, '\t', p.nCols, &p.fields)
+	if len(p.fields) < p.nCols {
+		return false, fmt.Errorf("%s: invalid search result with %d columns (%d expected): %s",
+			p.file, len(p.fields), p.nCols, line)
+	}
+	return true, nil
+}
+
+// Has tells if a column exists.
+func (p *searchResultParser) Has(col int) bool {
+	return p.colIdx[col] >= 0
+}
+
+// Field returns the value of a column in the last parsed line, "" for missing columns.
+func (p *searchResultParser) Field(col int) string {
+	if p.colIdx[col] < 0 {
+		return ""
+	}
+	return p.fields[p.colIdx[col]]
+}
+
+// blastOutFmtStd is the default field list of BLAST tabular format (-outfmt 6).
+var blastOutFmtStd = []string{"qseqid", "sseqid", "pident", "length", "mismatch", "gapopen",
+	"qstart", "qend", "sstart", "send", "evalue", "bitscore"}
+
+// outFmtField is a column of the user-defined tabular output.
+type outFmtField struct {
+	Name string
+	Desc string
+
+	// index of the column in the default output, -1 for fields computed from other columns.
+	col int
+	// for fields computed from other columns.
+	value func(items []string) string
+
+	// the field depends on the columns only available with -a/--all
+	NeedAll bool
+	// the field depends on the columns only available with --long-read
+	NeedLongRead bool
+}
+
+// Value returns the value of the field from a line of the default output.
+func (f *outFmtField) Value(items []string) string {
+	if f.col >= 0 {
+		if f.col >= len(items) {
+			return ""
+		}
+		return items[f.col]
+	}
+	return f.value(items)
+}
+
+var outFmtFields map[string]*outFmtField
+
+// outFmtFieldNames is used for listing available fields in order.
+var outFmtFieldNames []string
+
+func addOutFmtField(f *outFmtField) {
+	if _, ok := outFmtFields[f.Name]; !ok {
+		outFmtFieldNames = append(outFmtFieldNames, f.Name)
+	}
+	outFmtFields[f.Name] = f
+}
+
+func init() {
+	outFmtFields = make(map[string]*outFmtField, 64)
+	outFmtFieldNames = make([]string, 0, 64)
+
+	// BLAST-style fields
+	addOutFmtField(&outFmtField{Name: "qseqid", Desc: "Query sequence ID", col: colQuery})
+	addOutFmtField(&outFmtField{Name: "sseqid", Desc: "Subject sequence ID", col: colSseqid})
+	addOutFmtField(&outFmtField{Name: "pident", Desc: "Percentage of identical matches", col: colPident})
+	addOutFmtField(&outFmtField{Name: "length", Desc: "Alignment length", col: colAlenHSP})
+	addOutFmtField(&outFmtField{Name: "mismatch", Desc: "Number of mismatches", col: -1,
+		value: outFmtMismatch, NeedAll: true})
+	addOutFmtField(&outFmtField{Name: "gapopen", Desc: "Number of gap openings", col: -1,
+		value: outFmtGapOpen, NeedAll: true})
+	addOutFmtField(&outFmtField{Name: "nident", Desc: "Number of identical matches", col: -1,
+		value: outFmtNident, NeedAll: true})
+	addOutFmtField(&outFmtField{Name: "gaps", Desc: "Total number of gaps", col: colGaps})
+	addOutFmtField(&outFmtField{Name: "qstart", Desc: "Start of alignment in query", col: colQstart})
+	addOutFmtField(&outFmtField{Name: "qend", Desc: "End of alignment in query", col: colQend})
+	addOutFmtField(&outFmtField{Name: "sstart", Desc: "Start of alignment in subject (> send for the minus strand)", col: -1,
+		value: func(items []string) string {
+			if items[colSstr] == "-" {
+				return items[colSend]
+			}
+			return items[colSstart]
+		}})
+	addOutFmtField(&outFmtField{Name: "send", Desc: "End of alignment in subject (< sstart for the minus strand)", col: -1,
+		value: func(items []string) string {
+			if items[colSstr] == "-" {
+				return items[colSstart]
+			}
+			return items[colSend]
+		}})
+	addOutFmtField(&outFmtField{Name: "sstrand", Desc: "Subject strand (plus or minus)", col: -1,
+		value: func(items []string) string {
+			if items[colSstr] == "-" {
+				return "minus"
+			}
+			return "plus"
+		}})
+	addOutFmtField(&outFmtField{Name: "evalue", Desc: "Expect value", col: colEvalue})
+	addOutFmtField(&outFmtField{Name: "bitscore", Desc: "Bit score", col: colBitscore})
+	addOutFmtField(&outFmtField{Name: "qlen", Desc: "Query sequence length", col: colQlen})
+	addOutFmtField(&outFmtField{Name: "slen", Desc: "Subject sequence length", col: colSlen})
+	addOutFmtField(&outFmtField{Name: "qcovhsp", Desc: "Query coverage per HSP", col: colQcovHSP})
+	addOutFmtField(&outFmtField{Name: "qcovs", Desc: "Query coverage per subject genome", col: colQcovGnm})
+	addOutFmtField(&outFmtField{Name: "qseq", Desc: "Aligned part of query sequence", col: colQseq, NeedAll: true})
+	addOutFmtField(&outFmtField{Name: "sseq", Desc: "Aligned part of subject sequence", col: colSseq, NeedAll: true})
+
+	// LexicMap-specific fields, with the same names as the default output.
+	addOutFmtField(&outFmtField{Name: "query", Desc: "Query sequence ID", col: colQuery})
+	addOutFmtField(&outFmtField{Name: "hits", Desc: "Number of subject genomes", col: colHits})
+	addOutFmtField(&outFmtField{Name: "sgenome", Desc: "Subject genome ID", col: colSgenome})
+	addOutFmtField(&outFmtField{Name: "qcovGnm", Desc: "Query coverage per genome", col: colQcovGnm})
+	addOutFmtField(&outFmtField{Name: "hsp", Desc: "Nth HSP in the genome", col: colHSP})
+	addOutFmtField(&outFmtField{Name: "qcovHSP", Desc: "Query coverage per HSP", col: colQcovHSP})
+	addOutFmtField(&outFmtField{Name: "alenHSP", Desc: "Aligned length in the current HSP", col: colAlenHSP})
+	addOutFmtField(&outFmtField{Name: "sstr", Desc: "Subject strand (+ or -)", col: colSstr})
+	addOutFmtField(&outFmtField{Name: "mapq", Desc: "Mapping-quality style uniqueness score of the genome hit", col: colMapq})
+	addOutFmtField(&outFmtField{Name: "cigar", Desc: "CIGAR string of the alignment", col: colCigar, NeedAll: true})
+	addOutFmtField(&outFmtField{Name: "align", Desc: "Alignment text between qseq and sseq", col: colAlign, NeedAll: true})
+	addOutFmtField(&outFmtField{Name: "segment", Desc: "Segment type of long reads", col: colSegment, NeedLongRead: true})
+	addOutFmtField(&outFmtField{Name: "bkpQpos", Desc: "Query position of the breakpoint", col: colBkpQpos, NeedLongRead: true})
+	addOutFmtField(&outFmtField{Name: "bkpSubject", Desc: "Subject positions of the breakpoint", col: colBkpSubject, NeedLongRead: true})
+	addOutFmtField(&outFmtField{Name: "bkpStrand", Desc: "Strands of the two segments of the breakpoint", col: colBkpStrand, NeedLongRead: true})
+}
+
+// OutFmt is a list of user-selected output fields, similar to the BLAST tabular format.
+type OutFmt struct {
+	Fields []*outFmtField
+
+	// some fields depend on the columns only available with -a/--all
+	NeedAll bool
+	// some fields depend on the columns only available with --long-read
+	NeedLongRead bool
+}
+
+// ParseOutFmt parses a BLAST-like format string, e.g., "6 qseqid sseqid pident".
+// The leading "6" is optional, and fields can be separated by spaces or commas.
+// A single "6" means the standard BLAST fields.
+func ParseOutFmt(s string) (*OutFmt, error) {
+	names := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\t'
+	})
+	if len(names) == 0 {
+		return nil, fmt.Errorf("empty output format")
+	}
+
+	if _, err := strconv.Atoi(names[0]); err == nil {
+		if names[0] != "6" {
+			return nil, fmt.Errorf("only the tabular format (6) is supported, given: %s", names[0])
+		}
+		names = names[1:]
+		if len(names) == 0 {
+			names = blastOutFmtStd
+		}
+	}
+
+	outFmt := &OutFmt{Fields: make([]*outFmtField, 0, len(names))}
+	done := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		f, ok := outFmtFields[name]
+		if !ok {
+			return nil, outFmtUnknownFieldError(name)
+		}
+		if _, ok = done[name]; ok {
+			return nil, fmt.Errorf("duplicate output field: %s", name)
+		}
+		done[name] = struct{}{}
+		outFmt.Fields = append(outFmt.Fields, f)
+		if f.NeedAll {
+			outFmt.NeedAll = true
+		}
+		if f.NeedLongRead {
+			outFmt.NeedLongRead = true
+		}
+	}
+	return outFmt, nil
+}
+
+func outFmtUnknownFieldError(name string) error {
+	names := make([]string, len(outFmtFieldNames))
+	copy(names, outFmtFieldNames)
+	sort.Strings(names)
+	return fmt.Errorf("unknown output field: %s. available: %s", name, strings.Join(names, ", "))
+}
+
+// Write writes the selected fields of a line of the default output.
+func (f *OutFmt) Write(w *bufio.Writer, items []string) {
+	for i, field := range f.Fields {
+		if i > 0 {
+			w.WriteByte('\t')
+		}
+		w.WriteString(field.Value(items))
+	}
+	w.WriteByte('\n')
+}
+
+// formatEvalue formats an E-value with 3 significant digits.
+func formatEvalue(e float64) string {
+	return strconv.FormatFloat(e, 'g', 3, 64)
+}
+
+// cigarStats counts matches, mismatches, and gap openings from a CIGAR string
+// with the operations M (match), X (mismatch), I and D.
+func cigarStats(cigar string) (matches, mismatches, gapOpens int) {
+	var n int
+	for i := 0; i < len(cigar); i++ {
+		c := cigar[i]
+		if c >= '0' && c <= '9' {
+			n = n*10 + int(c-'0')
+			continue
+		}
+		switch c {
+		case 'M', '=':
+			matches += n
+		case 'X':
+			mismatches += n
+		case 'I', 'D':
+			gapOpens++
+		}
+		n = 0
+	}
+	return
+}
+
+// estimatedMatches computes the number of matches from pident and alignment length,
+// it's used when there's no CIGAR, e.g., in the pseudo-alignment mode.
+func estimatedMatches(items []string) int {
+	alen, _ := strconv.Atoi(items[colAlenHSP])
+	pident, _ := strconv.ParseFloat(items[colPident], 64)
+	return int(math.Round(pident * float64(alen) / 100))
+}
+
+// outFmtMismatch returns the number of mismatches. Without CIGAR, it's estimated
+// from the alignment length, with estimated matches and gap columns excluded.
+func outFmtMismatch(items []string) string {
+	if items[colCigar] == "" {
+		alen, _ := strconv.Atoi(items[colAlenHSP])
+		gaps, _ := strconv.Atoi(items[colGaps])
+		return strconv.Itoa(max(0, alen-estimatedMatches(items)-gaps))
+	}
+	_, mismatches, _ := cigarStats(items[colCigar])
+	return strconv.Itoa(mismatches)
+}
+
+// outFmtGapOpen returns the number of gap openings, which is not available without CIGAR,
+// as the gaps column only counts gap bases.
+func outFmtGapOpen(items []string) string {
+	if items[colCigar] == "" {
+		return "NA"
+	}
+	_, _, gapOpens := cigarStats(items[colCigar])
+	return strconv.Itoa(gapOpens)
+}
+
+func outFmtNident(items []string) string {
+	if items[colCigar] == "" {
+		return strconv.Itoa(estimatedMatches(items))
+	}
+	matches, _, _ := cigarStats(items[colCigar])
+	return strconv.Itoa(matches)
+}
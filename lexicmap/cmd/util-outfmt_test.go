@@ -0,0 +1,115 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOutFmt(t *testing.T) {
+	// the standard fields
+	for _, s := range []string{"6", " 6 "} {
+		f, err := ParseOutFmt(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(f.Fields) != len(blastOutFmtStd) {
+			t.Fatalf("%d fields expected, %d returned", len(blastOutFmtStd), len(f.Fields))
+		}
+		for i, field := range f.Fields {
+			if field.Name != blastOutFmtStd[i] {
+				t.Errorf("unexpected field %d: %s, expected: %s", i, field.Name, blastOutFmtStd[i])
+			}
+		}
+		if !f.NeedAll || f.NeedLongRead { // mismatch and gapopen need the CIGAR
+			t.Errorf("unexpected requirements of the standard fields: %v, %v", f.NeedAll, f.NeedLongRead)
+		}
+	}
+
+	// with or without the leading 6, separated by spaces or commas
+	for _, s := range []string{"6 qseqid sseqid pident", "qseqid,sseqid, pident"} {
+		f, err := ParseOutFmt(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(f.Fields) != 3 || f.Fields[2].Name != "pident" || f.NeedAll {
+			t.Errorf("unexpected fields of %q", s)
+		}
+	}
+
+	f, err := ParseOutFmt("qseqid bkpQpos")
+	if err != nil || !f.NeedLongRead {
+		t.Errorf("long-read columns needed: %v", err)
+	}
+
+	for s, msg := range map[string]string{
+		"":                       "empty",
+		"7 qseqid":               "only the tabular format",
+		"6 qseqid foo":           "unknown output field: foo",
+		"6 qseqid pident qseqid": "duplicate output field: qseqid",
+	} {
+		if _, err = ParseOutFmt(s); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("error with %q expected for %q, returned: %v", msg, s, err)
+		}
+	}
+}
+
+func TestCigarStats(t *testing.T) {
+	for cigar, expected := range map[string][3]int{
+		"":                 {0, 0, 0},
+		"100M":             {100, 0, 0},
+		"10=2X30=":         {40, 2, 0},
+		"20M1X5M3I10M2D4M": {39, 1, 2},
+		"5M10I10D5M":       {10, 0, 2},
+	} {
+		m, x, g := cigarStats(cigar)
+		if m != expected[0] || x != expected[1] || g != expected[2] {
+			t.Errorf("unexpected stats of %s: %d, %d, %d, expected: %v", cigar, m, x, g, expected)
+		}
+	}
+}
+
+func TestOutFmtWithoutCigar(t *testing.T) {
+	items := make([]string, nColsTotal)
+	items[colAlenHSP] = "100"
+	items[colPident] = "95.000"
+	items[colGaps] = "3"
+
+	// 95 matches, 3 gap columns, and 2 mismatches
+	if v := outFmtNident(items); v != "95" {
+		t.Errorf("unexpected nident: %s", v)
+	}
+	if v := outFmtMismatch(items); v != "2" {
+		t.Errorf("unexpected mismatch: %s", v)
+	}
+	if v := outFmtGapOpen(items); v != "NA" {
+		t.Errorf("unexpected gapopen: %s", v)
+	}
+
+	items[colCigar] = "20M1X5M3I71M"
+	if v := outFmtMismatch(items); v != "1" {
+		t.Errorf("unexpected mismatch: %s", v)
+	}
+	if v := outFmtGapOpen(items); v != "1" {
+		t.Errorf("unexpected gapopen: %s", v)
+	}
+}
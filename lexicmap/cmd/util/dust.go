@@ -0,0 +1,100 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+// dustBase maps bases to 2-bit codes, other bytes are -1.
+var dustBase [256]int8
+
+func init() {
+	for i := range dustBase {
+		dustBase[i] = -1
+	}
+	dustBase['A'], dustBase['a'] = 0, 0
+	dustBase['C'], dustBase['c'] = 1, 1
+	dustBase['G'], dustBase['g'] = 2, 2
+	dustBase['T'], dustBase['t'] = 3, 3
+	dustBase['U'], dustBase['u'] = 3, 3
+}
+
+// dustTriplet returns the code of the triplet starting at i, or -1 if it contains non-ACGT bases.
+func dustTriplet(s []byte, i int) int {
+	a, b, c := dustBase[s[i]], dustBase[s[i+1]], dustBase[s[i+2]]
+	if a < 0 || b < 0 || c < 0 {
+		return -1
+	}
+	return int(a)<<4 | int(b)<<2 | int(c)
+}
+
+// Dust finds low-complexity regions with the symmetric DUST algorithm.
+// A window of the sequence is masked if its triplet score
+// 10*sum(c_t*(c_t-1)/2)/(l-1) is larger than the threshold (20 in dustmasker),
+// where c_t is the count of triplet t and l is the number of triplets in the window.
+// Overlapping and adjacent windows are merged, and the 0-based closed intervals
+// are appended to regions in ascending order.
+// Sequences shorter than the window are scored as a whole.
+func Dust(s []byte, window int, threshold float64, regions *[][2]int) {
+	n := len(s)
+	if window > n {
+		window = n
+	}
+	if window < 4 {
+		return
+	}
+	l := window - 2 // number of triplets in a window
+	fl := float64(l-1) / 10
+
+	var counts [64]int
+	var sum, t int
+	start, end := -1, -1 // the current merged region
+	var ws, we int
+	for i := 0; i+2 < n; i++ {
+		// add the triplet at i
+		if t = dustTriplet(s, i); t >= 0 {
+			sum += counts[t]
+			counts[t]++
+		}
+		if i >= l { // remove the triplet leaving the window
+			if t = dustTriplet(s, i-l); t >= 0 {
+				counts[t]--
+				sum -= counts[t]
+			}
+		}
+		if i < l-1 { // the first window is not full yet
+			continue
+		}
+
+		if float64(sum)/fl <= threshold {
+			continue
+		}
+		ws, we = i-l+1, i+2
+		if start >= 0 && ws <= end+1 {
+			end = we
+			continue
+		}
+		if start >= 0 {
+			*regions = append(*regions, [2]int{start, end})
+		}
+		start, end = ws, we
+	}
+	if start >= 0 {
+		*regions = append(*regions, [2]int{start, end})
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDust(t *testing.T) {
+	random := []byte("ACGTTGCAAGCTAGCTAGGATCCGATCGTACGTAGCTAGCATCGGACTTAGCCATGCAGTCAGT" +
+		"GACTGCATGGCTAAGTCCGATGCTAGCTACGTACGATCGGATCCTAGCTAGCTTGCAACGTAGG")
+	poly := bytes.Repeat([]byte("A"), 100)
+	dinuc := bytes.Repeat([]byte("CA"), 50)
+
+	regions := make([][2]int, 0, 4)
+
+	Dust(random, 64, 20, &regions)
+	if len(regions) != 0 {
+		t.Errorf("unexpected low-complexity regions in a random sequence: %v", regions)
+	}
+
+	s := append(append(append([]byte{}, random...), poly...), random...)
+	regions = regions[:0]
+	Dust(s, 64, 20, &regions)
+	if len(regions) != 1 {
+		t.Fatalf("expected one region, got: %v", regions)
+	}
+	if regions[0][0] > len(random)+5 || regions[0][0] < len(random)-64 ||
+		regions[0][1] < len(random)+len(poly)-5 || regions[0][1] > len(random)+len(poly)+64 {
+		t.Errorf("unexpected region for a poly-A run at [%d, %d]: %v",
+			len(random), len(random)+len(poly)-1, regions)
+	}
+
+	regions = regions[:0]
+	Dust(dinuc, 64, 20, &regions)
+	if len(regions) != 1 || RegionsLength(regions) != len(dinuc) {
+		t.Errorf("a dinucleotide repeat should be fully masked: %v", regions)
+	}
+
+	// shorter than the window
+	regions = regions[:0]
+	Dust(poly[:30], 64, 20, &regions)
+	if len(regions) != 1 || RegionsLength(regions) != 30 {
+		t.Errorf("a short poly-A sequence should be fully masked: %v", regions)
+	}
+}
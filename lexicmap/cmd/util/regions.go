@@ -0,0 +1,78 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+import "sort"
+
+// RegionsLength returns the total length of 0-based closed intervals
+// that do not overlap with each other.
+func RegionsLength(regions [][2]int) (n int) {
+	for _, r := range regions {
+		n += r[1] - r[0] + 1
+	}
+	return n
+}
+
+// MergeRegions sorts 0-based closed intervals and merges overlapping and adjacent ones,
+// so that they can be used as skip regions in masking.
+func MergeRegions(regions *[][2]int) {
+	if len(*regions) < 2 {
+		return
+	}
+	sort.Slice(*regions, func(i, j int) bool {
+		return (*regions)[i][0] < (*regions)[j][0]
+	})
+
+	var j int // the last merged region
+	for i := 1; i < len(*regions); i++ {
+		r := (*regions)[i]
+		if r[0] <= (*regions)[j][1]+1 {
+			if r[1] > (*regions)[j][1] {
+				(*regions)[j][1] = r[1]
+			}
+			continue
+		}
+		j++
+		(*regions)[j] = r
+	}
+	*regions = (*regions)[:j+1]
+}
+
+// LowerCaseRegions finds runs of lowercase letters, e.g., soft-masked repeats,
+// and appends the 0-based closed intervals to regions in ascending order.
+func LowerCaseRegions(s []byte, regions *[][2]int) {
+	start := -1
+	for i, b := range s {
+		if 'a' <= b && b <= 'z' {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			*regions = append(*regions, [2]int{start, i - 1})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		*regions = append(*regions, [2]int{start, len(s) - 1})
+	}
+}
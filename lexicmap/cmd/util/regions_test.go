@@ -0,0 +1,51 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package util
+
+import "testing"
+
+func TestMergeRegions(t *testing.T) {
+	regions := [][2]int{{30, 40}, {0, 10}, {5, 8}, {11, 15}, {38, 50}, {60, 70}}
+	MergeRegions(&regions)
+	expected := [][2]int{{0, 15}, {30, 50}, {60, 70}}
+	if len(regions) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, regions)
+	}
+	for i, r := range expected {
+		if regions[i] != r {
+			t.Errorf("expected %v, got %v", expected, regions)
+		}
+	}
+}
+
+func TestLowerCaseRegions(t *testing.T) {
+	regions := make([][2]int, 0, 4)
+	LowerCaseRegions([]byte("acGTACgtaCGTa"), &regions)
+	expected := [][2]int{{0, 1}, {6, 8}, {12, 12}}
+	if len(regions) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, regions)
+	}
+	for i, r := range expected {
+		if regions[i] != r {
+			t.Errorf("expected %v, got %v", expected, regions)
+		}
+	}
+}
@@ -0,0 +1,453 @@
+// Copyright © 2023-2024 Wei Shen <shenwei356@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/shenwei356/xopen"
+	"github.com/spf13/cobra"
+)
+
+var variantsCmd = &cobra.Command{
+	Use:   "variants",
+	Short: "Call variants from alignments in search results",
+	Long: `Call variants from alignments in search results
+
+Input:
+   - Output of 'lexicmap search' with -a/--all, i.e., with the columns qseq and sseq.
+   - Only HSPs passing the filters (-i/--min-pident and -q/--min-qcov-hsp) are used.
+
+Method:
+   1. SNPs and indels are called from each HSP, relative to the query (default) or the subject
+      sequence (-r/--ref subject). For subject sequences, alignments on the negative strand are
+      reverse complemented, and positions of alignments across the origin of circular sequences are wrapped.
+   2. Indels are left-normalized, and contain the preceding base like VCF. Adjacent indels and
+      indels following a mismatch are merged into complex variants. Indels at the beginning of
+      alignments are skipped as there's no preceding base.
+   3. Each genome is treated as a haploid sample, and variants found in multiple HSPs of a genome are counted once.
+
+Output (VCF v4.2):
+   By default, each record is a variant in a genome, with INFO fields:
+     GENOME,  Genome ID.
+     AC,      Number of genomes with the alternative allele.
+     AN,      Number of genomes with alignments covering the position.
+     AF,      Allele frequency: AC/AN.
+   With -m/--multi-sample, each record is a position with all alternative alleles, and each genome is
+   a sample column with a haploid genotype: the index of the allele, 0 for the reference allele
+   (covered by alignments but without the variant), or "." (not covered). Alleles with different
+   reference alleles at the same position, e.g., a SNP and a deletion, are normalized to share the
+   longest reference allele, with the alternative alleles extended by the remaining reference bases.
+
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		opt := getOptions(cmd)
+		outputLog := opt.Verbose || opt.Log2File
+
+		outFile := getFlagString(cmd, "out-file")
+		multiSample := getFlagBool(cmd, "multi-sample")
+
+		refType := getFlagString(cmd, "ref")
+		var refSubject bool
+		switch refType {
+		case "query":
+		case "subject":
+			refSubject = true
+		default:
+			checkError(fmt.Errorf("invalid value of flag -r/--ref: %s. available values: query, subject", refType))
+		}
+
+		bufferSizeS := getFlagString(cmd, "buffer-size")
+		if bufferSizeS == "" {
+			checkError(fmt.Errorf("value of buffer size. supported unit: K, M, G"))
+		}
+		bufferSize, err := ParseByteSize(bufferSizeS)
+		if err != nil {
+			checkError(fmt.Errorf("invalid value of buffer size. supported unit: K, M, G"))
+		}
+
+		minPident := getFlagNonNegativeFloat64(cmd, "min-pident")
+		if minPident > 100 {
+			checkError(fmt.Errorf("the value of flag -i/--min-pident (%f) should be in range of [0, 100]", minPident))
+		}
+		minQcov := getFlagNonNegativeFloat64(cmd, "min-qcov-hsp")
+		if minQcov > 100 {
+			checkError(fmt.Errorf("the value of flag -q/--min-qcov-hsp (%f) should be in range of [0, 100]", minQcov))
+		}
+
+		// ---------------------------------------------------------------
+		// alignments
+
+		var chroms []string // reference sequences, in the order of appearance
+		var chromLens []int
+		chrom2idx := make(map[string]int, 128)
+		var genomes []string // samples, in the order of appearance
+		genome2idx := make(map[string]int, 1024)
+
+		// variant -> genomes
+		variants := make(map[variantKey]map[int]struct{}, 1024)
+		// aligned regions of each reference sequence
+		regions := make(map[int][]alignedRegion, 128)
+
+		files := getFileListFromArgsAndFile(cmd, args, true, "infile-list", true)
+
+		buf := make([]byte, bufferSize)
+		rp := newSearchResultParser(colQuery, colQlen, colSgenome, colSseqid, colQcovHSP, colPident,
+			colQstart, colQend, colSstart, colSend, colSstr, colSlen, colQseq, colSseq)
+		var vs []Variant
+		var qseq, sseq []byte
+		var nHSPs int
+
+		for _, file := range files {
+			fh, err := xopen.Ropen(file)
+			checkError(err)
+
+			rp.Reset(file)
+			scanner := bufio.NewScanner(fh)
+			scanner.Buffer(buf, int(bufferSize))
+			for scanner.Scan() {
+				line := strings.TrimRight(scanner.Text(), "\r\n")
+				if line == "" {
+					continue
+				}
+				ok, err := rp.Parse(line)
+				checkError(err)
+				if !ok {
+					continue
+				}
+
+				if minPident > 0 {
+					if v, _ := strconv.ParseFloat(rp.Field(colPident), 64); v < minPident {
+						continue
+					}
+				}
+				if minQcov > 0 {
+					if v, _ := strconv.ParseFloat(rp.Field(colQcovHSP), 64); v < minQcov {
+						continue
+					}
+				}
+
+				qseq = append(qseq[:0], rp.Field(colQseq)...)
+				sseq = append(sseq[:0], rp.Field(colSseq)...)
+				if len(qseq) != len(sseq) {
+					checkError(fmt.Errorf("unequal lengths of qseq and sseq: %s", line))
+				}
+
+				var chrom string
+				var chromLen, start, end int
+				if refSubject {
+					chrom = rp.Field(colSseqid)
+					chromLen, _ = strconv.Atoi(rp.Field(colSlen))
+					start, _ = strconv.Atoi(rp.Field(colSstart))
+					end, _ = strconv.Atoi(rp.Field(colSend))
+					if rp.Field(colSstr) == "-" {
+						reverseComplementAligned(qseq)
+						reverseComplementAligned(sseq)
+					}
+					qseq, sseq = sseq, qseq // the subject is the reference
+				} else {
+					chrom = rp.Field(colQuery)
+					chromLen, _ = strconv.Atoi(rp.Field(colQlen))
+					start, _ = strconv.Atoi(rp.Field(colQstart))
+					end, _ = strconv.Atoi(rp.Field(colQend))
+				}
+
+				c, ok := chrom2idx[chrom]
+				if !ok {
+					c = len(chroms)
+					chrom2idx[chrom] = c
+					chroms = append(chroms, chrom)
+					chromLens = append(chromLens, chromLen)
+				}
+				g, ok := genome2idx[rp.Field(colSgenome)]
+				if !ok {
+					g = len(genomes)
+					genome2idx[rp.Field(colSgenome)] = g
+					genomes = append(genomes, rp.Field(colSgenome))
+				}
+				regions[c] = append(regions[c], alignedRegion{genome: g, start: start, end: end})
+
+				LeftAlignGaps(qseq, sseq)
+				vs = vs[:0]
+				CallVariants(qseq, sseq, start, &vs)
+				for _, v := range vs {
+					// positions are converted before merging and sorting, so variants
+					// in wrapped and unwrapped alignments are the same.
+					key := variantKey{chrom: c, pos: unwrapPosition(v.Pos, chromLens[c]), ref: v.Ref, alt: v.Alt}
+					if _, ok = variants[key]; !ok {
+						variants[key] = make(map[int]struct{}, 8)
+					}
+					variants[key][g] = struct{}{}
+				}
+				nHSPs++
+			}
+			checkError(scanner.Err())
+			checkError(fh.Close())
+		}
+
+		if outputLog {
+			log.Infof("%d variants called from %d HSPs in %d genomes", len(variants), nHSPs, len(genomes))
+		}
+
+		// ---------------------------------------------------------------
+		// output
+
+		keys := make([]variantKey, 0, len(variants))
+		for key := range variants {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, b := keys[i], keys[j]
+			if a.chrom != b.chrom {
+				return a.chrom < b.chrom
+			}
+			if a.pos != b.pos {
+				return a.pos < b.pos
+			}
+			if a.ref != b.ref {
+				return a.ref < b.ref
+			}
+			return a.alt < b.alt
+		})
+
+		outfh, gw, w, err := outStream(outFile, strings.HasSuffix(outFile, ".gz"), opt.CompressionLevel)
+		checkError(err)
+		defer func() {
+			outfh.Flush()
+			if gw != nil {
+				gw.Close()
+			}
+			w.Close()
+		}()
+
+		fmt.Fprintln(outfh, "##fileformat=VCFv4.2")
+		fmt.Fprintf(outfh, "##source=LexicMap v%s\n", VERSION)
+		for i, chrom := range chroms {
+			fmt.Fprintf(outfh, "##contig=<ID=%s,length=%d>\n", chrom, chromLens[i])
+		}
+		if !multiSample {
+			fmt.Fprintln(outfh, `##INFO=<ID=GENOME,Number=1,Type=String,Description="Genome ID">`)
+		}
+		fmt.Fprintln(outfh, `##INFO=<ID=AC,Number=A,Type=Integer,Description="Number of genomes with the alternative allele">`)
+		fmt.Fprintln(outfh, `##INFO=<ID=AN,Number=1,Type=Integer,Description="Number of genomes with alignments covering the position">`)
+		fmt.Fprintln(outfh, `##INFO=<ID=AF,Number=A,Type=Float,Description="Allele frequency">`)
+		if multiSample {
+			fmt.Fprintln(outfh, `##FORMAT=<ID=GT,Number=1,Type=String,Description="Genotype">`)
+			fmt.Fprintf(outfh, "#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t%s\n", strings.Join(genomes, "\t"))
+		} else {
+			fmt.Fprintln(outfh, "#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO")
+		}
+
+		gts := make([]string, len(genomes))
+		var cov *siteCoverage
+		var an int
+		var alleles []Variant
+		var alts, acs, afs []string
+		var gsets []map[int]struct{}
+		alt2idx := make(map[string]int, 8)
+
+		for i := 0; i < len(keys); {
+			key := keys[i]
+			if i == 0 || keys[i-1].chrom != key.chrom {
+				cov = newSiteCoverage(regions[key.chrom], chromLens[key.chrom], len(genomes))
+			}
+			an = cov.At(key.pos)
+
+			if !multiSample {
+				ac := len(variants[key])
+				gs := make([]int, 0, ac)
+				for g := range variants[key] {
+					gs = append(gs, g)
+				}
+				sort.Ints(gs)
+				for _, g := range gs {
+					fmt.Fprintf(outfh, "%s\t%d\t.\t%s\t%s\t.\tPASS\tGENOME=%s;AC=%d;AN=%d;AF=%.4f\n",
+						chroms[key.chrom], key.pos, key.ref, key.alt, genomes[g], ac, an, float64(ac)/float64(an))
+				}
+				i++
+				continue
+			}
+
+			// all alleles at the position, which might have different reference alleles,
+			// e.g., a SNP and a deletion.
+			j := i + 1
+			for j < len(keys) && keys[j].chrom == key.chrom && keys[j].pos == key.pos {
+				j++
+			}
+			alleles = alleles[:0]
+			for k := i; k < j; k++ {
+				alleles = append(alleles, Variant{Pos: keys[k].pos, Ref: keys[k].ref, Alt: keys[k].alt})
+			}
+			ref := NormalizeAlleles(alleles)
+
+			// alleles identical after normalization are merged
+			clear(alt2idx)
+			alts, gsets = alts[:0], gsets[:0]
+			for k, v := range alleles {
+				a, ok := alt2idx[v.Alt]
+				if !ok {
+					a = len(alts)
+					alt2idx[v.Alt] = a
+					alts = append(alts, v.Alt)
+					gsets = append(gsets, make(map[int]struct{}, len(variants[keys[i+k]])))
+				}
+				for g := range variants[keys[i+k]] {
+					gsets[a][g] = struct{}{}
+				}
+			}
+
+			for g := range gts {
+				if cov.Covered(g) {
+					gts[g] = "0"
+				} else {
+					gts[g] = "."
+				}
+			}
+			acs, afs = acs[:0], afs[:0]
+			for a, gs := range gsets {
+				ac := len(gs)
+				acs = append(acs, strconv.Itoa(ac))
+				afs = append(afs, strconv.FormatFloat(float64(ac)/float64(an), 'f', 4, 64))
+				for g := range gs {
+					if gts[g] == "0" || gts[g] == "." {
+						gts[g] = strconv.Itoa(a + 1)
+					}
+				}
+			}
+			fmt.Fprintf(outfh, "%s\t%d\t.\t%s\t%s\t.\tPASS\tAC=%s;AN=%d;AF=%s\tGT\t%s\n",
+				chroms[key.chrom], key.pos, ref, strings.Join(alts, ","),
+				strings.Join(acs, ","), an, strings.Join(afs, ","), strings.Join(gts, "\t"))
+			i = j
+		}
+	},
+}
+
+// variantKey is a variant in a reference sequence.
+type variantKey struct {
+	chrom int
+	pos   int
+	ref   string
+	alt   string
+}
+
+// alignedRegion is a region of a reference sequence aligned with a genome, 1-based.
+type alignedRegion struct {
+	genome     int
+	start, end int
+}
+
+// unwrapPosition converts a position beyond the sequence length, i.e., on the next copy
+// of a circular sequence, to the real one.
+func unwrapPosition(pos, length int) int {
+	if length > 0 && pos > length {
+		return pos - length
+	}
+	return pos
+}
+
+// siteCoverage counts genomes with alignments covering positions of a reference sequence.
+// Like SeqCoverage, starts and ends of aligned regions are sorted, so positions queried
+// in ascending order are computed with a sweep.
+type siteCoverage struct {
+	starts []alignedRegion // sorted by start positions
+	ends   []alignedRegion // sorted by end positions
+	i, j   int
+
+	depth []int // number of regions of each genome covering the current position
+	n     int   // number of genomes covering the current position
+}
+
+// newSiteCoverage creates a siteCoverage from aligned regions of a reference sequence.
+// Regions beyond the sequence length (alignments across the origin of circular sequences)
+// are split into two parts.
+func newSiteCoverage(regions []alignedRegion, length int, nGenomes int) *siteCoverage {
+	starts := make([]alignedRegion, 0, len(regions)+8)
+	for _, r := range regions {
+		if length > 0 && r.end > length {
+			if r.start <= length {
+				starts = append(starts, alignedRegion{genome: r.genome, start: r.start, end: length})
+			}
+			r = alignedRegion{genome: r.genome, start: max(1, r.start-length), end: r.end - length}
+		}
+		starts = append(starts, r)
+	}
+	ends := make([]alignedRegion, len(starts))
+	copy(ends, starts)
+
+	sort.Slice(starts, func(i, j int) bool { return starts[i].start < starts[j].start })
+	sort.Slice(ends, func(i, j int) bool { return ends[i].end < ends[j].end })
+
+	return &siteCoverage{starts: starts, ends: ends, depth: make([]int, nGenomes)}
+}
+
+// At returns the number of genomes with alignments covering the position,
+// which should not be smaller than the previous one.
+func (c *siteCoverage) At(pos int) int {
+	var g int
+	for ; c.i < len(c.starts) && c.starts[c.i].start <= pos; c.i++ {
+		g = c.starts[c.i].genome
+		if c.depth[g] == 0 {
+			c.n++
+		}
+		c.depth[g]++
+	}
+	for ; c.j < len(c.ends) && c.ends[c.j].end < pos; c.j++ {
+		g = c.ends[c.j].genome
+		c.depth[g]--
+		if c.depth[g] == 0 {
+			c.n--
+		}
+	}
+	return c.n
+}
+
+// Covered tells if the genome has alignments covering the position of the last call of At.
+func (c *siteCoverage) Covered(genome int) bool {
+	return c.depth[genome] > 0
+}
+
+func init() {
+	utilsCmd.AddCommand(variantsCmd)
+
+	variantsCmd.Flags().StringP("out-file", "o", "-",
+		formatFlagUsage(`Out file in VCF format, supports a ".gz" suffix ("-" for stdout).`))
+
+	variantsCmd.Flags().StringP("ref", "r", "query",
+		formatFlagUsage(`Reference sequences of variants: query or subject.`))
+
+	variantsCmd.Flags().BoolP("multi-sample", "m", false,
+		formatFlagUsage(`Output a multi-sample VCF, with one sample column per genome.`))
+
+	variantsCmd.Flags().StringP("buffer-size", "b", "20M",
+		formatFlagUsage(`Size of buffer, supported unit: K, M, G. You need increase the value when "bufio.Scanner: token too long" error reported`))
+
+	variantsCmd.Flags().Float64P("min-pident", "i", 0,
+		formatFlagUsage(`Minimum percentage of identity of HSPs.`))
+
+	variantsCmd.Flags().Float64P("min-qcov-hsp", "q", 0,
+		formatFlagUsage(`Minimum query coverage (percentage) of HSPs.`))
+
+	variantsCmd.SetUsageTemplate(usageTemplate(""))
+}